@@ -1,7 +1,12 @@
 #!/usr/bin/env bash
 
+OLM_NAMESPACE="${OLM_NAMESPACE:-olm}"
+
 # Scale down OLM to avoid resources getting recreated
-kubectl scale deployment -n olm olm-operator --replicas 0
+kubectl scale deployment -n "${OLM_NAMESPACE}" olm-operator --replicas 0
 
 # Delete the APIService so that it does not block OLM uninstall
-kubectl delete apiservices.apiregistration.k8s.io v1.packages.operators.coreos.com
\ No newline at end of file
+# unless it is served by another OLM installation
+if [ "$(kubectl get apiservices.apiregistration.k8s.io v1.packages.operators.coreos.com -o jsonpath='{.spec.service.namespace}')" == "${OLM_NAMESPACE}" ]; then
+  kubectl delete apiservices.apiregistration.k8s.io v1.packages.operators.coreos.com
+fi
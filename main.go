@@ -5,13 +5,19 @@ import (
 	"embed"
 	"flag"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 
 	"open-cluster-management.io/addon-framework/pkg/addonmanager"
 	addonv1alpha1client "open-cluster-management.io/api/client/addon/clientset/versioned"
+	clusterv1client "open-cluster-management.io/api/client/cluster/clientset/versioned"
+	workv1client "open-cluster-management.io/api/client/work/clientset/versioned"
 
 	"github.com/stolostron/olm-addon/pkg/manager"
 )
@@ -24,7 +30,35 @@ const (
 var FS embed.FS
 
 func main() {
+	var versionPolicy, manifestSource, renderingMode, installRulesFile string
+	namespaces := manager.DefaultNamespaces()
+	sourceOpts := manager.ManifestSourceOptions{Embedded: FS}
 	klog.InitFlags(flag.CommandLine)
+	flag.StringVar(&versionPolicy, "version-policy", string(manager.NearestLowerPolicy),
+		"Manifest set selection for clusters with an unsupported Kubernetes version: nearest-lower, nearest-higher or refuse")
+	flag.StringVar(&renderingMode, "rendering-mode", string(manager.PatchRenderingMode),
+		"How the AddOnDeploymentConfig is applied to the manifests: patch or template")
+	flag.StringVar(&namespaces.OLM, "olm-namespace", namespaces.OLM,
+		"Namespace of the OLM components on the managed clusters")
+	flag.StringVar(&namespaces.Operators, "operators-namespace", namespaces.Operators,
+		"Namespace of the global operator group on the managed clusters")
+	probedCatalogs := strings.Join(manager.DefaultProbedCatalogSources, ",")
+	flag.StringVar(&probedCatalogs, "probed-catalog-sources", probedCatalogs,
+		"Comma-separated names of the CatalogSources of the OLM namespace whose connection state is reported in the ManagedClusterAddOn status")
+	flag.StringVar(&installRulesFile, "install-rules", "",
+		"YAML file of the rules deciding from their labels and ClusterClaims on which clusters OLM is deployed, OpenShift clusters are excluded by default")
+	flag.StringVar(&manifestSource, "manifest-source", "embedded",
+		"Source of the OLM manifests: embedded, dir:<path>, configmap:<namespace>/<name> or oci:<registry>/<repository>[:<tag>|@<digest>]")
+	flag.StringVar(&sourceOpts.Digest, "manifest-digest", "",
+		"Expected sha256 digest of the manifest archive retrieved from a ConfigMap or an OCI registry")
+	flag.StringVar(&sourceOpts.CacheDir, "manifest-cache-dir", filepath.Join(os.TempDir(), "olm-addon-manifests"),
+		"Directory where the manifest archives are extracted")
+	flag.BoolVar(&sourceOpts.InsecureRegistry, "manifest-registry-insecure", false,
+		"Pull the OCI manifest artifact over plain http")
+	flag.DurationVar(&sourceOpts.Timeout, "manifest-source-timeout", manager.DefaultManifestSourceTimeout,
+		"Maximum duration for retrieving the manifests from a ConfigMap or an OCI registry")
+	flag.DurationVar(&sourceOpts.RefreshInterval, "manifest-refresh-interval", manager.DefaultManifestRefreshInterval,
+		"Interval between two resolutions of the tag of an OCI manifest source")
 	flag.Parse()
 
 	klog.Info("starting ", addonName)
@@ -53,12 +87,48 @@ func main() {
 		klog.ErrorS(err, "unable to setup addon client")
 		os.Exit(1)
 	}
+	dynamicClient, err := dynamic.NewForConfig(kubeconfig)
+	if err != nil {
+		klog.ErrorS(err, "unable to setup dynamic client")
+		os.Exit(1)
+	}
 	addonMgr, err := addonmanager.New(kubeconfig)
 	if err != nil {
 		klog.ErrorS(err, "unable to setup addon manager")
 		os.Exit(1)
 	}
-	olmAgent, err := manager.NewOLMAgent(addonClient, addonName, FS)
+	sourceOpts.KubeClient, err = kubernetes.NewForConfig(kubeconfig)
+	if err != nil {
+		klog.ErrorS(err, "unable to setup kube client")
+		os.Exit(1)
+	}
+	source, err := manager.ParseManifestSource(manifestSource, sourceOpts)
+	if err != nil {
+		klog.ErrorS(err, "invalid manifest source")
+		os.Exit(1)
+	}
+	policy, err := manager.ParseVersionPolicy(versionPolicy)
+	if err != nil {
+		klog.ErrorS(err, "invalid version policy")
+		os.Exit(1)
+	}
+	mode, err := manager.ParseRenderingMode(renderingMode)
+	if err != nil {
+		klog.ErrorS(err, "invalid rendering mode")
+		os.Exit(1)
+	}
+	recorder, err := manager.NewEventRecorder(sourceOpts.KubeClient, addonName)
+	if err != nil {
+		klog.ErrorS(err, "unable to setup the event recorder")
+		os.Exit(1)
+	}
+	installRules, err := manager.LoadInstallRules(installRulesFile)
+	if err != nil {
+		klog.ErrorS(err, "invalid install rules")
+		os.Exit(1)
+	}
+	olmAgent, err := manager.NewOLMAgent(addonClient, dynamicClient, addonName, source, policy, mode, namespaces,
+		splitList(probedCatalogs), installRules, recorder)
 	if err != nil {
 		klog.ErrorS(err, "unable to create the olm agent")
 		os.Exit(1)
@@ -68,8 +138,26 @@ func main() {
 		klog.ErrorS(err, "unable to add addon agent to manager")
 		os.Exit(1)
 	}
+	workClient, err := workv1client.NewForConfig(kubeconfig)
+	if err != nil {
+		klog.ErrorS(err, "unable to setup work client")
+		os.Exit(1)
+	}
+
+	clusterClient, err := clusterv1client.NewForConfig(kubeconfig)
+	if err != nil {
+		klog.ErrorS(err, "unable to setup cluster client")
+		os.Exit(1)
+	}
 
 	ctx := context.Background()
+	go olmAgent.WatchManifests(ctx)
+	go manager.NewHealthController(&olmAgent, workClient).Run(ctx)
+	go manager.NewFleetSubscriptionController(dynamicClient, clusterClient, workClient, namespaces).Run(ctx)
+	go manager.NewInventoryController(&olmAgent, dynamicClient, workClient).Run(ctx)
+	go manager.NewRolloutController(&olmAgent, dynamicClient, clusterClient, workClient).Run(ctx)
+	go manager.NewRollbackController(&olmAgent, clusterClient, workClient, recorder).Run(ctx)
+	go manager.NewDriftController(&olmAgent, clusterClient, workClient).Run(ctx)
 	if err := addonMgr.Start(ctx); err != nil {
 		klog.ErrorS(err, "unable to start the addon manager")
 		os.Exit(1)
@@ -77,3 +165,14 @@ func main() {
 	<-ctx.Done()
 
 }
+
+// splitList returns the non-empty items of a comma-separated list.
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
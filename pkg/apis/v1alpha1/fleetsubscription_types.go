@@ -0,0 +1,118 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+)
+
+// FleetSubscription installs an operator with OLM on the managed clusters selected by a Placement.
+type FleetSubscription struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FleetSubscriptionSpec   `json:"spec"`
+	Status FleetSubscriptionStatus `json:"status,omitempty"`
+}
+
+// FleetSubscriptionSpec defines the Subscription and the OperatorGroup created on the managed clusters.
+type FleetSubscriptionSpec struct {
+	// Placement is the name of the Placement of the namespace selecting the managed clusters.
+	Placement string `json:"placement"`
+
+	// Package is the name of the operator package in the catalog.
+	Package string `json:"package"`
+
+	// Channel of the package, the default channel of the package when not set.
+	// +optional
+	Channel string `json:"channel,omitempty"`
+
+	// Source is the name of the CatalogSource providing the package.
+	Source string `json:"source"`
+
+	// SourceNamespace is the namespace of the CatalogSource, the OLM namespace when not set.
+	// +optional
+	SourceNamespace string `json:"sourceNamespace,omitempty"`
+
+	// StartingCSV is the version of the operator installed first.
+	// +optional
+	StartingCSV string `json:"startingCSV,omitempty"`
+
+	// InstallPlanApproval is Automatic, the default, or Manual.
+	// +optional
+	InstallPlanApproval olmv1alpha1.Approval `json:"installPlanApproval,omitempty"`
+
+	// Namespace of the managed clusters the operator is installed into.
+	Namespace string `json:"namespace"`
+
+	// TargetNamespaces are the namespaces watched by the operator, all namespaces when empty.
+	// They must be the same for all the FleetSubscriptions of the namespace, which share its OperatorGroup.
+	// Ignored when the operator is installed into the namespace of the global operator group.
+	// +optional
+	TargetNamespaces []string `json:"targetNamespaces,omitempty"`
+}
+
+// FleetSubscriptionStatus reports the installation of the operator on the managed clusters.
+type FleetSubscriptionStatus struct {
+	// Conditions of the FleetSubscription.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Clusters are the managed clusters selected by the Placement.
+	// +optional
+	Clusters []ClusterSubscriptionStatus `json:"clusters,omitempty"`
+}
+
+// ClusterSubscriptionStatus is the status of the operator on a managed cluster,
+// as reported by the ManifestWork deploying the Subscription.
+type ClusterSubscriptionStatus struct {
+	// Cluster is the name of the managed cluster.
+	Cluster string `json:"cluster"`
+
+	// State of the Subscription.
+	// +optional
+	State string `json:"state,omitempty"`
+
+	// InstalledCSV is the ClusterServiceVersion installed by the Subscription.
+	// +optional
+	InstalledCSV string `json:"installedCSV,omitempty"`
+
+	// Phase of the installed ClusterServiceVersion.
+	// +optional
+	Phase string `json:"phase,omitempty"`
+
+	// Reason of the phase of the installed ClusterServiceVersion.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Message describing the phase of the ClusterServiceVersion or why the ManifestWork could not be applied.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// PendingInstallPlan is the InstallPlan waiting for approval.
+	// +optional
+	PendingInstallPlan *PendingInstallPlan `json:"pendingInstallPlan,omitempty"`
+}
+
+// PendingInstallPlan is an InstallPlan of a managed cluster requiring a manual approval.
+// The steps of the InstallPlan, i.e. the resources it creates, are not reported and need to be reviewed on the cluster.
+type PendingInstallPlan struct {
+	// Name of the InstallPlan.
+	Name string `json:"name"`
+
+	// CSV is the ClusterServiceVersion the InstallPlan installs.
+	CSV string `json:"csv"`
+
+	// ClusterServiceVersionNames are all the ClusterServiceVersions the InstallPlan installs, including dependencies.
+	// Only reported when the work agent runs with the RawFeedbackJsonString feature gate.
+	// +optional
+	ClusterServiceVersionNames []string `json:"clusterServiceVersionNames,omitempty"`
+
+	// Phase of the InstallPlan.
+	// +optional
+	Phase string `json:"phase,omitempty"`
+
+	// Approved is true once the approval has been applied to the InstallPlan.
+	// +optional
+	Approved bool `json:"approved,omitempty"`
+}
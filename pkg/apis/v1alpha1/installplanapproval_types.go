@@ -0,0 +1,33 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InstallPlanApproval approves the pending InstallPlans of a FleetSubscription with manual approval
+// on a set of managed clusters.
+type InstallPlanApproval struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec InstallPlanApprovalSpec `json:"spec"`
+}
+
+// InstallPlanApprovalSpec selects the InstallPlans to approve.
+type InstallPlanApprovalSpec struct {
+	// FleetSubscription is the name of the FleetSubscription of the namespace whose InstallPlans are approved.
+	FleetSubscription string `json:"fleetSubscription"`
+
+	// CSV is the ClusterServiceVersion the approved InstallPlans install. InstallPlans installing
+	// another version are left pending.
+	CSV string `json:"csv"`
+
+	// Clusters are the names of the managed clusters on which the InstallPlans are approved.
+	// +optional
+	Clusters []string `json:"clusters,omitempty"`
+
+	// Placement is the name of a Placement of the namespace selecting additional managed clusters
+	// on which the InstallPlans are approved.
+	// +optional
+	Placement string `json:"placement,omitempty"`
+}
@@ -0,0 +1,89 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OperatorInventory lists the operators installed by OLM on a managed cluster.
+// It is maintained by the addon controller in the namespace of the cluster.
+// Only the packageserver ClusterServiceVersion and the ones installed by FleetSubscriptions are listed,
+// the operators installed on the cluster by other means are not.
+type OperatorInventory struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status OperatorInventoryStatus `json:"status,omitempty"`
+}
+
+// OperatorInventoryStatus contains the ClusterServiceVersions of a managed cluster.
+type OperatorInventoryStatus struct {
+	// Operators are sorted by namespace and name.
+	// +optional
+	Operators []InstalledOperator `json:"operators,omitempty"`
+}
+
+// InstalledOperator is a ClusterServiceVersion of a managed cluster.
+type InstalledOperator struct {
+	// Name of the ClusterServiceVersion.
+	Name string `json:"name"`
+
+	// Namespace of the ClusterServiceVersion.
+	Namespace string `json:"namespace"`
+
+	// Version of the operator.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Phase of the ClusterServiceVersion.
+	// +optional
+	Phase string `json:"phase,omitempty"`
+
+	// FleetSubscription is the namespace/name of the FleetSubscription which installed the operator,
+	// empty for OLM itself.
+	// +optional
+	FleetSubscription string `json:"fleetSubscription,omitempty"`
+}
+
+// FleetOperatorInventory aggregates the OperatorInventories of all the managed clusters.
+// It is maintained by the addon controller.
+// As the OperatorInventories, it only lists the packageserver ClusterServiceVersion and the ones installed
+// by FleetSubscriptions.
+type FleetOperatorInventory struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status FleetOperatorInventoryStatus `json:"status,omitempty"`
+}
+
+// FleetOperatorInventoryStatus contains the ClusterServiceVersions of the fleet.
+type FleetOperatorInventoryStatus struct {
+	// Operators are sorted by name and version.
+	// +optional
+	Operators []FleetOperator `json:"operators,omitempty"`
+}
+
+// FleetOperator is a ClusterServiceVersion installed on some managed clusters.
+type FleetOperator struct {
+	// Name of the ClusterServiceVersion.
+	Name string `json:"name"`
+
+	// Version of the operator.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Clusters the ClusterServiceVersion is installed on, sorted by name.
+	Clusters []ClusterOperator `json:"clusters"`
+}
+
+// ClusterOperator is the ClusterServiceVersion of a FleetOperator on a managed cluster.
+type ClusterOperator struct {
+	// Cluster is the name of the managed cluster.
+	Cluster string `json:"cluster"`
+
+	// Namespace of the ClusterServiceVersion.
+	Namespace string `json:"namespace"`
+
+	// Phase of the ClusterServiceVersion.
+	// +optional
+	Phase string `json:"phase,omitempty"`
+}
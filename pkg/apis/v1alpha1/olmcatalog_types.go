@@ -0,0 +1,57 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+)
+
+// OLMCatalog lists the catalogs made available by OLM on the managed clusters whose ManagedClusterAddOn
+// references it, directly or through the ClusterManagementAddOn.
+type OLMCatalog struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec OLMCatalogSpec `json:"spec"`
+}
+
+// OLMCatalogSpec defines the catalogs of the managed clusters.
+type OLMCatalogSpec struct {
+	// DisableDefaultCatalog removes the catalog of the manifests, operatorhubio-catalog, from the managed clusters.
+	// +optional
+	DisableDefaultCatalog bool `json:"disableDefaultCatalog,omitempty"`
+
+	// Catalogs are rendered into CatalogSources of the OLM namespace.
+	// A catalog with the name of the default catalog replaces it.
+	// +optional
+	Catalogs []Catalog `json:"catalogs,omitempty"`
+}
+
+// Catalog is a CatalogSource served from a registry image.
+type Catalog struct {
+	// Name of the CatalogSource.
+	Name string `json:"name"`
+
+	// Image of the catalog.
+	Image string `json:"image"`
+
+	// DisplayName is the name of the catalog shown to users.
+	// +optional
+	DisplayName string `json:"displayName,omitempty"`
+
+	// Publisher of the catalog.
+	// +optional
+	Publisher string `json:"publisher,omitempty"`
+
+	// PollInterval is the interval at which the image is checked for updates, not polled when not set.
+	// +optional
+	PollInterval *metav1.Duration `json:"pollInterval,omitempty"`
+
+	// Secrets are the names of the secrets of the OLM namespace used for pulling the image.
+	// +optional
+	Secrets []string `json:"secrets,omitempty"`
+
+	// GrpcPodConfig configures the pod serving the catalog, restricted security context when not set.
+	// +optional
+	GrpcPodConfig *olmv1alpha1.GrpcPodConfig `json:"grpcPodConfig,omitempty"`
+}
@@ -0,0 +1,84 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OLMRollout rolls out changes of the OLM images progressively to the managed clusters selected by a Placement.
+type OLMRollout struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OLMRolloutSpec   `json:"spec"`
+	Status OLMRolloutStatus `json:"status,omitempty"`
+}
+
+// OLMRolloutSpec defines the clusters of the rollout and how fast it progresses.
+type OLMRolloutSpec struct {
+	// Placement is the name of the Placement of the namespace selecting the managed clusters.
+	// The clusters are updated in the order of their names.
+	Placement string `json:"placement"`
+
+	// BatchSize is the number of clusters updated at the same time, 1 when not set.
+	// +optional
+	BatchSize int32 `json:"batchSize,omitempty"`
+
+	// MaxUnavailable is the number of updated clusters whose OLM components may fail the health check
+	// without halting the rollout.
+	// +optional
+	MaxUnavailable int32 `json:"maxUnavailable,omitempty"`
+
+	// HealthCheckDelay is the time given to the OLM components of an updated cluster to become healthy,
+	// 5 minutes when not set.
+	// +optional
+	HealthCheckDelay *metav1.Duration `json:"healthCheckDelay,omitempty"`
+
+	// Paused stops updating further clusters.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+}
+
+// OLMRolloutStatus reports the progress of the rollout.
+type OLMRolloutStatus struct {
+	// Conditions of the OLMRollout.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Clusters are the managed clusters selected by the Placement.
+	// +optional
+	Clusters []ClusterRolloutStatus `json:"clusters,omitempty"`
+}
+
+// ClusterRolloutStatus is the state of the rollout on a managed cluster.
+type ClusterRolloutStatus struct {
+	// Cluster is the name of the managed cluster.
+	Cluster string `json:"cluster"`
+
+	// State is Pending, Updating, Updated or Failed.
+	State string `json:"state"`
+
+	// Release are the OLM images deployed on the cluster.
+	// +optional
+	Release *OLMRelease `json:"release,omitempty"`
+
+	// Target are the OLM images the cluster is updated to when the rollout reaches it.
+	// +optional
+	Target *OLMRelease `json:"target,omitempty"`
+
+	// LastTransitionTime is the last time the state changed.
+	// +optional
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// Message describes the state, e.g. why the health check failed.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// OLMRelease are the images of the OLM components.
+type OLMRelease struct {
+	// OLM is the image of the OLM operators.
+	OLM string `json:"olm"`
+
+	// ConfigMapServer is the image serving the catalogs of ConfigMap CatalogSources.
+	ConfigMapServer string `json:"configMapServer"`
+}
@@ -0,0 +1,31 @@
+// Package v1alpha1 contains the hub resources configuring the olm-addon.
+// They are retrieved with the dynamic client and converted from their unstructured representation.
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupName is the API group of the olm-addon resources.
+const GroupName = "olm.addon.stolostron.io"
+
+// SchemeGroupVersion is the group version of the resources of this package.
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
+// OLMCatalogGVR identifies the OLMCatalog resources.
+var OLMCatalogGVR = SchemeGroupVersion.WithResource("olmcatalogs")
+
+// FleetSubscriptionGVR identifies the FleetSubscription resources.
+var FleetSubscriptionGVR = SchemeGroupVersion.WithResource("fleetsubscriptions")
+
+// OperatorInventoryGVR identifies the OperatorInventory resources.
+var OperatorInventoryGVR = SchemeGroupVersion.WithResource("operatorinventories")
+
+// FleetOperatorInventoryGVR identifies the FleetOperatorInventory resources.
+var FleetOperatorInventoryGVR = SchemeGroupVersion.WithResource("fleetoperatorinventories")
+
+// InstallPlanApprovalGVR identifies the InstallPlanApproval resources.
+var InstallPlanApprovalGVR = SchemeGroupVersion.WithResource("installplanapprovals")
+
+// OLMRolloutGVR identifies the OLMRollout resources.
+var OLMRolloutGVR = SchemeGroupVersion.WithResource("olmrollouts")
@@ -0,0 +1,141 @@
+package manager
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/version"
+
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+const (
+	// ExistingOLMClaim is the ClusterClaim reporting the version of an OLM installed on the managed cluster
+	// without the addon, e.g. with operator-sdk olm install.
+	ExistingOLMClaim = "version.olm.addon.stolostron.io"
+
+	// ConditionExistingOLM reports whether an OLM installed without the addon has been detected on the cluster.
+	ConditionExistingOLM = "ExistingOLM"
+
+	ReasonNoExistingOLM      = "NoExistingOLM"
+	ReasonExistingOLMAdopted = "ExistingOLMAdopted"
+	ReasonExistingOLMRefused = "ExistingOLMRefused"
+	ReasonExistingOLMNewer   = "ExistingOLMNewer"
+	ReasonInvalidExistingOLM = "InvalidExistingOLM"
+
+	// ExistingOLMPolicyRefuse does not deploy OLM on clusters with an existing OLM.
+	ExistingOLMPolicyRefuse = "Refuse"
+	// ExistingOLMPolicyAdopt deploys the manifests over an existing OLM which is not newer.
+	ExistingOLMPolicyAdopt = "Adopt"
+)
+
+// existingOLMVersion returns the version of the OLM installed without the addon claimed by the cluster.
+func existingOLMVersion(cluster *clusterv1.ManagedCluster) (string, bool) {
+	for _, claim := range cluster.Status.ClusterClaims {
+		if claim.Name == ExistingOLMClaim {
+			return claim.Value, true
+		}
+	}
+	return "", false
+}
+
+// parseExistingOLMPolicy reads the ExistingOLMPolicy customized variable, Refuse when not set.
+func parseExistingOLMPolicy(config addonfactory.Values) (string, error) {
+	value, ok := config["ExistingOLMPolicy"].(string)
+	if !ok || value == "" {
+		return ExistingOLMPolicyRefuse, nil
+	}
+	if value != ExistingOLMPolicyRefuse && value != ExistingOLMPolicyAdopt {
+		return ExistingOLMPolicyRefuse, fmt.Errorf("invalid value %q for ExistingOLMPolicy, %s or %s is expected", value,
+			ExistingOLMPolicyRefuse, ExistingOLMPolicyAdopt)
+	}
+	return value, nil
+}
+
+// existingOLMCondition decides whether the manifests of a set can be deployed on a cluster where OLM may have been
+// installed without the addon. An existing OLM is adopted, i.e. its resources get overwritten by the manifests,
+// when the ExistingOLMPolicy variable is Adopt and its version is not newer than the one of the manifest set.
+// Clusters where the addon already applied its manifests keep them whatever they claim as they may report
+// the OLM deployed by the addon, which is then not considered as adopted.
+func existingOLMCondition(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn,
+	set manifestSet, config addonfactory.Values) (bool, metav1.Condition) {
+	existing, ok := existingOLMVersion(cluster)
+	if !ok {
+		return true, metav1.Condition{
+			Type:    ConditionExistingOLM,
+			Status:  metav1.ConditionFalse,
+			Reason:  ReasonNoExistingOLM,
+			Message: fmt.Sprintf("the cluster does not claim an existing OLM with %s", ExistingOLMClaim),
+		}
+	}
+	condition := metav1.Condition{Type: ConditionExistingOLM, Status: metav1.ConditionTrue}
+	adopted := metav1.Condition{
+		Type:   ConditionExistingOLM,
+		Status: metav1.ConditionTrue,
+		Reason: ReasonExistingOLMAdopted,
+		Message: fmt.Sprintf("OLM %s installed on the cluster is adopted and managed with OLM %s of manifest set %s",
+			existing, set.olmVersion, set.Name()),
+	}
+	previous := meta.FindStatusCondition(addon.Status.Conditions, ConditionExistingOLM)
+	if previous != nil && previous.Reason == ReasonExistingOLMAdopted {
+		return true, adopted
+	}
+	if (previous == nil || previous.Reason == ReasonNoExistingOLM) &&
+		meta.IsStatusConditionTrue(addon.Status.Conditions, addonapiv1alpha1.ManagedClusterAddOnManifestApplied) {
+		// the claim appeared after the addon applied its manifests
+		return true, metav1.Condition{
+			Type:    ConditionExistingOLM,
+			Status:  metav1.ConditionFalse,
+			Reason:  ReasonNoExistingOLM,
+			Message: fmt.Sprintf("OLM %s claimed with %s is the one deployed by the addon", existing, ExistingOLMClaim),
+		}
+	}
+	policy, err := parseExistingOLMPolicy(config)
+	if err != nil {
+		condition.Reason = ReasonInvalidExistingOLM
+		condition.Message = fmt.Sprintf("OLM %s is installed on the cluster and not adopted: %s", existing, err.Error())
+		return false, condition
+	}
+	if policy != ExistingOLMPolicyAdopt {
+		condition.Reason = ReasonExistingOLMRefused
+		condition.Message = fmt.Sprintf("OLM %s is installed on the cluster, it is adopted when the ExistingOLMPolicy "+
+			"variable is %s", existing, ExistingOLMPolicyAdopt)
+		return false, condition
+	}
+	existingVersion, err := version.ParseGeneric(existing)
+	if err != nil {
+		condition.Reason = ReasonInvalidExistingOLM
+		condition.Message = fmt.Sprintf("the version %q of OLM installed on the cluster is not valid: %s", existing,
+			err.Error())
+		return false, condition
+	}
+	if setVersion, err := version.ParseGeneric(set.olmVersion); err == nil && setVersion.LessThan(existingVersion) {
+		condition.Reason = ReasonExistingOLMNewer
+		condition.Message = fmt.Sprintf("OLM %s installed on the cluster is newer than OLM %s of manifest set %s",
+			existing, set.olmVersion, set.Name())
+		return false, condition
+	}
+	return true, adopted
+}
+
+// setOrphanAnnotations marks the objects of an adopted OLM to be orphaned by the ManifestWork, the OLM installed
+// before the addon is left on the cluster when the addon gets removed.
+func setOrphanAnnotations(objects []runtime.Object) error {
+	for _, obj := range objects {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		annotations := accessor.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[addonapiv1alpha1.DeletionOrphanAnnotationKey] = ""
+		accessor.SetAnnotations(annotations)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package manager
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExistingOLMCondition(t *testing.T) {
+	set := manifestSet{version: version.MustParseGeneric("1.27"), olmVersion: "v0.25.0"}
+	testCases := []struct {
+		name       string
+		claim      string
+		policy     string
+		conditions []metav1.Condition
+		deploy     bool
+		reason     string
+	}{
+		{name: "no existing OLM", deploy: true, reason: ReasonNoExistingOLM},
+		{name: "refused by default", claim: "v0.24.0", reason: ReasonExistingOLMRefused},
+		{name: "adopted", claim: "v0.24.0", policy: ExistingOLMPolicyAdopt, deploy: true, reason: ReasonExistingOLMAdopted},
+		{name: "newer", claim: "0.26.0", policy: ExistingOLMPolicyAdopt, reason: ReasonExistingOLMNewer},
+		{name: "invalid version", claim: "latest", policy: ExistingOLMPolicyAdopt, reason: ReasonInvalidExistingOLM},
+		{name: "invalid policy", claim: "v0.24.0", policy: "Overwrite", reason: ReasonInvalidExistingOLM},
+		{
+			name:  "previously adopted",
+			claim: "v0.24.0",
+			conditions: []metav1.Condition{
+				{Type: ConditionExistingOLM, Status: metav1.ConditionTrue, Reason: ReasonExistingOLMAdopted},
+			},
+			deploy: true,
+			reason: ReasonExistingOLMAdopted,
+		},
+		{
+			name:  "deployed by the addon",
+			claim: "v0.25.0",
+			conditions: []metav1.Condition{
+				{Type: ConditionExistingOLM, Status: metav1.ConditionFalse, Reason: ReasonNoExistingOLM},
+				{Type: addonapiv1alpha1.ManagedClusterAddOnManifestApplied, Status: metav1.ConditionTrue},
+			},
+			deploy: true,
+			reason: ReasonNoExistingOLM,
+		},
+		{
+			name:  "previously refused",
+			claim: "v0.24.0",
+			conditions: []metav1.Condition{
+				{Type: ConditionExistingOLM, Status: metav1.ConditionTrue, Reason: ReasonExistingOLMRefused},
+				{Type: addonapiv1alpha1.ManagedClusterAddOnManifestApplied, Status: metav1.ConditionTrue},
+			},
+			reason: ReasonExistingOLMRefused,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cluster := newTestCluster("v1.27.3")
+			if tc.claim != "" {
+				cluster.Status.ClusterClaims = []clusterv1.ManagedClusterClaim{{Name: ExistingOLMClaim, Value: tc.claim}}
+			}
+			addon := &addonapiv1alpha1.ManagedClusterAddOn{Status: addonapiv1alpha1.ManagedClusterAddOnStatus{
+				Conditions: tc.conditions,
+			}}
+			config := map[string]interface{}{}
+			if tc.policy != "" {
+				config["ExistingOLMPolicy"] = tc.policy
+			}
+			deploy, condition := existingOLMCondition(cluster, addon, set, config)
+			require.Equal(t, tc.deploy, deploy)
+			require.Equal(t, tc.reason, condition.Reason)
+		})
+	}
+}
+
+func TestManifestsExistingOLM(t *testing.T) {
+	agent, addon := newTestAgent(t, PatchRenderingMode)
+	cluster := newTestCluster("v1.27.3")
+	cluster.Status.ClusterClaims = []clusterv1.ManagedClusterClaim{{Name: ExistingOLMClaim, Value: "v0.24.0"}}
+	objects, err := agent.Manifests(cluster, addon)
+	require.NoError(t, err)
+	require.Empty(t, objects, "the existing OLM is not overwritten")
+
+	agent, addon = newTestAgent(t, PatchRenderingMode,
+		addonapiv1alpha1.CustomizedVariable{Name: "ExistingOLMPolicy", Value: ExistingOLMPolicyAdopt})
+	objects, err = agent.Manifests(cluster, addon)
+	require.NoError(t, err)
+	require.NotEmpty(t, objects)
+	for _, obj := range objects {
+		accessor, err := meta.Accessor(obj)
+		require.NoError(t, err)
+		require.Contains(t, accessor.GetAnnotations(), addonapiv1alpha1.DeletionOrphanAnnotationKey,
+			"the adopted OLM is left on the cluster when the addon is removed")
+	}
+
+	// the OLM deployed by the addon is not orphaned
+	objects, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+	require.NoError(t, err)
+	for _, obj := range objects {
+		accessor, err := meta.Accessor(obj)
+		require.NoError(t, err)
+		require.NotContains(t, accessor.GetAnnotations(), addonapiv1alpha1.DeletionOrphanAnnotationKey)
+	}
+}
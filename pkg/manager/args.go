@@ -0,0 +1,97 @@
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+)
+
+// containerFlag maps a configuration value to a command line flag of an OLM container.
+type containerFlag struct {
+	// value is the name of the configuration value, e.g. OLMImage
+	value string
+	flag  string
+}
+
+// containerFlags lists per container the flags set from the configuration.
+var containerFlags = map[string][]containerFlag{
+	"catalog-operator": {
+		{value: "OLMImage", flag: "--util-image"},
+		{value: "ConfigMapServerImage", flag: "--configmapServerImage"},
+	},
+}
+
+// setContainerFlags sets the flags of the containers with the configured values.
+// The containers are left untouched when the flags cannot be set consistently.
+func setContainerFlags(owner string, containers []corev1.Container, config addonfactory.Values) error {
+	for i := range containers {
+		flags, ok := containerFlags[containers[i].Name]
+		if !ok {
+			continue
+		}
+		args := containers[i].Args
+		for _, f := range flags {
+			value, ok := config[f.value].(string)
+			if !ok {
+				continue
+			}
+			var err error
+			if args, err = setArg(args, f.flag, value); err != nil {
+				return fmt.Errorf("%s/%s: %w", owner, containers[i].Name, err)
+			}
+		}
+		containers[i].Args = args
+	}
+	return nil
+}
+
+// setArg returns a copy of args with the value of the flag set. The flag keeps the form it is provided in,
+// "--flag=value" or "--flag value", and is appended as "--flag=value" when missing.
+// Flags provided multiple times or without value are rejected as the result would be ambiguous.
+func setArg(args []string, flag, value string) ([]string, error) {
+	result := make([]string, 0, len(args)+1)
+	found := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == flag:
+			if i+1 >= len(args) || strings.HasPrefix(args[i+1], "-") {
+				return nil, fmt.Errorf("flag %s has no value", flag)
+			}
+			result = append(result, arg, value)
+			i++
+		case strings.HasPrefix(arg, flag+"="):
+			result = append(result, flag+"="+value)
+		default:
+			result = append(result, arg)
+			continue
+		}
+		if found {
+			return nil, fmt.Errorf("flag %s is provided multiple times", flag)
+		}
+		found = true
+	}
+	if !found {
+		result = append(result, flag+"="+value)
+	}
+	if actual := argValue(result, flag); actual != value {
+		return nil, fmt.Errorf("flag %s is set to %q instead of %q", flag, actual, value)
+	}
+	return result, nil
+}
+
+// argValue returns the value of a flag provided either as "--flag=value" or as "--flag value".
+func argValue(args []string, flag string) string {
+	for i, arg := range args {
+		if arg == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, flag+"=") {
+			return strings.TrimPrefix(arg, flag+"=")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package manager
+
+import (
+	"path"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetArg(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		expected      []string
+		expectedError string
+	}{
+		{
+			name:     "separate value",
+			args:     []string{"--namespace", "olm", "--util-image", "old", "--set-workload-user-id=true"},
+			expected: []string{"--namespace", "olm", "--util-image", "new", "--set-workload-user-id=true"},
+		},
+		{
+			name:     "equal sign",
+			args:     []string{"--util-image=old", "--namespace", "olm"},
+			expected: []string{"--util-image=new", "--namespace", "olm"},
+		},
+		{
+			name:     "missing flag",
+			args:     []string{"--namespace", "olm"},
+			expected: []string{"--namespace", "olm", "--util-image=new"},
+		},
+		{
+			name:     "flag with the same prefix",
+			args:     []string{"--util-image-pull-policy=Always"},
+			expected: []string{"--util-image-pull-policy=Always", "--util-image=new"},
+		},
+		{
+			name:          "missing value",
+			args:          []string{"--namespace", "olm", "--util-image"},
+			expectedError: "has no value",
+		},
+		{
+			name:          "flag instead of value",
+			args:          []string{"--util-image", "--namespace", "olm"},
+			expectedError: "has no value",
+		},
+		{
+			name:          "duplicated flag",
+			args:          []string{"--util-image", "old", "--util-image=other"},
+			expectedError: "multiple times",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := append([]string{}, tt.args...)
+			args, err := setArg(tt.args, "--util-image", "new")
+			require.Equal(t, original, tt.args, "args modified in place")
+			if tt.expectedError != "" {
+				require.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, args)
+		})
+	}
+}
+
+func TestSetConfigurationFlagsOfEmbeddedSets(t *testing.T) {
+	require.NoError(t, addToScheme())
+	resolver, err := newVersionResolver(repoFS, RefusePolicy)
+	require.NoError(t, err)
+	matrix, err := loadCompatibilityMatrix(repoFS)
+	require.NoError(t, err)
+	require.NoError(t, matrix.apply(resolver, repoFS))
+	config := addonfactory.Values{
+		"OLMImage":             testOLMImage,
+		"ConfigMapServerImage": testConfigMapServerImage,
+	}
+	for _, set := range resolver.sets {
+		set := set
+		t.Run(path.Join(set.dir, "olm.yaml"), func(t *testing.T) {
+			file, err := loadManifestFile(repoFS, set, "olm.yaml")
+			require.NoError(t, err)
+			configured := 0
+			for _, obj := range file.objects {
+				deployment, ok := obj.DeepCopyObject().(*appsv1.Deployment)
+				if !ok || deployment.Name != "catalog-operator" {
+					continue
+				}
+				original := deployment.Spec.Template.Spec.Containers[0].Args
+				require.NoError(t, setConfiguration(deployment, config))
+				args := deployment.Spec.Template.Spec.Containers[0].Args
+				require.Len(t, args, len(original))
+				require.Equal(t, testOLMImage, argValue(args, "--util-image"))
+				require.Equal(t, testConfigMapServerImage, argValue(args, "--configmapServerImage"))
+				require.Equal(t, argValue(original, "--namespace"), argValue(args, "--namespace"))
+				require.Equal(t, argValue(original, "--set-workload-user-id"), argValue(args, "--set-workload-user-id"))
+				configured++
+			}
+			require.Equal(t, 1, configured)
+		})
+	}
+}
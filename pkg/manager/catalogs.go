@@ -0,0 +1,148 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+
+	"github.com/stolostron/olm-addon/pkg/apis/v1alpha1"
+)
+
+// defaultCatalogSource is the name of the CatalogSource of the manifests.
+const defaultCatalogSource = "operatorhubio-catalog"
+
+// getCatalogs retrieves the OLMCatalogs referenced by the ManagedClusterAddOn.
+// Errors are returned rather than ignored as deploying the catalogs of the manifests
+// in place of the configured ones may expose the clusters to unwanted operators.
+func (o *olmAgent) getCatalogs(ctx context.Context, addon *addonapiv1alpha1.ManagedClusterAddOn) ([]*v1alpha1.OLMCatalog, error) {
+	if o.dynamicClient == nil || addon == nil {
+		return nil, nil
+	}
+	catalogs := []*v1alpha1.OLMCatalog{}
+	for _, ref := range addon.Status.ConfigReferences {
+		if ref.Group != v1alpha1.OLMCatalogGVR.Group || ref.Resource != v1alpha1.OLMCatalogGVR.Resource {
+			continue
+		}
+		u, err := o.dynamicClient.Resource(v1alpha1.OLMCatalogGVR).Namespace(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("not able to retrieve OLMCatalog %s/%s: %w", ref.Namespace, ref.Name, err)
+		}
+		catalog := &v1alpha1.OLMCatalog{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, catalog); err != nil {
+			return nil, fmt.Errorf("not able to decode OLMCatalog %s/%s: %w", ref.Namespace, ref.Name, err)
+		}
+		catalogs = append(catalogs, catalog)
+	}
+	return catalogs, nil
+}
+
+// catalogSource renders a catalog into a CatalogSource of the namespace.
+// The pod configuration of the catalog is kept but pods run in restricted mode, as for
+// the default catalog, unless another security context is configured.
+func catalogSource(catalog v1alpha1.Catalog, namespace string) *olmv1alpha1.CatalogSource {
+	source := &olmv1alpha1.CatalogSource{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: olmv1alpha1.SchemeGroupVersion.String(),
+			Kind:       olmv1alpha1.CatalogSourceKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      catalog.Name,
+			Namespace: namespace,
+		},
+		Spec: olmv1alpha1.CatalogSourceSpec{
+			SourceType:  olmv1alpha1.SourceTypeGrpc,
+			Image:       catalog.Image,
+			DisplayName: catalog.DisplayName,
+			Publisher:   catalog.Publisher,
+			Secrets:     catalog.Secrets,
+			GrpcPodConfig: &olmv1alpha1.GrpcPodConfig{
+				SecurityContextConfig: olmv1alpha1.Restricted,
+			},
+		},
+	}
+	if catalog.GrpcPodConfig != nil {
+		source.Spec.GrpcPodConfig = catalog.GrpcPodConfig.DeepCopy()
+		if source.Spec.GrpcPodConfig.SecurityContextConfig == "" {
+			source.Spec.GrpcPodConfig.SecurityContextConfig = olmv1alpha1.Restricted
+		}
+	}
+	if catalog.PollInterval != nil {
+		source.Spec.UpdateStrategy = &olmv1alpha1.UpdateStrategy{
+			RegistryPoll: &olmv1alpha1.RegistryPoll{RawInterval: catalog.PollInterval.Duration.String()},
+		}
+	}
+	return source
+}
+
+// validateCatalogs checks that the catalogs can be rendered into CatalogSources
+// and that no CatalogSource is defined twice.
+func validateCatalogs(catalogs []*v1alpha1.OLMCatalog) error {
+	names := map[string]string{}
+	for _, olmCatalog := range catalogs {
+		for _, catalog := range olmCatalog.Spec.Catalogs {
+			if errs := validation.IsDNS1123Subdomain(catalog.Name); len(errs) > 0 {
+				return fmt.Errorf("OLMCatalog %s/%s: invalid catalog name %q: %s", olmCatalog.Namespace, olmCatalog.Name,
+					catalog.Name, strings.Join(errs, ", "))
+			}
+			if catalog.Image == "" {
+				return fmt.Errorf("OLMCatalog %s/%s: no image for catalog %s", olmCatalog.Namespace, olmCatalog.Name, catalog.Name)
+			}
+			if catalog.PollInterval != nil && catalog.PollInterval.Duration <= 0 {
+				return fmt.Errorf("OLMCatalog %s/%s: invalid poll interval %s for catalog %s", olmCatalog.Namespace,
+					olmCatalog.Name, catalog.PollInterval.Duration, catalog.Name)
+			}
+			if owner, ok := names[catalog.Name]; ok {
+				return fmt.Errorf("catalog %s defined in OLMCatalog %s and %s/%s", catalog.Name, owner,
+					olmCatalog.Namespace, olmCatalog.Name)
+			}
+			names[catalog.Name] = olmCatalog.Namespace + "/" + olmCatalog.Name
+		}
+	}
+	return nil
+}
+
+// setCatalogs adds the CatalogSources of the OLMCatalogs to the objects.
+// CatalogSources of the manifests with the same name are replaced and the default
+// catalog is removed when disabled by any of the OLMCatalogs.
+func setCatalogs(objects []runtime.Object, catalogs []*v1alpha1.OLMCatalog, namespace string) ([]runtime.Object, error) {
+	if len(catalogs) == 0 {
+		return objects, nil
+	}
+	if err := validateCatalogs(catalogs); err != nil {
+		return nil, err
+	}
+	removed := map[string]bool{}
+	sources := []runtime.Object{}
+	for _, olmCatalog := range catalogs {
+		if olmCatalog.Spec.DisableDefaultCatalog {
+			removed[defaultCatalogSource] = true
+		}
+		for _, catalog := range olmCatalog.Spec.Catalogs {
+			removed[catalog.Name] = true
+			sources = append(sources, catalogSource(catalog, namespace))
+		}
+	}
+	result := []runtime.Object{}
+	for _, obj := range objects {
+		if obj.GetObjectKind().GroupVersionKind().Kind == olmv1alpha1.CatalogSourceKind {
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				return nil, err
+			}
+			if accessor.GetNamespace() == namespace && removed[accessor.GetName()] {
+				continue
+			}
+		}
+		result = append(result, obj)
+	}
+	return append(result, sources...), nil
+}
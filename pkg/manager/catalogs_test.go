@@ -0,0 +1,220 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stolostron/olm-addon/pkg/apis/v1alpha1"
+)
+
+// withCatalogs serves the OLMCatalogs with a fake dynamic client and references them from the addon.
+func withCatalogs(t *testing.T, agent *olmAgent, addon *addonapiv1alpha1.ManagedClusterAddOn,
+	catalogs ...*v1alpha1.OLMCatalog) {
+	objects := []runtime.Object{}
+	for _, catalog := range catalogs {
+		catalog.APIVersion = v1alpha1.SchemeGroupVersion.String()
+		catalog.Kind = "OLMCatalog"
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(catalog)
+		require.NoError(t, err)
+		objects = append(objects, &unstructured.Unstructured{Object: content})
+		addon.Status.ConfigReferences = append(addon.Status.ConfigReferences, addonapiv1alpha1.ConfigReference{
+			ConfigGroupResource: addonapiv1alpha1.ConfigGroupResource{
+				Group:    v1alpha1.OLMCatalogGVR.Group,
+				Resource: v1alpha1.OLMCatalogGVR.Resource,
+			},
+			ConfigReferent: addonapiv1alpha1.ConfigReferent{Name: catalog.Name, Namespace: catalog.Namespace},
+		})
+	}
+	agent.dynamicClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objects...)
+}
+
+// findCatalogSources returns the CatalogSources of a list of objects by name.
+func findCatalogSources(objects []runtime.Object) map[string]*olmv1alpha1.CatalogSource {
+	sources := map[string]*olmv1alpha1.CatalogSource{}
+	for _, obj := range objects {
+		if source, ok := obj.(*olmv1alpha1.CatalogSource); ok {
+			sources[source.Name] = source
+		}
+	}
+	return sources
+}
+
+func TestCatalogSource(t *testing.T) {
+	source := catalogSource(v1alpha1.Catalog{
+		Name:         "internal",
+		Image:        "registry.example.com/catalog:v1",
+		DisplayName:  "Internal Operators",
+		Publisher:    "Example",
+		PollInterval: &metav1.Duration{Duration: 30 * time.Minute},
+		Secrets:      []string{"pull-secret"},
+	}, "olm")
+	require.Equal(t, olmv1alpha1.CatalogSourceKind, source.Kind)
+	require.Equal(t, "olm", source.Namespace)
+	require.Equal(t, olmv1alpha1.SourceTypeGrpc, source.Spec.SourceType)
+	require.Equal(t, "registry.example.com/catalog:v1", source.Spec.Image)
+	require.Equal(t, "Internal Operators", source.Spec.DisplayName)
+	require.Equal(t, "Example", source.Spec.Publisher)
+	require.Equal(t, []string{"pull-secret"}, source.Spec.Secrets)
+	require.Equal(t, "30m0s", source.Spec.UpdateStrategy.RegistryPoll.RawInterval)
+	require.Equal(t, olmv1alpha1.Restricted, source.Spec.GrpcPodConfig.SecurityContextConfig)
+
+	source = catalogSource(v1alpha1.Catalog{
+		Name:          "legacy",
+		Image:         "registry.example.com/legacy:v1",
+		GrpcPodConfig: &olmv1alpha1.GrpcPodConfig{SecurityContextConfig: olmv1alpha1.Legacy},
+	}, "olm")
+	require.Nil(t, source.Spec.UpdateStrategy)
+	require.Equal(t, olmv1alpha1.Legacy, source.Spec.GrpcPodConfig.SecurityContextConfig)
+
+	// a partial pod configuration keeps the restricted security context
+	source = catalogSource(v1alpha1.Catalog{
+		Name:  "scheduled",
+		Image: "registry.example.com/scheduled:v1",
+		GrpcPodConfig: &olmv1alpha1.GrpcPodConfig{
+			NodeSelector: map[string]string{"node-role.kubernetes.io/infra": ""},
+		},
+	}, "olm")
+	require.Equal(t, olmv1alpha1.Restricted, source.Spec.GrpcPodConfig.SecurityContextConfig)
+	require.Equal(t, map[string]string{"node-role.kubernetes.io/infra": ""}, source.Spec.GrpcPodConfig.NodeSelector)
+}
+
+func TestValidateCatalogs(t *testing.T) {
+	newCatalog := func(name string, catalogs ...v1alpha1.Catalog) *v1alpha1.OLMCatalog {
+		return &v1alpha1.OLMCatalog{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       v1alpha1.OLMCatalogSpec{Catalogs: catalogs},
+		}
+	}
+	tests := []struct {
+		name     string
+		catalogs []*v1alpha1.OLMCatalog
+		err      string
+	}{
+		{
+			name:     "valid",
+			catalogs: []*v1alpha1.OLMCatalog{newCatalog("a", v1alpha1.Catalog{Name: "internal", Image: "catalog:v1"})},
+		},
+		{
+			name:     "invalid name",
+			catalogs: []*v1alpha1.OLMCatalog{newCatalog("a", v1alpha1.Catalog{Name: "Internal", Image: "catalog:v1"})},
+			err:      "invalid catalog name \"Internal\"",
+		},
+		{
+			name:     "no image",
+			catalogs: []*v1alpha1.OLMCatalog{newCatalog("a", v1alpha1.Catalog{Name: "internal"})},
+			err:      "no image for catalog internal",
+		},
+		{
+			name: "invalid poll interval",
+			catalogs: []*v1alpha1.OLMCatalog{newCatalog("a", v1alpha1.Catalog{Name: "internal", Image: "catalog:v1",
+				PollInterval: &metav1.Duration{}})},
+			err: "invalid poll interval",
+		},
+		{
+			name: "duplicated",
+			catalogs: []*v1alpha1.OLMCatalog{
+				newCatalog("a", v1alpha1.Catalog{Name: "internal", Image: "catalog:v1"}),
+				newCatalog("b", v1alpha1.Catalog{Name: "internal", Image: "catalog:v2"}),
+			},
+			err: "catalog internal defined in OLMCatalog default/a and default/b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCatalogs(tt.catalogs)
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCatalogsConfiguration(t *testing.T) {
+	for _, mode := range []RenderingMode{PatchRenderingMode, TemplateRenderingMode} {
+		t.Run(string(mode), func(t *testing.T) {
+			agent, addon := newTestAgent(t, mode)
+			objects, err := agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.NoError(t, err)
+			sources := findCatalogSources(objects)
+			require.Len(t, sources, 1)
+			require.Contains(t, sources, defaultCatalogSource)
+
+			agent, addon = newTestAgent(t, mode)
+			withCatalogs(t, agent, addon, &v1alpha1.OLMCatalog{
+				ObjectMeta: metav1.ObjectMeta{Name: "catalogs", Namespace: "default"},
+				Spec: v1alpha1.OLMCatalogSpec{Catalogs: []v1alpha1.Catalog{
+					{Name: "internal", Image: "registry.example.com/catalog:v1"},
+				}},
+			})
+			objects, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.NoError(t, err)
+			sources = findCatalogSources(objects)
+			require.Len(t, sources, 2)
+			require.Contains(t, sources, defaultCatalogSource)
+			require.Equal(t, "registry.example.com/catalog:v1", sources["internal"].Spec.Image)
+			require.Equal(t, DefaultOLMNamespace, sources["internal"].Namespace)
+
+			agent, addon = newTestAgent(t, mode)
+			withCatalogs(t, agent, addon, &v1alpha1.OLMCatalog{
+				ObjectMeta: metav1.ObjectMeta{Name: "catalogs", Namespace: "default"},
+				Spec: v1alpha1.OLMCatalogSpec{Catalogs: []v1alpha1.Catalog{
+					{Name: defaultCatalogSource, Image: "registry.example.com/mirror:v1"},
+				}},
+			})
+			objects, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.NoError(t, err)
+			sources = findCatalogSources(objects)
+			require.Len(t, sources, 1)
+			require.Equal(t, "registry.example.com/mirror:v1", sources[defaultCatalogSource].Spec.Image)
+
+			agent, addon = newTestAgent(t, mode)
+			withCatalogs(t, agent, addon,
+				&v1alpha1.OLMCatalog{
+					ObjectMeta: metav1.ObjectMeta{Name: "no-default", Namespace: "default"},
+					Spec:       v1alpha1.OLMCatalogSpec{DisableDefaultCatalog: true},
+				},
+				&v1alpha1.OLMCatalog{
+					ObjectMeta: metav1.ObjectMeta{Name: "catalogs", Namespace: "default"},
+					Spec: v1alpha1.OLMCatalogSpec{Catalogs: []v1alpha1.Catalog{
+						{Name: "internal", Image: "registry.example.com/catalog:v1"},
+					}},
+				})
+			objects, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.NoError(t, err)
+			sources = findCatalogSources(objects)
+			require.Len(t, sources, 1)
+			require.Contains(t, sources, "internal")
+
+			agent, addon = newTestAgent(t, mode)
+			withCatalogs(t, agent, addon, &v1alpha1.OLMCatalog{
+				ObjectMeta: metav1.ObjectMeta{Name: "catalogs", Namespace: "default"},
+				Spec:       v1alpha1.OLMCatalogSpec{Catalogs: []v1alpha1.Catalog{{Name: "internal"}}},
+			})
+			_, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.ErrorContains(t, err, "no image for catalog internal")
+
+			agent, addon = newTestAgent(t, mode)
+			withCatalogs(t, agent, addon)
+			addon.Status.ConfigReferences = append(addon.Status.ConfigReferences, addonapiv1alpha1.ConfigReference{
+				ConfigGroupResource: addonapiv1alpha1.ConfigGroupResource{
+					Group:    v1alpha1.OLMCatalogGVR.Group,
+					Resource: v1alpha1.OLMCatalogGVR.Resource,
+				},
+				ConfigReferent: addonapiv1alpha1.ConfigReferent{Name: "missing", Namespace: "default"},
+			})
+			_, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.ErrorContains(t, err, "not able to retrieve OLMCatalog default/missing")
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package manager
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+)
+
+const (
+	compatibilityFile    = "compatibility.yaml"
+	compatibilityVersion = "v1"
+)
+
+// compatibilityMatrix maps ranges of Kubernetes versions to the OLM release
+// and manifest set deployed on clusters in these ranges.
+type compatibilityMatrix struct {
+	// Version of the file format
+	Version  string       `json:"version"`
+	Releases []olmRelease `json:"releases"`
+}
+
+// olmRelease is an entry of the compatibility matrix.
+type olmRelease struct {
+	// Manifests is the name of the manifest set directory, e.g. v1.25
+	Manifests  string          `json:"manifests"`
+	Kubernetes kubernetesRange `json:"kubernetes"`
+	OLMVersion string          `json:"olmVersion"`
+	Images     releaseImages   `json:"images"`
+	// LeaderElection is whether the OLM and catalog operators of the release accept the --leader-elect flag
+	// and can run several replicas in high-availability mode.
+	LeaderElection bool `json:"leaderElection,omitempty"`
+}
+
+// kubernetesRange is an inclusive range of Kubernetes minor versions.
+type kubernetesRange struct {
+	Min string `json:"min"`
+	Max string `json:"max"`
+}
+
+// releaseImages are the images referenced by a manifest set.
+type releaseImages struct {
+	OLM             string `json:"olm"`
+	ConfigMapServer string `json:"configMapServer"`
+}
+
+// loadCompatibilityMatrix parses the compatibility matrix of the manifest sets.
+func loadCompatibilityMatrix(manifests fs.FS) (*compatibilityMatrix, error) {
+	content, err := fs.ReadFile(manifests, compatibilityFile)
+	if err != nil {
+		return nil, err
+	}
+	matrix := &compatibilityMatrix{}
+	if err := yaml.UnmarshalStrict(content, matrix); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", compatibilityFile, err)
+	}
+	if matrix.Version != compatibilityVersion {
+		return nil, fmt.Errorf("unsupported %s version %q, expected %q", compatibilityFile, matrix.Version, compatibilityVersion)
+	}
+	return matrix, nil
+}
+
+// apply validates the matrix against the manifest sets known by the resolver
+// and sets the Kubernetes ranges and OLM releases of the sets accordingly.
+func (m *compatibilityMatrix) apply(resolver *versionResolver, manifests fs.FS) error {
+	releases := map[string]olmRelease{}
+	for _, release := range m.Releases {
+		if _, ok := releases[release.Manifests]; ok {
+			return fmt.Errorf("manifest set %s is listed multiple times in %s", release.Manifests, compatibilityFile)
+		}
+		releases[release.Manifests] = release
+	}
+	for i := range resolver.sets {
+		set := &resolver.sets[i]
+		release, ok := releases[set.Name()]
+		if !ok {
+			return fmt.Errorf("manifest set %s is missing in %s", set.Name(), compatibilityFile)
+		}
+		delete(releases, set.Name())
+		minVersion, err := version.ParseGeneric(release.Kubernetes.Min)
+		if err != nil {
+			return fmt.Errorf("invalid minimum Kubernetes version for manifest set %s: %w", set.Name(), err)
+		}
+		maxVersion, err := version.ParseGeneric(release.Kubernetes.Max)
+		if err != nil {
+			return fmt.Errorf("invalid maximum Kubernetes version for manifest set %s: %w", set.Name(), err)
+		}
+		if !sameMinorOrLess(minVersion, maxVersion) {
+			return fmt.Errorf("invalid Kubernetes range for manifest set %s: %s is greater than %s", set.Name(),
+				release.Kubernetes.Min, release.Kubernetes.Max)
+		}
+		set.minVersion = minVersion
+		set.maxVersion = maxVersion
+		set.olmVersion = release.OLMVersion
+		set.images = release.Images
+		set.leaderElection = release.LeaderElection
+		if err := validateImages(manifests, *set, release); err != nil {
+			return err
+		}
+	}
+	if len(releases) > 0 {
+		missing := []string{}
+		for name := range releases {
+			missing = append(missing, name)
+		}
+		return fmt.Errorf("manifest sets %s listed in %s do not exist", strings.Join(missing, ", "), compatibilityFile)
+	}
+	for i := 1; i < len(resolver.sets); i++ {
+		if sameMinorOrLess(resolver.sets[i].minVersion, resolver.sets[i-1].maxVersion) {
+			return fmt.Errorf("Kubernetes ranges of manifest sets %s and %s overlap", resolver.sets[i-1].Name(),
+				resolver.sets[i].Name())
+		}
+	}
+	return nil
+}
+
+// Images assigned to the template values when validating the manifest templates of a set.
+const (
+	probeOLMImage             = "olm-addon.invalid/olm@sha256:0"
+	probeConfigMapServerImage = "olm-addon.invalid/configmap-operator-registry@sha256:0"
+)
+
+// validateImages checks that the images referenced in the OLM manifests of a set
+// match what is declared in the compatibility matrix. The images declared in the matrix are the default
+// values of the manifest templates, templates are therefore checked to reference these values
+// rather than literal images by rendering them with other images.
+func validateImages(manifests fs.FS, set manifestSet, release olmRelease) error {
+	file, err := loadManifestFile(manifests, set, "olm.yaml")
+	if err != nil {
+		return err
+	}
+	objects, expected, origin := file.objects, release.Images, "declared in "+compatibilityFile
+	if file.template != nil {
+		if release.Images.OLM == "" || release.Images.ConfigMapServer == "" {
+			return fmt.Errorf("manifest set %s: the OLM and configmap server images need to be declared in %s",
+				release.Manifests, compatibilityFile)
+		}
+		values := defaultValues(set)
+		values["OLMImage"] = probeOLMImage
+		values["ConfigMapServerImage"] = probeConfigMapServerImage
+		objects, err = renderManifests(file.template, values)
+		if err != nil {
+			return err
+		}
+		expected = releaseImages{OLM: probeOLMImage, ConfigMapServer: probeConfigMapServerImage}
+		origin = "set by the template value"
+	}
+	checkContainers := func(owner string, containers []corev1.Container) error {
+		for _, container := range containers {
+			if container.Image != expected.OLM {
+				return fmt.Errorf("manifest set %s: image %s of %s/%s does not match %s %s", release.Manifests,
+					container.Image, owner, container.Name, expected.OLM, origin)
+			}
+		}
+		return nil
+	}
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *appsv1.Deployment:
+			if err := checkContainers(o.Name, o.Spec.Template.Spec.Containers); err != nil {
+				return err
+			}
+			if o.Name != "catalog-operator" {
+				continue
+			}
+			args := o.Spec.Template.Spec.Containers[0].Args
+			if img := argValue(args, "--util-image"); img != expected.OLM {
+				return fmt.Errorf("manifest set %s: util image %s does not match %s %s", release.Manifests,
+					img, expected.OLM, origin)
+			}
+			if img := argValue(args, "--configmapServerImage"); img != expected.ConfigMapServer {
+				return fmt.Errorf("manifest set %s: configmap server image %s does not match %s %s",
+					release.Manifests, img, expected.ConfigMapServer, origin)
+			}
+		case *olmv1alpha1.ClusterServiceVersion:
+			if o.Spec.Version.String() != strings.TrimPrefix(release.OLMVersion, "v") {
+				return fmt.Errorf("manifest set %s: version %s of %s does not match OLM %s declared in %s",
+					release.Manifests, o.Spec.Version.String(), o.Name, release.OLMVersion, compatibilityFile)
+			}
+			for _, deployment := range o.Spec.InstallStrategy.StrategySpec.DeploymentSpecs {
+				if err := checkContainers(deployment.Name, deployment.Spec.Template.Spec.Containers); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"k8s.io/apimachinery/pkg/util/version"
+
+	"github.com/stretchr/testify/require"
+)
+
+// repoFS gives access to the manifests embedded in the addon binary.
+var repoFS = os.DirFS("../../manifests")
+
+func TestEmbeddedCompatibilityMatrix(t *testing.T) {
+	require.NoError(t, addToScheme())
+	resolver, err := newVersionResolver(repoFS, RefusePolicy)
+	require.NoError(t, err)
+	matrix, err := loadCompatibilityMatrix(repoFS)
+	require.NoError(t, err)
+	require.NoError(t, matrix.apply(resolver, repoFS))
+	for _, set := range resolver.sets {
+		require.NotEmpty(t, set.olmVersion, "OLM version missing for %s", set.Name())
+	}
+}
+
+func TestInvalidCompatibilityMatrix(t *testing.T) {
+	require.NoError(t, addToScheme())
+	olm := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: catalog-operator
+  namespace: olm
+spec:
+  template:
+    spec:
+      containers:
+      - name: catalog-operator
+        image: quay.io/operator-framework/olm@sha256:111
+        args:
+        - --configmapServerImage=quay.io/operator-framework/configmap-operator-registry:latest
+        - --util-image
+        - quay.io/operator-framework/olm@sha256:111
+`
+	matrixHeader := `version: v1
+releases:
+- manifests: v1.25
+  kubernetes:
+    min: "1.24"
+    max: "1.25"
+  olmVersion: v0.25.0
+  images:
+    configMapServer: quay.io/operator-framework/configmap-operator-registry:latest
+`
+	tests := []struct {
+		name   string
+		matrix string
+		valid  bool
+	}{
+		{"valid", matrixHeader + "    olm: quay.io/operator-framework/olm@sha256:111\n", true},
+		{"image mismatch", matrixHeader + "    olm: quay.io/operator-framework/olm@sha256:222\n", false},
+		{"missing set", "version: v1\nreleases: []\n", false},
+		{"unknown set", matrixHeader + "    olm: quay.io/operator-framework/olm@sha256:111\n- manifests: v1.26\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifests := fstest.MapFS{
+				"v1.25/olm.yaml":     &fstest.MapFile{Data: []byte(olm)},
+				"compatibility.yaml": &fstest.MapFile{Data: []byte(tt.matrix)},
+			}
+			resolver, err := newVersionResolver(manifests, RefusePolicy)
+			require.NoError(t, err)
+			matrix, err := loadCompatibilityMatrix(manifests)
+			require.NoError(t, err)
+			err = matrix.apply(resolver, manifests)
+			if !tt.valid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			set, _, err := resolver.resolve(version.MustParseSemantic("v1.24.7"))
+			require.NoError(t, err)
+			require.Equal(t, "v0.25.0", set.olmVersion)
+		})
+	}
+}
+
+func TestTemplatedImagesValidation(t *testing.T) {
+	require.NoError(t, addToScheme())
+	olm := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: catalog-operator
+  namespace: olm
+spec:
+  template:
+    spec:
+      containers:
+      - name: catalog-operator
+        image: %s
+        args:
+        - {{ printf "--configmapServerImage=%%s" .ConfigMapServerImage | toJson }}
+        - --util-image
+        - {{ toJson .OLMImage }}
+`
+	matrix := `version: v1
+releases:
+- manifests: v1.25
+  kubernetes:
+    min: "1.25"
+    max: "1.25"
+  olmVersion: v0.25.0
+  images:
+    olm: %s
+    configMapServer: quay.io/operator-framework/configmap-operator-registry:latest
+`
+	tests := []struct {
+		name     string
+		image    string
+		olmImage string
+		valid    bool
+	}{
+		{"image from the template value", "{{ toJson .OLMImage }}", "quay.io/operator-framework/olm@sha256:111", true},
+		{"literal image matching the matrix", "quay.io/operator-framework/olm@sha256:111",
+			"quay.io/operator-framework/olm@sha256:111", false},
+		{"missing image in the matrix", "{{ toJson .OLMImage }}", `""`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifests := fstest.MapFS{
+				"v1.25/olm.yaml":     &fstest.MapFile{Data: []byte(fmt.Sprintf(olm, tt.image))},
+				"compatibility.yaml": &fstest.MapFile{Data: []byte(fmt.Sprintf(matrix, tt.olmImage))},
+			}
+			resolver, err := newVersionResolver(manifests, RefusePolicy)
+			require.NoError(t, err)
+			compatibility, err := loadCompatibilityMatrix(manifests)
+			require.NoError(t, err)
+			err = compatibility.apply(resolver, manifests)
+			if !tt.valid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
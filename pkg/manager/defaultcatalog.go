@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+)
+
+const (
+	defaultCatalogImage        = "quay.io/operatorhubio/catalog:latest"
+	defaultCatalogDisplayName  = "Community Operators"
+	defaultCatalogPublisher    = "OperatorHub.io"
+	defaultCatalogPollInterval = "60m"
+)
+
+// defaultCatalog holds the customized settings of the default CatalogSource.
+// Empty fields keep the values of the manifests.
+type defaultCatalog struct {
+	disabled     bool
+	image        string
+	displayName  string
+	publisher    string
+	pollInterval string
+}
+
+// parseDefaultCatalog reads the DefaultCatalog customized variables.
+func parseDefaultCatalog(config addonfactory.Values) (defaultCatalog, error) {
+	catalog := defaultCatalog{}
+	if value, ok := config["DefaultCatalogDisabled"].(string); ok {
+		disabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return defaultCatalog{}, fmt.Errorf("invalid value %q for DefaultCatalogDisabled: %w", value, err)
+		}
+		catalog.disabled = disabled
+	}
+	catalog.image, _ = config["DefaultCatalogImage"].(string)
+	catalog.displayName, _ = config["DefaultCatalogDisplayName"].(string)
+	catalog.publisher, _ = config["DefaultCatalogPublisher"].(string)
+	if value, ok := config["DefaultCatalogPollInterval"].(string); ok && value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return defaultCatalog{}, fmt.Errorf("invalid value %q for DefaultCatalogPollInterval, a positive duration is expected", value)
+		}
+		catalog.pollInterval = value
+	}
+	return catalog, nil
+}
+
+// setDefaultCatalogConfiguration removes the default CatalogSource of the namespace when disabled
+// or overrides its settings with the configured ones.
+func setDefaultCatalogConfiguration(objects []runtime.Object, config addonfactory.Values,
+	namespace string) ([]runtime.Object, error) {
+	catalog, err := parseDefaultCatalog(config)
+	if err != nil {
+		return nil, err
+	}
+	result := []runtime.Object{}
+	for _, obj := range objects {
+		source, ok := obj.(*olmv1alpha1.CatalogSource)
+		if !ok || source.Name != defaultCatalogSource || source.Namespace != namespace {
+			result = append(result, obj)
+			continue
+		}
+		if catalog.disabled {
+			continue
+		}
+		if catalog.image != "" {
+			source.Spec.Image = catalog.image
+		}
+		if catalog.displayName != "" {
+			source.Spec.DisplayName = catalog.displayName
+		}
+		if catalog.publisher != "" {
+			source.Spec.Publisher = catalog.publisher
+		}
+		if catalog.pollInterval != "" {
+			source.Spec.UpdateStrategy = &olmv1alpha1.UpdateStrategy{
+				RegistryPoll: &olmv1alpha1.RegistryPoll{RawInterval: catalog.pollInterval},
+			}
+		}
+		result = append(result, source)
+	}
+	return result, nil
+}
+
+// setDefaultCatalogValues sets the template values of the default CatalogSource.
+// Empty variables keep the default values.
+func setDefaultCatalogValues(values, defaults, config addonfactory.Values) error {
+	catalog, err := parseDefaultCatalog(config)
+	if err != nil {
+		return err
+	}
+	values["DefaultCatalogDisabled"] = catalog.disabled
+	for name, value := range map[string]string{
+		"DefaultCatalogImage":        catalog.image,
+		"DefaultCatalogDisplayName":  catalog.displayName,
+		"DefaultCatalogPublisher":    catalog.publisher,
+		"DefaultCatalogPollInterval": catalog.pollInterval,
+	} {
+		if value == "" {
+			value = defaults[name].(string)
+		}
+		values[name] = value
+	}
+	return nil
+}
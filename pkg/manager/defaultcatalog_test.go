@@ -0,0 +1,125 @@
+package manager
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	agentfw "open-cluster-management.io/addon-framework/pkg/agent"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	workfake "open-cluster-management.io/api/client/work/clientset/versioned/fake"
+	workapiv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultCatalog(t *testing.T) {
+	for _, mode := range []RenderingMode{PatchRenderingMode, TemplateRenderingMode} {
+		t.Run(string(mode), func(t *testing.T) {
+			agent, addon := newTestAgent(t, mode)
+			objects, err := agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.NoError(t, err)
+			source := findCatalogSources(objects)[defaultCatalogSource]
+			require.NotNil(t, source)
+			require.Equal(t, defaultCatalogImage, source.Spec.Image)
+			require.Equal(t, defaultCatalogDisplayName, source.Spec.DisplayName)
+			require.Equal(t, defaultCatalogPublisher, source.Spec.Publisher)
+			require.Equal(t, defaultCatalogPollInterval, source.Spec.UpdateStrategy.RegistryPoll.RawInterval)
+
+			agent, addon = newTestAgent(t, mode,
+				addonapiv1alpha1.CustomizedVariable{Name: "DefaultCatalogImage", Value: "registry.example.com/operatorhubio/catalog:v1"},
+				addonapiv1alpha1.CustomizedVariable{Name: "DefaultCatalogDisplayName", Value: "Mirrored Operators"},
+				addonapiv1alpha1.CustomizedVariable{Name: "DefaultCatalogPublisher", Value: "Example"},
+				addonapiv1alpha1.CustomizedVariable{Name: "DefaultCatalogPollInterval", Value: "12h"})
+			objects, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.NoError(t, err)
+			source = findCatalogSources(objects)[defaultCatalogSource]
+			require.NotNil(t, source)
+			require.Equal(t, "registry.example.com/operatorhubio/catalog:v1", source.Spec.Image)
+			require.Equal(t, "Mirrored Operators", source.Spec.DisplayName)
+			require.Equal(t, "Example", source.Spec.Publisher)
+			require.Equal(t, "12h", source.Spec.UpdateStrategy.RegistryPoll.RawInterval)
+			for _, file := range agent.catalog.get().files["v1.27"] {
+				if cached := findCatalogSources(file.objects)[defaultCatalogSource]; cached != nil {
+					require.Equal(t, defaultCatalogImage, cached.Spec.Image, "cached objects modified")
+				}
+			}
+
+			agent, addon = newTestAgent(t, mode,
+				addonapiv1alpha1.CustomizedVariable{Name: "DefaultCatalogDisabled", Value: "true"})
+			objects, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.NoError(t, err)
+			require.Empty(t, findCatalogSources(objects))
+
+			agent, addon = newTestAgent(t, mode,
+				addonapiv1alpha1.CustomizedVariable{Name: "DefaultCatalogDisabled", Value: "false"})
+			objects, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.NoError(t, err)
+			require.Contains(t, findCatalogSources(objects), defaultCatalogSource)
+
+			agent, addon = newTestAgent(t, mode,
+				addonapiv1alpha1.CustomizedVariable{Name: "DefaultCatalogDisabled", Value: "maybe"})
+			_, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.ErrorContains(t, err, "invalid value \"maybe\" for DefaultCatalogDisabled")
+
+			agent, addon = newTestAgent(t, mode,
+				addonapiv1alpha1.CustomizedVariable{Name: "DefaultCatalogPollInterval", Value: "-1h"})
+			_, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.ErrorContains(t, err, "invalid value \"-1h\" for DefaultCatalogPollInterval")
+		})
+	}
+}
+
+// probedAvailability evaluates the probe fields against the feedback of a ManifestWork
+// as the addon framework does for setting the Available condition of the ManagedClusterAddOn.
+func probedAvailability(prober *agentfw.HealthProber, work *workapiv1.ManifestWork) metav1.ConditionStatus {
+	for _, field := range prober.WorkProber.ProbeFields {
+		result := findFeedback(field.ResourceIdentifier, work)
+		if result == nil {
+			return metav1.ConditionUnknown
+		}
+		if err := prober.WorkProber.HealthCheck(field.ResourceIdentifier, *result); err != nil {
+			return metav1.ConditionFalse
+		}
+	}
+	return metav1.ConditionTrue
+}
+
+func TestDefaultCatalogDisabledHealth(t *testing.T) {
+	agent, addon := newTestAgent(t, PatchRenderingMode,
+		addonapiv1alpha1.CustomizedVariable{Name: "DefaultCatalogDisabled", Value: "true"})
+	catalogCondition := ConditionCatalogSourceReadyPrefix + defaultCatalogSource
+	agent.updateConditions(addon, metav1.Condition{Type: catalogCondition, Status: metav1.ConditionTrue,
+		Reason: ReasonCatalogSourceReady})
+	feedbacks := healthyFeedbacks()
+	delete(feedbacks, "catalogsources/"+defaultCatalogSource)
+	work := testWork(feedbacks)
+	require.Equal(t, metav1.ConditionUnknown, probedAvailability(agent.healthProber(), work),
+		"the default catalog is probed before the cluster gets rendered")
+
+	_, err := agent.Manifests(newTestCluster("v1.27.3"), addon)
+	require.NoError(t, err)
+	require.Equal(t, metav1.ConditionTrue, probedAvailability(agent.healthProber(), work))
+
+	controller := NewHealthController(agent, workfake.NewSimpleClientset(work))
+	require.NoError(t, controller.factory.Work().V1().ManifestWorks().Informer().GetIndexer().Add(work))
+	require.NoError(t, controller.sync(context.Background(), "cluster1/addon-olm-addon-deploy-0"))
+	updated, err := agent.addonClient.AddonV1alpha1().ManagedClusterAddOns("cluster1").Get(context.Background(),
+		"olm-addon", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Nil(t, meta.FindStatusCondition(updated.Status.Conditions, catalogCondition),
+		"no condition is reported for the disabled catalog")
+	require.True(t, meta.IsStatusConditionTrue(updated.Status.Conditions, ConditionPackageServerAvailable))
+
+	// the status of the catalog is not fed back on the other clusters
+	conditions, removed := agent.catalogConditions("cluster2")
+	require.Empty(t, removed)
+	require.Len(t, conditions, 1)
+	require.Equal(t, ReasonNotProbed, conditions[0].Reason)
+
+	// the catalog is probed again once it is deployed on all the clusters
+	agent.undeployedProbes.forget("cluster1")
+	require.Equal(t, metav1.ConditionTrue, probedAvailability(agent.healthProber(), testWork(healthyFeedbacks())))
+	require.Len(t, agent.probeFields(), 5)
+}
@@ -0,0 +1,382 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"k8s.io/klog/v2"
+
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	addoninformers "open-cluster-management.io/api/client/addon/informers/externalversions"
+	addonlisters "open-cluster-management.io/api/client/addon/listers/addon/v1alpha1"
+	clusterclientset "open-cluster-management.io/api/client/cluster/clientset/versioned"
+	clusterinformers "open-cluster-management.io/api/client/cluster/informers/externalversions"
+	clusterlistersv1 "open-cluster-management.io/api/client/cluster/listers/cluster/v1"
+	workclientset "open-cluster-management.io/api/client/work/clientset/versioned"
+	workinformers "open-cluster-management.io/api/client/work/informers/externalversions"
+	worklisters "open-cluster-management.io/api/client/work/listers/work/v1"
+	workapiv1 "open-cluster-management.io/api/work/v1"
+)
+
+const (
+	// ConditionDrifted reports whether the OLM resources of the managed cluster differ from the rendered manifests.
+	ConditionDrifted = "Drifted"
+
+	ReasonDriftDetected           = "DriftDetected"
+	ReasonNoDrift                 = "NoDrift"
+	ReasonHealing                 = "Healing"
+	ReasonInvalidDriftRemediation = "InvalidDriftRemediation"
+
+	// HealAnnotation holds the time of the last healing of the OLM resources of a cluster on its ManagedClusterAddOn.
+	// It is copied to the rendered manifests so that the work agent applies them again.
+	HealAnnotation = "olm.addon.stolostron.io/healed-at"
+
+	// DriftRemediationReport only reports the drift of the OLM resources.
+	DriftRemediationReport = "Report"
+	// DriftRemediationAutoHeal applies the manifests again when the OLM resources drift.
+	DriftRemediationAutoHeal = "AutoHeal"
+
+	// healInterval is the minimal interval between two healings of the same cluster,
+	// which prevents fighting with another controller modifying the OLM resources.
+	healInterval = 5 * time.Minute
+
+	// driftResync is the interval at which the drift is computed again from the ManifestWorks.
+	driftResync = 10 * time.Minute
+)
+
+// driftProbeRules reports the fields of the deployments compared with the manifests.
+// The arguments are only reported by work agents running with the RawFeedbackJsonString feature gate.
+func driftProbeRules() workapiv1.FeedbackRule {
+	return workapiv1.FeedbackRule{
+		Type: workapiv1.JSONPathsType,
+		JsonPaths: []workapiv1.JsonPath{
+			{Name: "SpecReplicas", Path: ".spec.replicas"},
+			{Name: "Image", Path: ".spec.template.spec.containers[0].image"},
+			{Name: "Args", Path: ".spec.template.spec.containers[0].args"},
+		},
+	}
+}
+
+// parseDriftRemediation reads the DriftRemediation customized variable, Report when not set.
+func parseDriftRemediation(config addonfactory.Values) (string, error) {
+	value, ok := config["DriftRemediation"].(string)
+	if !ok || value == "" {
+		return DriftRemediationReport, nil
+	}
+	if value != DriftRemediationReport && value != DriftRemediationAutoHeal {
+		return DriftRemediationReport, fmt.Errorf("invalid value %q for DriftRemediation, %s or %s is expected", value,
+			DriftRemediationReport, DriftRemediationAutoHeal)
+	}
+	return value, nil
+}
+
+// setHealAnnotation copies the heal annotation of the ManagedClusterAddOn to the objects,
+// their change makes the work agent apply them again.
+func setHealAnnotation(objects []runtime.Object, addon *addonapiv1alpha1.ManagedClusterAddOn) error {
+	healedAt, ok := addon.Annotations[HealAnnotation]
+	if !ok {
+		return nil
+	}
+	for _, obj := range objects {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		annotations := accessor.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[HealAnnotation] = healedAt
+		accessor.SetAnnotations(annotations)
+	}
+	return nil
+}
+
+// resourceDrift lists the differences between a resource of the managed cluster and its manifest.
+type resourceDrift struct {
+	resource string
+	diffs    []string
+}
+
+func (d resourceDrift) String() string {
+	return fmt.Sprintf("%s: %s", d.resource, strings.Join(d.diffs, ", "))
+}
+
+// detectDrift compares the manifests of a ManifestWork with the state of the resources reported by the work agent.
+// Resources which are no longer available after having been applied are reported as deleted, the fields fed back
+// for the deployments are compared with their manifests. Fields not set in the manifests are only reported when
+// their value is not the default one set by the API server.
+func detectDrift(work *workapiv1.ManifestWork) []resourceDrift {
+	expected := map[string]*unstructured.Unstructured{}
+	for _, manifest := range work.Spec.Workload.Manifests {
+		u := &unstructured.Unstructured{}
+		if err := json.Unmarshal(manifest.Raw, &u.Object); err != nil {
+			continue
+		}
+		expected[manifestKey(u.GroupVersionKind().Group, u.GetKind(), u.GetNamespace(), u.GetName())] = u
+	}
+	drifts := []resourceDrift{}
+	for _, manifest := range work.Status.ResourceStatus.Manifests {
+		resource := manifest.ResourceMeta
+		name := resource.Name
+		if resource.Namespace != "" {
+			name = resource.Namespace + "/" + resource.Name
+		}
+		name = fmt.Sprintf("%s %s", resource.Kind, name)
+		if meta.IsStatusConditionTrue(manifest.Conditions, string(workapiv1.ManifestApplied)) &&
+			meta.IsStatusConditionFalse(manifest.Conditions, string(workapiv1.ManifestAvailable)) {
+			drifts = append(drifts, resourceDrift{resource: name, diffs: []string{"deleted"}})
+			continue
+		}
+		obj := expected[manifestKey(resource.Group, resource.Kind, resource.Namespace, resource.Name)]
+		if obj == nil || resource.Kind != "Deployment" || len(manifest.StatusFeedbacks.Values) == 0 {
+			continue
+		}
+		if diffs := deploymentDrift(obj, manifest.StatusFeedbacks); len(diffs) > 0 {
+			drifts = append(drifts, resourceDrift{resource: name, diffs: diffs})
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].resource < drifts[j].resource })
+	return drifts
+}
+
+// manifestKey identifies a resource in the manifests and the status of a ManifestWork.
+func manifestKey(group, kind, namespace, name string) string {
+	return strings.Join([]string{group, kind, namespace, name}, "/")
+}
+
+// deploymentDrift compares the replicas, image and arguments of a deployment with the values fed back.
+func deploymentDrift(obj *unstructured.Unstructured, result workapiv1.StatusFeedbackResult) []string {
+	deployment := &appsv1.Deployment{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, deployment); err != nil ||
+		len(deployment.Spec.Template.Spec.Containers) == 0 {
+		return nil
+	}
+	container := deployment.Spec.Template.Spec.Containers[0]
+	diffs := []string{}
+	for _, value := range result.Values {
+		switch {
+		case value.Name == "SpecReplicas" && value.Value.Integer != nil:
+			// the API server defaults the replicas to 1
+			replicas := int64(1)
+			if deployment.Spec.Replicas != nil {
+				replicas = int64(*deployment.Spec.Replicas)
+			}
+			if *value.Value.Integer != replicas {
+				diffs = append(diffs, fmt.Sprintf("replicas is %d instead of %d", *value.Value.Integer, replicas))
+			}
+		case value.Name == "Image" && value.Value.String != nil:
+			if *value.Value.String != container.Image {
+				diffs = append(diffs, fmt.Sprintf("image is %s instead of %s", *value.Value.String, container.Image))
+			}
+		case value.Name == "Args" && value.Value.JsonRaw != nil:
+			args := []string{}
+			if err := json.Unmarshal([]byte(*value.Value.JsonRaw), &args); err != nil {
+				continue
+			}
+			// empty and missing arguments are equivalent
+			if len(args) != len(container.Args) || (len(args) > 0 && !reflect.DeepEqual(args, container.Args)) {
+				diffs = append(diffs, fmt.Sprintf("args are %s instead of %s", strings.Join(args, " "),
+					strings.Join(container.Args, " ")))
+			}
+		}
+	}
+	return diffs
+}
+
+// DriftController reports the drift of the OLM resources of the managed clusters from their manifests
+// and heals them when configured to.
+type DriftController struct {
+	agent          *olmAgent
+	clusterFactory clusterinformers.SharedInformerFactory
+	addonFactory   addoninformers.SharedInformerFactory
+	workFactory    workinformers.SharedInformerFactory
+	clusterLister  clusterlistersv1.ManagedClusterLister
+	addonLister    addonlisters.ManagedClusterAddOnLister
+	workLister     worklisters.ManifestWorkLister
+	synced         []cache.InformerSynced
+	queue          workqueue.RateLimitingInterface
+	now            func() time.Time
+}
+
+// NewDriftController watches the ManagedClusterAddOns and the ManifestWorks of the addon.
+func NewDriftController(agent *olmAgent, clusterClient clusterclientset.Interface,
+	workClient workclientset.Interface) *DriftController {
+	clusterFactory := clusterinformers.NewSharedInformerFactory(clusterClient, driftResync)
+	addonFactory := addoninformers.NewSharedInformerFactory(agent.addonClient, driftResync)
+	workFactory := workinformers.NewSharedInformerFactoryWithOptions(workClient, driftResync,
+		workinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = fmt.Sprintf("%s=%s", addonapiv1alpha1.AddonLabelKey, agent.addonName)
+		}))
+	clusterInformer := clusterFactory.Cluster().V1().ManagedClusters()
+	addonInformer := addonFactory.Addon().V1alpha1().ManagedClusterAddOns()
+	workInformer := workFactory.Work().V1().ManifestWorks()
+	c := &DriftController{
+		agent:          agent,
+		clusterFactory: clusterFactory,
+		addonFactory:   addonFactory,
+		workFactory:    workFactory,
+		clusterLister:  clusterInformer.Lister(),
+		addonLister:    addonInformer.Lister(),
+		workLister:     workInformer.Lister(),
+		synced: []cache.InformerSynced{clusterInformer.Informer().HasSynced, addonInformer.Informer().HasSynced,
+			workInformer.Informer().HasSynced},
+		queue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "olm-addon-drift"),
+		now:   time.Now,
+	}
+	// the queue keys are the cluster namespaces
+	enqueue := func(obj interface{}) {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return
+		}
+		if addon, ok := obj.(*addonapiv1alpha1.ManagedClusterAddOn); ok && addon.Name != agent.addonName {
+			return
+		}
+		c.queue.Add(accessor.GetNamespace())
+	}
+	for _, informer := range []cache.SharedIndexInformer{addonInformer.Informer(), workInformer.Informer()} {
+		_, _ = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    enqueue,
+			UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+		})
+	}
+	return c
+}
+
+// Run checks the drift of the clusters until the context is cancelled.
+func (c *DriftController) Run(ctx context.Context) {
+	defer c.queue.ShutDown()
+	c.clusterFactory.Start(ctx.Done())
+	c.addonFactory.Start(ctx.Done())
+	c.workFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		return
+	}
+	go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	<-ctx.Done()
+}
+
+func (c *DriftController) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *DriftController) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+	if err := c.sync(ctx, key.(string)); err != nil {
+		klog.ErrorS(err, "Not able to check the drift of the OLM resources", "cluster", key)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// sync sets the Drifted condition of a cluster from its deploy ManifestWork
+// and records a new healing time when the resources drifted and AutoHeal is configured.
+func (c *DriftController) sync(ctx context.Context, cluster string) error {
+	addon, err := c.addonLister.ManagedClusterAddOns(cluster).Get(c.agent.addonName)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	managedCluster, err := c.clusterLister.Get(cluster)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	works, err := c.workLister.ManifestWorks(cluster).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	sort.Slice(works, func(i, j int) bool { return works[i].Name < works[j].Name })
+	drifts := []resourceDrift{}
+	for _, work := range works {
+		if strings.HasPrefix(work.Name, constants.DeployWorkNamePrefix(c.agent.addonName)) {
+			drifts = append(drifts, detectDrift(work)...)
+		}
+	}
+	if len(drifts) == 0 {
+		c.agent.updateConditions(addon, metav1.Condition{
+			Type:    ConditionDrifted,
+			Status:  metav1.ConditionFalse,
+			Reason:  ReasonNoDrift,
+			Message: "the OLM resources match the manifests",
+		})
+		return nil
+	}
+	summary := []string{}
+	for _, drift := range drifts {
+		summary = append(summary, drift.String())
+	}
+	condition := metav1.Condition{
+		Type:    ConditionDrifted,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonDriftDetected,
+		Message: strings.Join(summary, "; "),
+	}
+	config, err := c.agent.getConfig(managedCluster, addon)
+	if err != nil {
+		return err
+	}
+	remediation, err := parseDriftRemediation(config)
+	if err != nil {
+		klog.ErrorS(err, "Only reporting the drift", "cluster", cluster)
+		condition.Reason = ReasonInvalidDriftRemediation
+		condition.Message = fmt.Sprintf("%s, %s", err.Error(), condition.Message)
+	}
+	if remediation != DriftRemediationAutoHeal {
+		c.agent.updateConditions(addon, condition)
+		return nil
+	}
+	now := c.now()
+	if healedAt, err := time.Parse(time.RFC3339, addon.Annotations[HealAnnotation]); err == nil &&
+		now.Sub(healedAt) < healInterval {
+		// the previous healing may not be applied yet or another controller keeps changing the resources
+		condition.Reason = ReasonHealing
+		c.agent.updateConditions(addon, condition)
+		c.queue.AddAfter(cluster, healInterval-now.Sub(healedAt))
+		return nil
+	}
+	klog.InfoS("Healing the drifted OLM resources", "cluster", cluster, "drift", condition.Message)
+	updated := addon.DeepCopy()
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	updated.Annotations[HealAnnotation] = now.UTC().Format(time.RFC3339)
+	if _, err := c.agent.addonClient.AddonV1alpha1().ManagedClusterAddOns(cluster).Update(ctx, updated,
+		metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	condition.Reason = ReasonHealing
+	c.agent.updateConditions(addon, condition)
+	c.queue.AddAfter(cluster, healInterval)
+	return nil
+}
@@ -0,0 +1,130 @@
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterfake "open-cluster-management.io/api/client/cluster/clientset/versioned/fake"
+	workfake "open-cluster-management.io/api/client/work/clientset/versioned/fake"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	workapiv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stretchr/testify/require"
+)
+
+// driftedWork returns the ManifestWork deploying the manifests of a cluster whose olm-operator deployment
+// has been scaled and patched with another image and whose global-operators OperatorGroup has been deleted.
+func driftedWork(t *testing.T, agent *olmAgent, cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) *workapiv1.ManifestWork {
+	work := rolloutWork(t, agent, cluster, addon, 1)
+	for i, manifest := range work.Status.ResourceStatus.Manifests {
+		if manifest.ResourceMeta.Resource != "deployments" {
+			continue
+		}
+		work.Status.ResourceStatus.Manifests[i].ResourceMeta.Kind = "Deployment"
+		image := "quay.io/operator-framework/olm:v0.25.0"
+		replicas := int64(1)
+		args := `["--namespace","olm"]`
+		if manifest.ResourceMeta.Name == "olm-operator" {
+			image = "quay.io/example/olm:debug"
+			replicas = 2
+		}
+		work.Status.ResourceStatus.Manifests[i].StatusFeedbacks.Values = append(manifest.StatusFeedbacks.Values,
+			workapiv1.FeedbackValue{Name: "SpecReplicas", Value: workapiv1.FieldValue{Type: workapiv1.Integer, Integer: &replicas}},
+			workapiv1.FeedbackValue{Name: "Image", Value: workapiv1.FieldValue{Type: workapiv1.String, String: &image}},
+			workapiv1.FeedbackValue{Name: "Args", Value: workapiv1.FieldValue{Type: workapiv1.JsonRaw, JsonRaw: &args}})
+	}
+	work.Status.ResourceStatus.Manifests = append(work.Status.ResourceStatus.Manifests, workapiv1.ManifestCondition{
+		ResourceMeta: workapiv1.ManifestResourceMeta{Group: "operators.coreos.com", Kind: "OperatorGroup",
+			Resource: "operatorgroups", Name: "global-operators", Namespace: "operators"},
+		Conditions: []metav1.Condition{
+			{Type: string(workapiv1.ManifestApplied), Status: metav1.ConditionTrue},
+			{Type: string(workapiv1.ManifestAvailable), Status: metav1.ConditionFalse},
+		},
+	})
+	return work
+}
+
+func TestDetectDrift(t *testing.T) {
+	agent, addon := newTestAgent(t, PatchRenderingMode)
+	work := driftedWork(t, agent, newTestCluster("v1.27.3"), addon)
+	drifts := detectDrift(work)
+	require.Len(t, drifts, 3)
+	require.Equal(t, "Deployment olm/catalog-operator", drifts[0].resource)
+	require.Len(t, drifts[0].diffs, 2, "the image and the args differ, not the defaulted replicas")
+	require.Contains(t, drifts[0].diffs[1], "args are --namespace olm instead of")
+	require.Equal(t, "Deployment olm/olm-operator", drifts[1].resource)
+	require.Contains(t, drifts[1].diffs, "image is quay.io/example/olm:debug instead of "+
+		deploymentImages(mustManifests(t, agent, addon))["olm-operator"])
+	require.Equal(t, "OperatorGroup operators/global-operators: deleted", drifts[2].String())
+
+	require.Empty(t, detectDrift(rolloutWork(t, agent, newTestCluster("v1.27.3"), addon, 1)))
+}
+
+func mustManifests(t *testing.T, agent *olmAgent, addon *addonapiv1alpha1.ManagedClusterAddOn) []runtime.Object {
+	objects, err := agent.Manifests(newTestCluster("v1.27.3"), addon)
+	require.NoError(t, err)
+	return objects
+}
+
+func TestDriftControllerSync(t *testing.T) {
+	for _, remediation := range []string{"", DriftRemediationAutoHeal} {
+		t.Run(remediation, func(t *testing.T) {
+			ctx := context.Background()
+			variables := []addonapiv1alpha1.CustomizedVariable{}
+			if remediation != "" {
+				variables = append(variables, addonapiv1alpha1.CustomizedVariable{Name: "DriftRemediation", Value: remediation})
+			}
+			agent, addon := newTestAgent(t, PatchRenderingMode, variables...)
+			cluster := newTestCluster("v1.27.3")
+			controller := NewDriftController(agent, clusterfake.NewSimpleClientset(), workfake.NewSimpleClientset())
+			now := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+			controller.now = func() time.Time { return now }
+			require.NoError(t, controller.clusterFactory.Cluster().V1().ManagedClusters().Informer().GetIndexer().Add(cluster))
+			require.NoError(t, controller.workFactory.Work().V1().ManifestWorks().Informer().GetIndexer().
+				Add(driftedWork(t, agent, cluster, addon)))
+			addonIndexer := controller.addonFactory.Addon().V1alpha1().ManagedClusterAddOns().Informer().GetIndexer()
+			latest := func() *addonapiv1alpha1.ManagedClusterAddOn {
+				addon, err := agent.addonClient.AddonV1alpha1().ManagedClusterAddOns("cluster1").Get(ctx, "olm-addon",
+					metav1.GetOptions{})
+				require.NoError(t, err)
+				return addon
+			}
+			sync := func() *metav1.Condition {
+				require.NoError(t, addonIndexer.Update(latest()))
+				require.NoError(t, controller.sync(ctx, "cluster1"))
+				return meta.FindStatusCondition(latest().Status.Conditions, ConditionDrifted)
+			}
+
+			condition := sync()
+			require.NotNil(t, condition)
+			require.Equal(t, metav1.ConditionTrue, condition.Status)
+			require.Contains(t, condition.Message, "OperatorGroup operators/global-operators: deleted")
+			if remediation == "" {
+				require.Equal(t, ReasonDriftDetected, condition.Reason)
+				require.NotContains(t, latest().Annotations, HealAnnotation, "only reported")
+				return
+			}
+			require.Equal(t, ReasonHealing, condition.Reason)
+			require.Equal(t, "2023-06-01T10:00:00Z", latest().Annotations[HealAnnotation])
+			for _, obj := range mustManifests(t, agent, latest()) {
+				accessor, err := meta.Accessor(obj)
+				require.NoError(t, err)
+				require.Equal(t, "2023-06-01T10:00:00Z", accessor.GetAnnotations()[HealAnnotation])
+			}
+
+			// the resources are not healed again before the heal interval
+			now = now.Add(time.Minute)
+			require.Equal(t, ReasonHealing, sync().Reason)
+			require.Equal(t, "2023-06-01T10:00:00Z", latest().Annotations[HealAnnotation])
+			now = now.Add(5 * time.Minute)
+			sync()
+			require.Equal(t, "2023-06-01T10:06:00Z", latest().Annotations[HealAnnotation])
+		})
+	}
+}
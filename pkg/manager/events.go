@@ -0,0 +1,22 @@
+package manager
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+)
+
+// NewEventRecorder returns a recorder of the hub events about the ManagedClusterAddOns.
+func NewEventRecorder(kubeClient kubernetes.Interface, addonName string) (record.EventRecorder, error) {
+	eventScheme := runtime.NewScheme()
+	if err := addonapiv1alpha1.Install(eventScheme); err != nil {
+		return nil, err
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	return broadcaster.NewRecorder(eventScheme, corev1.EventSource{Component: addonName}), nil
+}
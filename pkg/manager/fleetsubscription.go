@@ -0,0 +1,728 @@
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"k8s.io/klog/v2"
+
+	olmv1 "github.com/operator-framework/api/pkg/operators/v1"
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+
+	clusterclientset "open-cluster-management.io/api/client/cluster/clientset/versioned"
+	clusterinformers "open-cluster-management.io/api/client/cluster/informers/externalversions"
+	clusterlisters "open-cluster-management.io/api/client/cluster/listers/cluster/v1beta1"
+	workclientset "open-cluster-management.io/api/client/work/clientset/versioned"
+	workinformers "open-cluster-management.io/api/client/work/informers/externalversions"
+	worklisters "open-cluster-management.io/api/client/work/listers/work/v1"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	workapiv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stolostron/olm-addon/pkg/apis/v1alpha1"
+)
+
+const (
+	// FleetSubscriptionLabel is set on the ManifestWorks deploying FleetSubscriptions.
+	FleetSubscriptionLabel = "olm.addon.stolostron.io/fleet-subscription"
+	// FleetSubscriptionAnnotation holds the namespace/name key of the FleetSubscription deployed by a ManifestWork.
+	FleetSubscriptionAnnotation = "olm.addon.stolostron.io/fleet-subscription"
+
+	// ConditionManifestWorksSynced reports whether the ManifestWorks of a FleetSubscription are up to date.
+	ConditionManifestWorksSynced = "ManifestWorksSynced"
+
+	ReasonManifestWorksSynced         = "ManifestWorksSynced"
+	ReasonInvalidSpec                 = "InvalidSpec"
+	ReasonConflictingTargetNamespaces = "ConflictingTargetNamespaces"
+
+	// fleetSubscriptionResync is the interval at which the FleetSubscriptions are reconciled.
+	fleetSubscriptionResync = 10 * time.Minute
+)
+
+// fleetSubscriptionWorkName returns the name of the ManifestWorks of a FleetSubscription.
+// It is derived from a hash as namespace and name together may exceed the length allowed for a name.
+func fleetSubscriptionWorkName(key string) string {
+	return fmt.Sprintf("fleet-subscription-%x", sha256.Sum256([]byte(key)))[:36]
+}
+
+// decodeFleetSubscription converts a FleetSubscription from its unstructured representation.
+func decodeFleetSubscription(obj runtime.Object) (*v1alpha1.FleetSubscription, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+	subscription := &v1alpha1.FleetSubscription{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, subscription); err != nil {
+		return nil, fmt.Errorf("not able to decode FleetSubscription %s/%s: %w", u.GetNamespace(), u.GetName(), err)
+	}
+	return subscription, nil
+}
+
+// validateFleetSubscription checks that the resources of the managed clusters can be created from the spec.
+// Operators cannot be installed into the OLM namespace, whose OperatorGroup only targets OLM itself.
+func validateFleetSubscription(spec v1alpha1.FleetSubscriptionSpec, namespaces Namespaces) error {
+	for field, value := range map[string]string{"placement": spec.Placement, "package": spec.Package, "source": spec.Source} {
+		if value == "" {
+			return fmt.Errorf("%s is required", field)
+		}
+	}
+	names := append([]string{spec.Namespace}, spec.TargetNamespaces...)
+	if spec.SourceNamespace != "" {
+		names = append(names, spec.SourceNamespace)
+	}
+	for _, namespace := range names {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace %q: %s", namespace, strings.Join(errs, ", "))
+		}
+	}
+	if spec.Namespace == namespaces.OLM {
+		return fmt.Errorf("operators cannot be installed into the OLM namespace %s", namespaces.OLM)
+	}
+	switch spec.InstallPlanApproval {
+	case "", olmv1alpha1.ApprovalAutomatic, olmv1alpha1.ApprovalManual:
+	default:
+		return fmt.Errorf("invalid installPlanApproval %q, Automatic or Manual expected", spec.InstallPlanApproval)
+	}
+	return nil
+}
+
+// operatorGroupTargets returns the sorted target namespaces of the OperatorGroup of a FleetSubscription.
+func operatorGroupTargets(spec v1alpha1.FleetSubscriptionSpec) []string {
+	targets := sets.NewString(spec.TargetNamespaces...).List()
+	if len(targets) == 0 {
+		return nil
+	}
+	return targets
+}
+
+// precedes tells whether a FleetSubscription was created before another one, by key for the same creation time.
+func precedes(subscription, other *v1alpha1.FleetSubscription) bool {
+	if !subscription.CreationTimestamp.Equal(&other.CreationTimestamp) {
+		return subscription.CreationTimestamp.Before(&other.CreationTimestamp)
+	}
+	return subscription.Namespace+"/"+subscription.Name < other.Namespace+"/"+other.Name
+}
+
+// subscriptionIdentifier identifies the Subscription of a FleetSubscription in its ManifestWorks.
+func subscriptionIdentifier(subscription *v1alpha1.FleetSubscription) workapiv1.ResourceIdentifier {
+	return workapiv1.ResourceIdentifier{
+		Group:     olmv1alpha1.GroupName,
+		Resource:  "subscriptions",
+		Name:      subscription.Name,
+		Namespace: subscription.Spec.Namespace,
+	}
+}
+
+// csvIdentifier identifies the installed ClusterServiceVersion in the ManifestWorks of a FleetSubscription.
+func csvIdentifier(subscription *v1alpha1.FleetSubscription, csv string) workapiv1.ResourceIdentifier {
+	return workapiv1.ResourceIdentifier{
+		Group:     olmv1alpha1.GroupName,
+		Resource:  "clusterserviceversions",
+		Name:      csv,
+		Namespace: subscription.Spec.Namespace,
+	}
+}
+
+// parseSubscriptionFeedback returns the status of a Subscription.
+func parseSubscriptionFeedback(result *workapiv1.StatusFeedbackResult) subscriptionStatus {
+	status := subscriptionStatus{}
+	if result == nil {
+		return status
+	}
+	for _, value := range result.Values {
+		if value.Value.String == nil {
+			continue
+		}
+		switch value.Name {
+		case "State":
+			status.state = *value.Value.String
+		case "InstalledCSV":
+			status.installedCSV = *value.Value.String
+		case "CurrentCSV":
+			status.currentCSV = *value.Value.String
+		case "InstallPlan":
+			status.installPlan = *value.Value.String
+		}
+	}
+	return status
+}
+
+// fleetSubscriptionWork renders the ManifestWork creating the Subscription of a FleetSubscription on a cluster.
+// The namespace and the OperatorGroup are not created when the operator is installed into the namespace of the
+// global operator group. The OperatorGroup is named after the namespace so that the FleetSubscriptions of the
+// namespace share it. They are orphaned on deletion as they may be shared with other operators.
+// Once reported by the Subscription, the installed ClusterServiceVersion is added with an empty server side apply
+// so that its phase is reported without the ManifestWork managing any of its fields. It is deleted with the
+// Subscription, which uninstalls the operator.
+// The InstallPlan a Subscription with manual approval waits for is added the same way to report it,
+// with the approval set once approved from the hub. It is orphaned as OLM garbage collects it.
+func fleetSubscriptionWork(subscription *v1alpha1.FleetSubscription, cluster string, namespaces Namespaces,
+	observed subscriptionStatus, approved bool) (*workapiv1.ManifestWork, error) {
+	spec := subscription.Spec
+	objects := []runtime.Object{}
+	orphans := []workapiv1.OrphaningRule{}
+	if spec.Namespace != namespaces.Operators {
+		objects = append(objects,
+			&corev1.Namespace{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+				ObjectMeta: metav1.ObjectMeta{Name: spec.Namespace},
+			},
+			&olmv1.OperatorGroup{
+				TypeMeta:   metav1.TypeMeta{APIVersion: olmv1.GroupVersion.String(), Kind: "OperatorGroup"},
+				ObjectMeta: metav1.ObjectMeta{Name: spec.Namespace, Namespace: spec.Namespace},
+				Spec:       olmv1.OperatorGroupSpec{TargetNamespaces: operatorGroupTargets(spec)},
+			})
+		orphans = append(orphans,
+			workapiv1.OrphaningRule{Resource: "namespaces", Name: spec.Namespace},
+			workapiv1.OrphaningRule{Group: olmv1.GroupVersion.Group, Resource: "operatorgroups", Name: spec.Namespace,
+				Namespace: spec.Namespace})
+	}
+	sourceNamespace := spec.SourceNamespace
+	if sourceNamespace == "" {
+		sourceNamespace = namespaces.OLM
+	}
+	objects = append(objects, &olmv1alpha1.Subscription{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: olmv1alpha1.SchemeGroupVersion.String(),
+			Kind:       olmv1alpha1.SubscriptionKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: subscription.Name, Namespace: spec.Namespace},
+		Spec: &olmv1alpha1.SubscriptionSpec{
+			CatalogSource:          spec.Source,
+			CatalogSourceNamespace: sourceNamespace,
+			Package:                spec.Package,
+			Channel:                spec.Channel,
+			StartingCSV:            spec.StartingCSV,
+			InstallPlanApproval:    spec.InstallPlanApproval,
+		},
+	})
+	configs := []workapiv1.ManifestConfigOption{{
+		ResourceIdentifier: subscriptionIdentifier(subscription),
+		FeedbackRules: []workapiv1.FeedbackRule{{
+			Type: workapiv1.JSONPathsType,
+			JsonPaths: []workapiv1.JsonPath{
+				{Name: "State", Path: ".status.state"},
+				{Name: "InstalledCSV", Path: ".status.installedCSV"},
+				{Name: "CurrentCSV", Path: ".status.currentCSV"},
+				{Name: "InstallPlan", Path: ".status.installPlanRef.name"},
+			},
+		}},
+	}}
+	installedCSV := observed.installedCSV
+	if installedCSV != "" && len(validation.IsDNS1123Subdomain(installedCSV)) == 0 {
+		objects = append(objects, &metav1.PartialObjectMetadata{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: olmv1alpha1.SchemeGroupVersion.String(),
+				Kind:       olmv1alpha1.ClusterServiceVersionKind,
+			},
+			ObjectMeta: metav1.ObjectMeta{Name: installedCSV, Namespace: spec.Namespace},
+		})
+		configs = append(configs, workapiv1.ManifestConfigOption{
+			ResourceIdentifier: csvIdentifier(subscription, installedCSV),
+			FeedbackRules:      []workapiv1.FeedbackRule{csvFeedbackRule()},
+			UpdateStrategy: &workapiv1.UpdateStrategy{
+				Type:            workapiv1.UpdateStrategyTypeServerSideApply,
+				ServerSideApply: &workapiv1.ServerSideApplyConfig{FieldManager: "work-agent-fleet-subscription"},
+			},
+		})
+	}
+	if observed.pendingInstallPlan() {
+		objects = append(objects, installPlanManifest(spec.Namespace, observed.installPlan, approved))
+		identifier := installPlanIdentifier(subscription, observed.installPlan)
+		configs = append(configs, workapiv1.ManifestConfigOption{
+			ResourceIdentifier: identifier,
+			FeedbackRules:      []workapiv1.FeedbackRule{installPlanFeedbackRule()},
+			UpdateStrategy: &workapiv1.UpdateStrategy{
+				Type:            workapiv1.UpdateStrategyTypeServerSideApply,
+				ServerSideApply: &workapiv1.ServerSideApplyConfig{FieldManager: "work-agent-fleet-subscription"},
+			},
+		})
+		orphans = append(orphans, workapiv1.OrphaningRule{Group: identifier.Group, Resource: identifier.Resource,
+			Name: identifier.Name, Namespace: identifier.Namespace})
+	}
+	manifests := []workapiv1.Manifest{}
+	for _, obj := range objects {
+		raw, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, workapiv1.Manifest{RawExtension: runtime.RawExtension{Raw: raw}})
+	}
+	work := &workapiv1.ManifestWork{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fleetSubscriptionWorkName(subscription.Namespace + "/" + subscription.Name),
+			Namespace:   cluster,
+			Labels:      map[string]string{FleetSubscriptionLabel: "true"},
+			Annotations: map[string]string{FleetSubscriptionAnnotation: subscription.Namespace + "/" + subscription.Name},
+		},
+		Spec: workapiv1.ManifestWorkSpec{
+			Workload:        workapiv1.ManifestsTemplate{Manifests: manifests},
+			ManifestConfigs: configs,
+		},
+	}
+	if len(orphans) > 0 {
+		work.Spec.DeleteOption = &workapiv1.DeleteOption{
+			PropagationPolicy: workapiv1.DeletePropagationPolicyTypeSelectivelyOrphan,
+			SelectivelyOrphan: &workapiv1.SelectivelyOrphan{OrphaningRules: orphans},
+		}
+	}
+	return work, nil
+}
+
+// clusterSubscriptionStatus reports the status of a FleetSubscription on a cluster from the status of its ManifestWork.
+func clusterSubscriptionStatus(subscription *v1alpha1.FleetSubscription, cluster string,
+	work *workapiv1.ManifestWork) v1alpha1.ClusterSubscriptionStatus {
+	status := v1alpha1.ClusterSubscriptionStatus{Cluster: cluster}
+	if work == nil {
+		status.Message = "ManifestWork not created yet"
+		return status
+	}
+	observed := parseSubscriptionFeedback(findFeedback(subscriptionIdentifier(subscription), work))
+	status.State, status.InstalledCSV = observed.state, observed.installedCSV
+	if observed.pendingInstallPlan() {
+		status.PendingInstallPlan = pendingInstallPlanStatus(subscription, observed, work)
+	}
+	if status.InstalledCSV != "" {
+		if result := findFeedback(csvIdentifier(subscription, status.InstalledCSV), work); result != nil {
+			csv := parseCSVFeedback(*result)
+			status.Phase, status.Reason, status.Message = csv.phase, csv.reason, csv.message
+		}
+	}
+	if applied := meta.FindStatusCondition(work.Status.Conditions, workapiv1.WorkApplied); applied != nil &&
+		applied.Status == metav1.ConditionFalse && status.Message == "" {
+		status.Message = applied.Message
+	}
+	return status
+}
+
+// FleetSubscriptionController creates the ManifestWorks installing the operators of the FleetSubscriptions
+// on the managed clusters selected by their Placement and reports their status.
+type FleetSubscriptionController struct {
+	dynamicClient       dynamic.Interface
+	workClient          workclientset.Interface
+	namespaces          Namespaces
+	subscriptionFactory dynamicinformer.DynamicSharedInformerFactory
+	clusterFactory      clusterinformers.SharedInformerFactory
+	workFactory         workinformers.SharedInformerFactory
+	subscriptionLister  cache.GenericLister
+	approvalLister      cache.GenericLister
+	decisionLister      clusterlisters.PlacementDecisionLister
+	workLister          worklisters.ManifestWorkLister
+	synced              []cache.InformerSynced
+	queue               workqueue.RateLimitingInterface
+}
+
+// NewFleetSubscriptionController watches the FleetSubscriptions, their InstallPlanApprovals, the PlacementDecisions
+// and the ManifestWorks deploying the FleetSubscriptions. namespaces are the namespaces OLM is installed into on the managed clusters.
+func NewFleetSubscriptionController(dynamicClient dynamic.Interface, clusterClient clusterclientset.Interface,
+	workClient workclientset.Interface, namespaces Namespaces) *FleetSubscriptionController {
+	subscriptionFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, fleetSubscriptionResync)
+	clusterFactory := clusterinformers.NewSharedInformerFactory(clusterClient, fleetSubscriptionResync)
+	workFactory := workinformers.NewSharedInformerFactoryWithOptions(workClient, fleetSubscriptionResync,
+		workinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = FleetSubscriptionLabel
+		}))
+	subscriptionInformer := subscriptionFactory.ForResource(v1alpha1.FleetSubscriptionGVR)
+	approvalInformer := subscriptionFactory.ForResource(v1alpha1.InstallPlanApprovalGVR)
+	decisionInformer := clusterFactory.Cluster().V1beta1().PlacementDecisions()
+	workInformer := workFactory.Work().V1().ManifestWorks()
+	c := &FleetSubscriptionController{
+		dynamicClient:       dynamicClient,
+		workClient:          workClient,
+		namespaces:          namespaces,
+		subscriptionFactory: subscriptionFactory,
+		clusterFactory:      clusterFactory,
+		workFactory:         workFactory,
+		subscriptionLister:  subscriptionInformer.Lister(),
+		approvalLister:      approvalInformer.Lister(),
+		decisionLister:      decisionInformer.Lister(),
+		workLister:          workInformer.Lister(),
+		synced: []cache.InformerSynced{subscriptionInformer.Informer().HasSynced, approvalInformer.Informer().HasSynced,
+			decisionInformer.Informer().HasSynced, workInformer.Informer().HasSynced},
+		queue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "olm-addon-fleet-subscriptions"),
+	}
+	enqueue := func(obj interface{}) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			utilruntime.HandleError(err)
+			return
+		}
+		c.queue.Add(key)
+	}
+	// the FleetSubscriptions sharing the OperatorGroup of a namespace are enqueued together
+	// as the target namespaces of one of them may conflict with the others
+	enqueueSubscription := func(obj interface{}) {
+		enqueue(obj)
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		subscription, err := decodeFleetSubscription(obj.(runtime.Object))
+		if err != nil {
+			utilruntime.HandleError(err)
+			return
+		}
+		c.enqueueNamespace(subscription.Spec.Namespace)
+	}
+	_, _ = subscriptionInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueueSubscription,
+		UpdateFunc: func(old, obj interface{}) {
+			enqueueSubscription(old)
+			enqueueSubscription(obj)
+		},
+		DeleteFunc: enqueueSubscription,
+	})
+	enqueueApproval := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		approval, err := decodeInstallPlanApproval(obj.(runtime.Object))
+		if err != nil {
+			utilruntime.HandleError(err)
+			return
+		}
+		c.queue.Add(approval.Namespace + "/" + approval.Spec.FleetSubscription)
+	}
+	_, _ = approvalInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueueApproval,
+		UpdateFunc: func(_, obj interface{}) { enqueueApproval(obj) },
+		DeleteFunc: enqueueApproval,
+	})
+	enqueueDecision := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		if decision, ok := obj.(*clusterv1beta1.PlacementDecision); ok {
+			c.enqueuePlacement(decision.Namespace, decision.Labels[clusterv1beta1.PlacementLabel])
+		}
+	}
+	_, _ = decisionInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueueDecision,
+		UpdateFunc: func(_, obj interface{}) { enqueueDecision(obj) },
+		DeleteFunc: enqueueDecision,
+	})
+	enqueueWork := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		if work, ok := obj.(*workapiv1.ManifestWork); ok && work.Annotations[FleetSubscriptionAnnotation] != "" {
+			c.queue.Add(work.Annotations[FleetSubscriptionAnnotation])
+		}
+	}
+	_, _ = workInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueueWork,
+		UpdateFunc: func(_, obj interface{}) { enqueueWork(obj) },
+		DeleteFunc: enqueueWork,
+	})
+	return c
+}
+
+// enqueuePlacement enqueues the FleetSubscriptions referencing a Placement directly or through an InstallPlanApproval.
+func (c *FleetSubscriptionController) enqueuePlacement(namespace, placement string) {
+	approvals, err := c.approvalLister.ByNamespace(namespace).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, obj := range approvals {
+		approval, err := decodeInstallPlanApproval(obj)
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		if approval.Spec.Placement == placement {
+			c.queue.Add(approval.Namespace + "/" + approval.Spec.FleetSubscription)
+		}
+	}
+	objects, err := c.subscriptionLister.ByNamespace(namespace).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, obj := range objects {
+		subscription, err := decodeFleetSubscription(obj)
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		if subscription.Spec.Placement == placement {
+			c.queue.Add(subscription.Namespace + "/" + subscription.Name)
+		}
+	}
+}
+
+// enqueueNamespace enqueues the FleetSubscriptions installing operators into a namespace of the managed clusters.
+func (c *FleetSubscriptionController) enqueueNamespace(namespace string) {
+	objects, err := c.subscriptionLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, obj := range objects {
+		subscription, err := decodeFleetSubscription(obj)
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		if subscription.Spec.Namespace == namespace {
+			c.queue.Add(subscription.Namespace + "/" + subscription.Name)
+		}
+	}
+}
+
+// conflictingTargets returns why the target namespaces of a FleetSubscription conflict, if they do, with the ones
+// of the first FleetSubscription created for the same namespace, which define its shared OperatorGroup.
+// FleetSubscriptions with an invalid spec are not considered as they are not deployed.
+func (c *FleetSubscriptionController) conflictingTargets(subscription *v1alpha1.FleetSubscription) (string, error) {
+	if subscription.Spec.Namespace == c.namespaces.Operators {
+		return "", nil
+	}
+	objects, err := c.subscriptionLister.List(labels.Everything())
+	if err != nil {
+		return "", err
+	}
+	first := subscription
+	for _, obj := range objects {
+		other, err := decodeFleetSubscription(obj)
+		if err != nil {
+			return "", err
+		}
+		if other.Spec.Namespace == subscription.Spec.Namespace && precedes(other, first) &&
+			validateFleetSubscription(other.Spec, c.namespaces) == nil {
+			first = other
+		}
+	}
+	targets, firstTargets := operatorGroupTargets(subscription.Spec), operatorGroupTargets(first.Spec)
+	if equality.Semantic.DeepEqual(targets, firstTargets) {
+		return "", nil
+	}
+	return fmt.Sprintf("the target namespaces %v of the OperatorGroup of namespace %s differ from %v of "+
+		"FleetSubscription %s/%s", targets, subscription.Spec.Namespace, firstTargets, first.Namespace, first.Name), nil
+}
+
+// Run processes the FleetSubscriptions until the context is cancelled.
+func (c *FleetSubscriptionController) Run(ctx context.Context) {
+	defer c.queue.ShutDown()
+	c.subscriptionFactory.Start(ctx.Done())
+	c.clusterFactory.Start(ctx.Done())
+	c.workFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		return
+	}
+	go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	<-ctx.Done()
+}
+
+func (c *FleetSubscriptionController) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *FleetSubscriptionController) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+	if err := c.sync(ctx, key.(string)); err != nil {
+		klog.ErrorS(err, "Not able to sync the FleetSubscription", "fleetSubscription", key)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// works returns the ManifestWorks of a FleetSubscription by cluster.
+func (c *FleetSubscriptionController) works(key string) (map[string]*workapiv1.ManifestWork, error) {
+	all, err := c.workLister.List(labels.SelectorFromSet(labels.Set{FleetSubscriptionLabel: "true"}))
+	if err != nil {
+		return nil, err
+	}
+	works := map[string]*workapiv1.ManifestWork{}
+	for _, work := range all {
+		if work.Annotations[FleetSubscriptionAnnotation] == key {
+			works[work.Namespace] = work
+		}
+	}
+	return works, nil
+}
+
+// decidedClusters returns the clusters selected by a Placement, sorted by name.
+func (c *FleetSubscriptionController) decidedClusters(namespace, placement string) ([]string, error) {
+	return decidedClusters(c.decisionLister, namespace, placement)
+}
+
+// decidedClusters returns the clusters selected by a Placement from its PlacementDecisions, sorted by name.
+func decidedClusters(lister clusterlisters.PlacementDecisionLister, namespace, placement string) ([]string, error) {
+	decisions, err := lister.PlacementDecisions(namespace).List(
+		labels.SelectorFromSet(labels.Set{clusterv1beta1.PlacementLabel: placement}))
+	if err != nil {
+		return nil, err
+	}
+	clusters := []string{}
+	for _, decision := range decisions {
+		for _, d := range decision.Status.Decisions {
+			clusters = append(clusters, d.ClusterName)
+		}
+	}
+	sort.Strings(clusters)
+	return clusters, nil
+}
+
+// sync creates or updates the ManifestWorks of a FleetSubscription on the decided clusters, deletes the ManifestWorks
+// of the clusters no longer decided or of deleted FleetSubscriptions and updates the status of the FleetSubscription.
+func (c *FleetSubscriptionController) sync(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	works, err := c.works(key)
+	if err != nil {
+		return err
+	}
+	obj, err := c.subscriptionLister.ByNamespace(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return c.deleteWorks(ctx, works, nil)
+	}
+	if err != nil {
+		return err
+	}
+	subscription, err := decodeFleetSubscription(obj)
+	if err != nil {
+		return err
+	}
+	status := v1alpha1.FleetSubscriptionStatus{
+		Conditions: append([]metav1.Condition{}, subscription.Status.Conditions...),
+	}
+	if err := validateFleetSubscription(subscription.Spec, c.namespaces); err != nil {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    ConditionManifestWorksSynced,
+			Status:  metav1.ConditionFalse,
+			Reason:  ReasonInvalidSpec,
+			Message: err.Error(),
+		})
+		return c.updateStatus(ctx, obj.(*unstructured.Unstructured), subscription, status)
+	}
+	conflict, err := c.conflictingTargets(subscription)
+	if err != nil {
+		return err
+	}
+	if conflict != "" {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    ConditionManifestWorksSynced,
+			Status:  metav1.ConditionFalse,
+			Reason:  ReasonConflictingTargetNamespaces,
+			Message: conflict,
+		})
+		return c.updateStatus(ctx, obj.(*unstructured.Unstructured), subscription, status)
+	}
+	clusters, err := c.decidedClusters(namespace, subscription.Spec.Placement)
+	if err != nil {
+		return err
+	}
+	approvals, err := c.installPlanApprovals(subscription)
+	if err != nil {
+		return err
+	}
+	decided := map[string]bool{}
+	for _, cluster := range clusters {
+		decided[cluster] = true
+		existing := works[cluster]
+		observed := subscriptionStatus{}
+		if existing != nil {
+			observed = parseSubscriptionFeedback(findFeedback(subscriptionIdentifier(subscription), existing))
+		}
+		approved := observed.currentCSV != "" && approvals[cluster][observed.currentCSV]
+		work, err := fleetSubscriptionWork(subscription, cluster, c.namespaces, observed, approved)
+		if err != nil {
+			return err
+		}
+		if err := c.applyWork(ctx, existing, work); err != nil {
+			return err
+		}
+		status.Clusters = append(status.Clusters, clusterSubscriptionStatus(subscription, cluster, existing))
+	}
+	if err := c.deleteWorks(ctx, works, decided); err != nil {
+		return err
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:    ConditionManifestWorksSynced,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonManifestWorksSynced,
+		Message: fmt.Sprintf("ManifestWorks applied to %d clusters selected by Placement %s", len(clusters), subscription.Spec.Placement),
+	})
+	return c.updateStatus(ctx, obj.(*unstructured.Unstructured), subscription, status)
+}
+
+// applyWork creates the ManifestWork or updates the existing one when its spec differs.
+func (c *FleetSubscriptionController) applyWork(ctx context.Context, existing, work *workapiv1.ManifestWork) error {
+	if existing == nil {
+		_, err := c.workClient.WorkV1().ManifestWorks(work.Namespace).Create(ctx, work, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	if equality.Semantic.DeepEqual(existing.Spec, work.Spec) {
+		return nil
+	}
+	updated := existing.DeepCopy()
+	updated.Spec = work.Spec
+	_, err := c.workClient.WorkV1().ManifestWorks(work.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
+	return err
+}
+
+// deleteWorks deletes the ManifestWorks of the clusters which are not kept.
+func (c *FleetSubscriptionController) deleteWorks(ctx context.Context, works map[string]*workapiv1.ManifestWork,
+	keep map[string]bool) error {
+	for cluster, work := range works {
+		if keep[cluster] {
+			continue
+		}
+		err := c.workClient.WorkV1().ManifestWorks(work.Namespace).Delete(ctx, work.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		klog.V(1).InfoS("Deleted ManifestWork of FleetSubscription", "cluster", cluster,
+			"fleetSubscription", work.Annotations[FleetSubscriptionAnnotation])
+	}
+	return nil
+}
+
+// updateStatus updates the status of the FleetSubscription if it changed.
+func (c *FleetSubscriptionController) updateStatus(ctx context.Context, u *unstructured.Unstructured,
+	subscription *v1alpha1.FleetSubscription, status v1alpha1.FleetSubscriptionStatus) error {
+	if equality.Semantic.DeepEqual(subscription.Status, status) {
+		return nil
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return err
+	}
+	updated := u.DeepCopy()
+	updated.Object["status"] = content
+	_, err = c.dynamicClient.Resource(v1alpha1.FleetSubscriptionGVR).Namespace(u.GetNamespace()).UpdateStatus(ctx,
+		updated, metav1.UpdateOptions{})
+	return err
+}
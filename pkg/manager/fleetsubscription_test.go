@@ -0,0 +1,233 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clusterfake "open-cluster-management.io/api/client/cluster/clientset/versioned/fake"
+	workfake "open-cluster-management.io/api/client/work/clientset/versioned/fake"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	workapiv1 "open-cluster-management.io/api/work/v1"
+
+	olmv1 "github.com/operator-framework/api/pkg/operators/v1"
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stolostron/olm-addon/pkg/apis/v1alpha1"
+)
+
+func newTestFleetSubscription() *v1alpha1.FleetSubscription {
+	return &v1alpha1.FleetSubscription{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "FleetSubscription"},
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd", Namespace: "fleet"},
+		Spec: v1alpha1.FleetSubscriptionSpec{
+			Placement: "all",
+			Package:   "etcd",
+			Channel:   "singlenamespace-alpha",
+			Source:    "operatorhubio-catalog",
+			Namespace: "etcd",
+		},
+	}
+}
+
+// manifestKinds returns the kinds of the manifests of a ManifestWork.
+func manifestKinds(t *testing.T, work *workapiv1.ManifestWork) []string {
+	kinds := []string{}
+	for _, manifest := range work.Spec.Workload.Manifests {
+		u := &unstructured.Unstructured{}
+		require.NoError(t, json.Unmarshal(manifest.Raw, &u.Object))
+		kinds = append(kinds, u.GetKind())
+	}
+	return kinds
+}
+
+func TestValidateFleetSubscription(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(spec *v1alpha1.FleetSubscriptionSpec)
+		err    string
+	}{
+		{name: "valid", modify: func(spec *v1alpha1.FleetSubscriptionSpec) {}},
+		{name: "no placement", modify: func(spec *v1alpha1.FleetSubscriptionSpec) { spec.Placement = "" }, err: "placement is required"},
+		{name: "no package", modify: func(spec *v1alpha1.FleetSubscriptionSpec) { spec.Package = "" }, err: "package is required"},
+		{name: "invalid namespace", modify: func(spec *v1alpha1.FleetSubscriptionSpec) { spec.Namespace = "" }, err: "invalid namespace \"\""},
+		{
+			name:   "invalid target namespace",
+			modify: func(spec *v1alpha1.FleetSubscriptionSpec) { spec.TargetNamespaces = []string{"Apps"} },
+			err:    "invalid namespace \"Apps\"",
+		},
+		{
+			name:   "OLM namespace",
+			modify: func(spec *v1alpha1.FleetSubscriptionSpec) { spec.Namespace = DefaultOLMNamespace },
+			err:    "operators cannot be installed into the OLM namespace olm",
+		},
+		{
+			name:   "invalid approval",
+			modify: func(spec *v1alpha1.FleetSubscriptionSpec) { spec.InstallPlanApproval = "Sometimes" },
+			err:    "invalid installPlanApproval \"Sometimes\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := newTestFleetSubscription().Spec
+			tt.modify(&spec)
+			err := validateFleetSubscription(spec, DefaultNamespaces())
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestFleetSubscriptionWork(t *testing.T) {
+	subscription := newTestFleetSubscription()
+	work, err := fleetSubscriptionWork(subscription, "cluster1", DefaultNamespaces(), subscriptionStatus{}, false)
+	require.NoError(t, err)
+	require.Equal(t, "cluster1", work.Namespace)
+	require.Equal(t, "fleet/etcd", work.Annotations[FleetSubscriptionAnnotation])
+	require.Len(t, work.Name, 36)
+	require.Equal(t, []string{"Namespace", "OperatorGroup", "Subscription"}, manifestKinds(t, work))
+	require.Len(t, work.Spec.DeleteOption.SelectivelyOrphan.OrphaningRules, 2)
+	require.Len(t, work.Spec.ManifestConfigs, 1)
+	group := &olmv1.OperatorGroup{}
+	require.NoError(t, json.Unmarshal(work.Spec.Workload.Manifests[1].Raw, group))
+	require.Equal(t, "etcd", group.Name, "the OperatorGroup is shared by the FleetSubscriptions of the namespace")
+
+	sub := &olmv1alpha1.Subscription{}
+	require.NoError(t, json.Unmarshal(work.Spec.Workload.Manifests[2].Raw, sub))
+	require.Equal(t, "etcd", sub.Namespace)
+	require.Equal(t, DefaultOLMNamespace, sub.Spec.CatalogSourceNamespace)
+	require.Equal(t, "singlenamespace-alpha", sub.Spec.Channel)
+
+	subscription.Spec.Namespace = DefaultOperatorsNamespace
+	work, err = fleetSubscriptionWork(subscription, "cluster1", DefaultNamespaces(), subscriptionStatus{installedCSV: "etcdoperator.v0.9.4"}, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Subscription", "ClusterServiceVersion"}, manifestKinds(t, work))
+	require.Nil(t, work.Spec.DeleteOption)
+	require.Len(t, work.Spec.ManifestConfigs, 2)
+	require.Equal(t, "etcdoperator.v0.9.4", work.Spec.ManifestConfigs[1].ResourceIdentifier.Name)
+	require.Equal(t, workapiv1.UpdateStrategyTypeServerSideApply, work.Spec.ManifestConfigs[1].UpdateStrategy.Type)
+}
+
+func TestFleetSubscriptionControllerSync(t *testing.T) {
+	ctx := context.Background()
+	subscription := newTestFleetSubscription()
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(subscription)
+	require.NoError(t, err)
+	u := &unstructured.Unstructured{Object: content}
+
+	decision := &clusterv1beta1.PlacementDecision{
+		ObjectMeta: metav1.ObjectMeta{Name: "all-decision-1", Namespace: "fleet",
+			Labels: map[string]string{clusterv1beta1.PlacementLabel: "all"}},
+		Status: clusterv1beta1.PlacementDecisionStatus{Decisions: []clusterv1beta1.ClusterDecision{
+			{ClusterName: "cluster1"}, {ClusterName: "cluster2"},
+		}},
+	}
+	installed, err := fleetSubscriptionWork(subscription, "cluster1", DefaultNamespaces(), subscriptionStatus{}, false)
+	require.NoError(t, err)
+	installed.Status.ResourceStatus.Manifests = []workapiv1.ManifestCondition{{
+		ResourceMeta: workapiv1.ManifestResourceMeta{Group: olmv1alpha1.GroupName, Resource: "subscriptions",
+			Name: "etcd", Namespace: "etcd"},
+		StatusFeedbacks: stringFeedback(map[string]string{"State": "AtLatestKnown", "InstalledCSV": "etcdoperator.v0.9.4"}),
+	}, {
+		ResourceMeta: workapiv1.ManifestResourceMeta{Group: olmv1alpha1.GroupName, Resource: "clusterserviceversions",
+			Name: "etcdoperator.v0.9.4", Namespace: "etcd"},
+		StatusFeedbacks: csvFeedback("Succeeded", "InstallSucceeded", "completed with no errors"),
+	}}
+	stale, err := fleetSubscriptionWork(subscription, "cluster3", DefaultNamespaces(), subscriptionStatus{}, false)
+	require.NoError(t, err)
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), u)
+	clusterClient := clusterfake.NewSimpleClientset(decision)
+	workClient := workfake.NewSimpleClientset(installed, stale)
+	controller := NewFleetSubscriptionController(dynamicClient, clusterClient, workClient, DefaultNamespaces())
+	subscriptionIndexer := controller.subscriptionFactory.ForResource(v1alpha1.FleetSubscriptionGVR).Informer().GetIndexer()
+	workIndexer := controller.workFactory.Work().V1().ManifestWorks().Informer().GetIndexer()
+	require.NoError(t, subscriptionIndexer.Add(u))
+	require.NoError(t, controller.clusterFactory.Cluster().V1beta1().PlacementDecisions().Informer().GetIndexer().Add(decision))
+	require.NoError(t, workIndexer.Add(installed))
+	require.NoError(t, workIndexer.Add(stale))
+
+	require.NoError(t, controller.sync(ctx, "fleet/etcd"))
+	works, err := workClient.WorkV1().ManifestWorks("").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, works.Items, 2)
+	for _, work := range works.Items {
+		switch work.Namespace {
+		case "cluster1":
+			require.Equal(t, []string{"Namespace", "OperatorGroup", "Subscription", "ClusterServiceVersion"},
+				manifestKinds(t, &work), "installed CSV added")
+		case "cluster2":
+			require.Equal(t, []string{"Namespace", "OperatorGroup", "Subscription"}, manifestKinds(t, &work))
+		default:
+			t.Errorf("unexpected ManifestWork in %s", work.Namespace)
+		}
+	}
+
+	updated, err := dynamicClient.Resource(v1alpha1.FleetSubscriptionGVR).Namespace("fleet").Get(ctx, "etcd", metav1.GetOptions{})
+	require.NoError(t, err)
+	result, err := decodeFleetSubscription(updated)
+	require.NoError(t, err)
+	require.Len(t, result.Status.Conditions, 1)
+	require.Equal(t, metav1.ConditionTrue, result.Status.Conditions[0].Status)
+	require.Equal(t, []v1alpha1.ClusterSubscriptionStatus{
+		{Cluster: "cluster1", State: "AtLatestKnown", InstalledCSV: "etcdoperator.v0.9.4", Phase: "Succeeded",
+			Reason: "InstallSucceeded", Message: "completed with no errors"},
+		{Cluster: "cluster2", Message: "ManifestWork not created yet"},
+	}, result.Status.Clusters)
+
+	// the ManifestWorks of deleted FleetSubscriptions are removed
+	require.NoError(t, subscriptionIndexer.Delete(u))
+	require.NoError(t, controller.sync(ctx, "fleet/etcd"))
+	works, err = workClient.WorkV1().ManifestWorks("").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, works.Items, 1, "only the ManifestWork of cluster1 is in the informer cache")
+}
+
+func TestFleetSubscriptionConflictingTargets(t *testing.T) {
+	ctx := context.Background()
+	newSubscription := func(name string, created int64, targets ...string) *unstructured.Unstructured {
+		subscription := newTestFleetSubscription()
+		subscription.Name = name
+		subscription.CreationTimestamp = metav1.Unix(created, 0)
+		subscription.Spec.TargetNamespaces = targets
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(subscription)
+		require.NoError(t, err)
+		return &unstructured.Unstructured{Object: content}
+	}
+	first := newSubscription("etcd", 1, "apps", "web")
+	same := newSubscription("etcd-backup", 2, "web", "apps")
+	conflicting := newSubscription("etcd-restore", 3, "apps")
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), first, same, conflicting)
+	controller := NewFleetSubscriptionController(dynamicClient, clusterfake.NewSimpleClientset(),
+		workfake.NewSimpleClientset(), DefaultNamespaces())
+	subscriptionIndexer := controller.subscriptionFactory.ForResource(v1alpha1.FleetSubscriptionGVR).Informer().GetIndexer()
+	for _, u := range []*unstructured.Unstructured{first, same, conflicting} {
+		require.NoError(t, subscriptionIndexer.Add(u))
+		require.NoError(t, controller.sync(ctx, "fleet/"+u.GetName()))
+	}
+
+	condition := func(name string) metav1.Condition {
+		updated, err := dynamicClient.Resource(v1alpha1.FleetSubscriptionGVR).Namespace("fleet").Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+		result, err := decodeFleetSubscription(updated)
+		require.NoError(t, err)
+		require.Len(t, result.Status.Conditions, 1)
+		return result.Status.Conditions[0]
+	}
+	require.Equal(t, metav1.ConditionTrue, condition("etcd").Status)
+	require.Equal(t, metav1.ConditionTrue, condition("etcd-backup").Status, "same target namespaces in another order")
+	rejected := condition("etcd-restore")
+	require.Equal(t, metav1.ConditionFalse, rejected.Status)
+	require.Equal(t, ReasonConflictingTargetNamespaces, rejected.Reason)
+	require.Equal(t, "the target namespaces [apps] of the OperatorGroup of namespace etcd differ from [apps web] of "+
+		"FleetSubscription fleet/etcd", rejected.Message)
+}
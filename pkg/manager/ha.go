@@ -0,0 +1,187 @@
+package manager
+
+import (
+	"fmt"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+)
+
+const (
+	// defaultPackageServerReplicas is the number of packageserver replicas of the upstream manifests.
+	defaultPackageServerReplicas = 2
+	// haPackageServerReplicas is the number of packageserver replicas in high-availability mode.
+	haPackageServerReplicas = 3
+	// defaultOperatorReplicas is the number of replicas of the OLM and catalog operators of the upstream manifests.
+	defaultOperatorReplicas = 1
+	// haOperatorReplicas is the number of replicas of the OLM and catalog operators in high-availability mode
+	// when their release supports leader election.
+	haOperatorReplicas = 2
+)
+
+// leaderElectedOperators are the OLM components which run a single active replica with leader election.
+var leaderElectedOperators = []string{"olm-operator", "catalog-operator"}
+
+// parseHAMode reads the HAMode customized variable, high availability is disabled when not configured.
+func parseHAMode(config addonfactory.Values) (bool, error) {
+	value, ok := config["HAMode"].(string)
+	if !ok {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q for HAMode: %w", value, err)
+	}
+	return enabled, nil
+}
+
+// haAffinity spreads the pods of a component across nodes when possible.
+func haAffinity(component string) *corev1.Affinity {
+	return &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{
+				Weight: 100,
+				PodAffinityTerm: corev1.PodAffinityTerm{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": component}},
+					TopologyKey:   corev1.LabelHostname,
+				},
+			}},
+		},
+	}
+}
+
+// packageServerPDB keeps all but one packageserver replica running during voluntary disruptions.
+func packageServerPDB(namespace string) *policyv1.PodDisruptionBudget {
+	maxUnavailable := intstr.FromInt(1)
+	return &policyv1.PodDisruptionBudget{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: policyv1.SchemeGroupVersion.String(),
+			Kind:       "PodDisruptionBudget",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "packageserver",
+			Namespace: namespace,
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MaxUnavailable: &maxUnavailable,
+			Selector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "packageserver"}},
+		},
+	}
+}
+
+// findCSVDeployment returns the deployment with the given name in the install strategy of a ClusterServiceVersion.
+func findCSVDeployment(obj runtime.Object, name string) *olmv1alpha1.StrategyDeploymentSpec {
+	csv, ok := obj.(*olmv1alpha1.ClusterServiceVersion)
+	if !ok {
+		return nil
+	}
+	for i := range csv.Spec.InstallStrategy.StrategySpec.DeploymentSpecs {
+		if csv.Spec.InstallStrategy.StrategySpec.DeploymentSpecs[i].Name == name {
+			return &csv.Spec.InstallStrategy.StrategySpec.DeploymentSpecs[i]
+		}
+	}
+	return nil
+}
+
+// setLeaderElection runs the OLM and catalog operators with additional replicas spread across nodes,
+// one of them being elected leader.
+func setLeaderElection(obj runtime.Object, namespace string) error {
+	deployment, ok := obj.(*appsv1.Deployment)
+	if !ok || deployment.Namespace != namespace {
+		return nil
+	}
+	for _, name := range leaderElectedOperators {
+		if deployment.Name != name {
+			continue
+		}
+		replicas := int32(haOperatorReplicas)
+		deployment.Spec.Replicas = &replicas
+		if deployment.Spec.Template.Spec.Affinity == nil {
+			deployment.Spec.Template.Spec.Affinity = haAffinity(name)
+		}
+		for i := range deployment.Spec.Template.Spec.Containers {
+			container := &deployment.Spec.Template.Spec.Containers[i]
+			if container.Name != name {
+				continue
+			}
+			args, err := setArg(container.Args, "--leader-elect", "true")
+			if err != nil {
+				return fmt.Errorf("%s/%s: %w", deployment.Name, container.Name, err)
+			}
+			container.Args = args
+		}
+	}
+	return nil
+}
+
+// setHAConfiguration runs the packageserver with additional replicas spread across nodes when HAMode is enabled.
+// It is protected by a disruption budget, which is added when the manifests do not provide it.
+// The OLM and catalog operators only get additional replicas when their release supports leader election,
+// as declared by the compatibility matrix, none of the releases shipped with the addon does.
+func setHAConfiguration(objects []runtime.Object, config addonfactory.Values, namespace string,
+	leaderElection bool) ([]runtime.Object, error) {
+	enabled, err := parseHAMode(config)
+	if err != nil || !enabled {
+		return objects, err
+	}
+	pdb := false
+	for _, obj := range objects {
+		if _, ok := obj.(*policyv1.PodDisruptionBudget); ok {
+			pdb = true
+		}
+		if leaderElection {
+			if err := setLeaderElection(obj, namespace); err != nil {
+				return nil, err
+			}
+		}
+		deployment := findCSVDeployment(obj, "packageserver")
+		if deployment == nil {
+			continue
+		}
+		replicas := int32(haPackageServerReplicas)
+		deployment.Spec.Replicas = &replicas
+		if deployment.Spec.Template.Spec.Affinity == nil {
+			deployment.Spec.Template.Spec.Affinity = haAffinity("packageserver")
+		}
+	}
+	if pdb {
+		return objects, nil
+	}
+	return append(objects, packageServerPDB(namespace)), nil
+}
+
+// setHAValues sets the template values related to high availability.
+// LeaderElection is only set when the release supports it and the OLM and catalog operators then get additional
+// replicas. The default anti-affinity of the components only applies when no affinity has been configured.
+func setHAValues(values, config addonfactory.Values, leaderElection bool) error {
+	enabled, err := parseHAMode(config)
+	if err != nil {
+		return err
+	}
+	values["HAMode"] = enabled
+	if !enabled {
+		return nil
+	}
+	values["PackageServerReplicas"] = haPackageServerReplicas
+	components := []string{"packageserver"}
+	if leaderElection {
+		values["LeaderElection"] = true
+		values["OperatorReplicas"] = haOperatorReplicas
+		components = append(components, leaderElectedOperators...)
+	}
+	for _, component := range components {
+		if affinity, _ := values[affinityValueName(component)].(*corev1.Affinity); affinity == nil {
+			values[affinityValueName(component)] = haAffinity(component)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package manager
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+
+	"github.com/stretchr/testify/require"
+)
+
+// findPDBs returns the PodDisruptionBudgets of a list of objects.
+func findPDBs(objects []runtime.Object) []*policyv1.PodDisruptionBudget {
+	pdbs := []*policyv1.PodDisruptionBudget{}
+	for _, obj := range objects {
+		if pdb, ok := obj.(*policyv1.PodDisruptionBudget); ok {
+			pdbs = append(pdbs, pdb)
+		}
+	}
+	return pdbs
+}
+
+func TestHAMode(t *testing.T) {
+	for _, mode := range []RenderingMode{PatchRenderingMode, TemplateRenderingMode} {
+		t.Run(string(mode), func(t *testing.T) {
+			agent, addon := newTestAgent(t, mode)
+			objects, err := agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.NoError(t, err)
+			// the disruption budget is always deployed as it reports the ready replicas of the packageserver
+			require.Len(t, findPDBs(objects), 1)
+			packageServer := componentPodSpecs(objects)["packageserver"]
+			require.Nil(t, packageServer.Affinity)
+
+			agent, addon = newTestAgent(t, mode, addonapiv1alpha1.CustomizedVariable{Name: "HAMode", Value: "true"})
+			objects, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.NoError(t, err)
+			pdbs := findPDBs(objects)
+			require.Len(t, pdbs, 1)
+			require.Equal(t, packageServerPDB(DefaultOLMNamespace).Spec, pdbs[0].Spec)
+			require.Equal(t, DefaultOLMNamespace, pdbs[0].Namespace)
+			for _, obj := range objects {
+				if deployment := findCSVDeployment(obj, "packageserver"); deployment != nil {
+					require.Equal(t, int32(haPackageServerReplicas), *deployment.Spec.Replicas)
+					require.Equal(t, haAffinity("packageserver"), deployment.Spec.Template.Spec.Affinity)
+				}
+			}
+			for _, file := range agent.catalog.get().files["v1.27"] {
+				for _, obj := range file.objects {
+					if deployment := findCSVDeployment(obj, "packageserver"); deployment != nil {
+						require.Equal(t, int32(defaultPackageServerReplicas), *deployment.Spec.Replicas, "cached objects modified")
+					}
+				}
+			}
+			// the operators of the shipped releases do not support leader election and keep a single replica
+			for _, obj := range objects {
+				if deployment, ok := obj.(*appsv1.Deployment); ok {
+					require.Equal(t, int32(defaultOperatorReplicas), *deployment.Spec.Replicas, deployment.Name)
+					require.NotContains(t, deployment.Spec.Template.Spec.Containers[0].Args, "--leader-elect=true")
+				}
+			}
+
+			agent, addon = newTestAgent(t, mode,
+				addonapiv1alpha1.CustomizedVariable{Name: "HAMode", Value: "true"},
+				addonapiv1alpha1.CustomizedVariable{Name: "Affinity", Value: testAntiAffinity})
+			objects, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.NoError(t, err)
+			placement, err := parsePodPlacement(addonfactory.Values{"Affinity": testAntiAffinity})
+			require.NoError(t, err)
+			affinity, _ := placement.forComponent("packageserver")
+			require.Equal(t, affinity, componentPodSpecs(objects)["packageserver"].Affinity)
+
+			agent, addon = newTestAgent(t, mode, addonapiv1alpha1.CustomizedVariable{Name: "HAMode", Value: "maybe"})
+			_, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.ErrorContains(t, err, "invalid value \"maybe\" for HAMode")
+		})
+	}
+}
+
+func TestHALeaderElection(t *testing.T) {
+	agent, addon := newTestAgent(t, PatchRenderingMode, addonapiv1alpha1.CustomizedVariable{Name: "HAMode", Value: "true"})
+	resolver := agent.catalog.get().resolver
+	for i := range resolver.sets {
+		resolver.sets[i].leaderElection = true
+	}
+	objects, err := agent.Manifests(newTestCluster("v1.27.3"), addon)
+	require.NoError(t, err)
+	operators := 0
+	for _, obj := range objects {
+		deployment, ok := obj.(*appsv1.Deployment)
+		if !ok {
+			continue
+		}
+		operators++
+		require.Equal(t, int32(haOperatorReplicas), *deployment.Spec.Replicas, deployment.Name)
+		require.Equal(t, haAffinity(deployment.Name), deployment.Spec.Template.Spec.Affinity)
+		require.Contains(t, deployment.Spec.Template.Spec.Containers[0].Args, "--leader-elect=true")
+	}
+	require.Equal(t, len(leaderElectedOperators), operators)
+
+	values := addonfactory.Values{}
+	require.NoError(t, setHAValues(values, addonfactory.Values{"HAMode": "true"}, true))
+	require.Equal(t, true, values["LeaderElection"])
+	require.Equal(t, haOperatorReplicas, values["OperatorReplicas"])
+	require.Equal(t, haAffinity("catalog-operator"), values[affinityValueName("catalog-operator")])
+	values = addonfactory.Values{}
+	require.NoError(t, setHAValues(values, addonfactory.Values{"HAMode": "true"}, false))
+	require.NotContains(t, values, "LeaderElection")
+}
@@ -0,0 +1,725 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"k8s.io/klog/v2"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+	agentfw "open-cluster-management.io/addon-framework/pkg/agent"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	workclientset "open-cluster-management.io/api/client/work/clientset/versioned"
+	workinformers "open-cluster-management.io/api/client/work/informers/externalversions"
+	worklisters "open-cluster-management.io/api/client/work/listers/work/v1"
+	workapiv1 "open-cluster-management.io/api/work/v1"
+)
+
+// Conditions reporting the health of the OLM components on the ManagedClusterAddOn.
+const (
+	// ConditionDegraded reports that the OLM components are available but not all their replicas are ready.
+	ConditionDegraded = "Degraded"
+	// ConditionOLMOperatorAvailable reports whether a replica of the olm-operator deployment is ready.
+	ConditionOLMOperatorAvailable = "OLMOperatorAvailable"
+	// ConditionCatalogOperatorAvailable reports whether a replica of the catalog-operator deployment is ready.
+	ConditionCatalogOperatorAvailable = "CatalogOperatorAvailable"
+	// ConditionPackageServerAvailable reports whether the packageserver ClusterServiceVersion has succeeded.
+	ConditionPackageServerAvailable = "PackageServerAvailable"
+	// ConditionPackagesAPIAvailable reports whether the packages.operators.coreos.com APIService is available.
+	ConditionPackagesAPIAvailable = "PackagesAPIAvailable"
+	// ConditionCatalogSourceReadyPrefix is followed by the name of a CatalogSource in the conditions
+	// reporting whether OLM is connected to the registry of the catalog.
+	ConditionCatalogSourceReadyPrefix = "CatalogSourceReady-"
+)
+
+// Reasons of the health conditions.
+const (
+	ReasonReplicasNotReady      = "ReplicasNotReady"
+	ReasonReplicasReady         = "ReplicasReady"
+	ReasonDeploymentReady       = "DeploymentReady"
+	ReasonDeploymentNotReady    = "DeploymentNotReady"
+	ReasonCSVSucceeded          = "ClusterServiceVersionSucceeded"
+	ReasonCSVNotSucceeded       = "ClusterServiceVersionNotSucceeded"
+	ReasonAPIServiceAvailable   = "APIServiceAvailable"
+	ReasonAPIServiceUnavailable = "APIServiceUnavailable"
+	ReasonNoProbeResult         = "NoProbeResult"
+	ReasonCatalogSourceReady    = "CatalogSourceReady"
+	ReasonConnectionFailure     = "ConnectionFailure"
+	ReasonConnecting            = "Connecting"
+	ReasonNotProbed             = "NotProbed"
+)
+
+// DefaultProbedCatalogSources are the CatalogSources of the OLM namespace whose health is reported by default.
+var DefaultProbedCatalogSources = []string{"operatorhubio-catalog"}
+
+// healthResync is the interval at which the conditions are recomputed from the ManifestWorks.
+const healthResync = 10 * time.Minute
+
+// packagesAPIService is the APIService served by the packageserver. It is created by OLM
+// for the packageserver ClusterServiceVersion and its availability is reported in the status of the latter.
+const packagesAPIService = "v1.packages.operators.coreos.com"
+
+// csvAPIServiceReasons are the reasons set by OLM on ClusterServiceVersions whose APIServices are not available.
+var csvAPIServiceReasons = map[string]bool{
+	string(olmv1alpha1.CSVReasonAPIServiceResourceIssue):          true,
+	string(olmv1alpha1.CSVReasonAPIServiceResourcesNeedReinstall): true,
+	string(olmv1alpha1.CSVReasonAPIServiceInstallFailed):          true,
+}
+
+// probedComponent is an OLM component whose health is probed through the status feedback of the ManifestWork.
+type probedComponent struct {
+	field agentfw.ProbeField
+	// condition reports the health of the component on the ManagedClusterAddOn,
+	// components without condition are only reported by the Degraded condition
+	condition string
+}
+
+// probedComponents returns the OLM components whose health is reported to the hub.
+func (o *olmAgent) probedComponents() []probedComponent {
+	deployment := func(name, condition string) probedComponent {
+		return probedComponent{
+			field: agentfw.ProbeField{
+				ResourceIdentifier: workapiv1.ResourceIdentifier{
+					Group:     "apps",
+					Resource:  "deployments",
+					Name:      name,
+					Namespace: o.namespaces.OLM,
+				},
+				// the updated replicas tell OLM rollouts whether the pods run the current template,
+				// the drift rules report the fields compared with the manifests
+				ProbeRules: []workapiv1.FeedbackRule{
+					{Type: workapiv1.WellKnownStatusType},
+					{
+						Type:      workapiv1.JSONPathsType,
+						JsonPaths: []workapiv1.JsonPath{{Name: "UpdatedReplicas", Path: ".status.updatedReplicas"}},
+					},
+					driftProbeRules(),
+				},
+			},
+			condition: condition,
+		}
+	}
+	components := []probedComponent{
+		deployment("olm-operator", ConditionOLMOperatorAvailable),
+		deployment("catalog-operator", ConditionCatalogOperatorAvailable),
+		{
+			field: agentfw.ProbeField{
+				ResourceIdentifier: workapiv1.ResourceIdentifier{
+					Group:     olmv1alpha1.GroupName,
+					Resource:  "clusterserviceversions",
+					Name:      "packageserver",
+					Namespace: o.namespaces.OLM,
+				},
+				ProbeRules: []workapiv1.FeedbackRule{csvFeedbackRule()},
+			},
+			condition: ConditionPackageServerAvailable,
+		},
+		{
+			// the packageserver deployment is created by OLM from the ClusterServiceVersion,
+			// its ready replicas are counted by its disruption budget
+			field: agentfw.ProbeField{
+				ResourceIdentifier: workapiv1.ResourceIdentifier{
+					Group:     "policy",
+					Resource:  "poddisruptionbudgets",
+					Name:      "packageserver",
+					Namespace: o.namespaces.OLM,
+				},
+				ProbeRules: []workapiv1.FeedbackRule{{
+					Type: workapiv1.JSONPathsType,
+					JsonPaths: []workapiv1.JsonPath{
+						{Name: "CurrentHealthy", Path: ".status.currentHealthy"},
+						{Name: "ExpectedPods", Path: ".status.expectedPods"},
+					},
+				}},
+			},
+		},
+	}
+	for _, catalog := range o.probedCatalogs {
+		components = append(components, probedComponent{
+			field: agentfw.ProbeField{
+				ResourceIdentifier: workapiv1.ResourceIdentifier{
+					Group:     olmv1alpha1.GroupName,
+					Resource:  "catalogsources",
+					Name:      catalog,
+					Namespace: o.namespaces.OLM,
+				},
+				ProbeRules: []workapiv1.FeedbackRule{{
+					Type: workapiv1.JSONPathsType,
+					JsonPaths: []workapiv1.JsonPath{
+						{Name: "ConnectionState", Path: ".status.connectionState.lastObservedState"},
+						{Name: "LastConnect", Path: ".status.connectionState.lastConnect"},
+						{Name: "LatestImageRegistryPoll", Path: ".status.latestImageRegistryPoll"},
+						{Name: "Message", Path: ".status.message"},
+					},
+				}},
+			},
+			condition: ConditionCatalogSourceReadyPrefix + catalog,
+		})
+	}
+	return components
+}
+
+// probeTracker records per cluster the optional probed resources which are not deployed, e.g. the default
+// catalog when disabled or the packageserver PodDisruptionBudget of manifest sets without it.
+// The work agent does not return any feedback for them.
+type probeTracker struct {
+	mu sync.RWMutex
+	// missing are the keys of the probed resources not deployed per cluster
+	missing map[string]map[string]bool
+}
+
+func newProbeTracker() *probeTracker {
+	return &probeTracker{missing: map[string]map[string]bool{}}
+}
+
+// probeKey identifies a probed resource of the OLM namespace in a probeTracker.
+func probeKey(identifier workapiv1.ResourceIdentifier) string {
+	return identifier.Resource + "/" + identifier.Name
+}
+
+// record sets the probed resources not deployed on a cluster, the cluster is forgotten when there are none.
+func (t *probeTracker) record(cluster string, missing map[string]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(missing) == 0 {
+		delete(t.missing, cluster)
+		return
+	}
+	t.missing[cluster] = missing
+}
+
+// forget removes the resources recorded for a cluster, e.g. when nothing is deployed on it.
+func (t *probeTracker) forget(cluster string) {
+	t.record(cluster, nil)
+}
+
+// missingOn returns whether a probed resource is not deployed on the cluster.
+func (t *probeTracker) missingOn(cluster string, identifier workapiv1.ResourceIdentifier) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.missing[cluster][probeKey(identifier)]
+}
+
+// missingAnywhere returns whether a probed resource is not deployed on at least one cluster.
+func (t *probeTracker) missingAnywhere(identifier workapiv1.ResourceIdentifier) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, missing := range t.missing {
+		if missing[probeKey(identifier)] {
+			return true
+		}
+	}
+	return false
+}
+
+// optionalProbe tells whether a probed resource may not be part of the rendered manifests.
+func optionalProbe(identifier workapiv1.ResourceIdentifier) bool {
+	return identifier.Resource == "catalogsources" || identifier.Resource == "poddisruptionbudgets"
+}
+
+// recordProbes records the optional probed resources of the OLM namespace which are not part of the rendered objects.
+func (o *olmAgent) recordProbes(cluster string, objects []runtime.Object) {
+	rendered := map[string]bool{}
+	for _, obj := range objects {
+		accessor, err := meta.Accessor(obj)
+		if err != nil || accessor.GetNamespace() != o.namespaces.OLM {
+			continue
+		}
+		switch obj.GetObjectKind().GroupVersionKind().Kind {
+		case olmv1alpha1.CatalogSourceKind:
+			rendered["catalogsources/"+accessor.GetName()] = true
+		case "PodDisruptionBudget":
+			rendered["poddisruptionbudgets/"+accessor.GetName()] = true
+		}
+	}
+	missing := map[string]bool{}
+	for _, component := range o.probedComponents() {
+		key := probeKey(component.field.ResourceIdentifier)
+		if optionalProbe(component.field.ResourceIdentifier) && !rendered[key] {
+			missing[key] = true
+		}
+	}
+	o.undeployedProbes.record(cluster, missing)
+}
+
+// catalogConditions returns the conditions of the probed CatalogSources whose status is not fed back for a cluster
+// and the types of the conditions of the ones which are not deployed on it.
+func (o *olmAgent) catalogConditions(cluster string) ([]metav1.Condition, []string) {
+	conditions := []metav1.Condition{}
+	removed := []string{}
+	for _, catalog := range o.probedCatalogs {
+		identifier := workapiv1.ResourceIdentifier{Group: olmv1alpha1.GroupName, Resource: "catalogsources",
+			Name: catalog, Namespace: o.namespaces.OLM}
+		switch {
+		case o.undeployedProbes.missingOn(cluster, identifier):
+			removed = append(removed, ConditionCatalogSourceReadyPrefix+catalog)
+		case o.undeployedProbes.missingAnywhere(identifier):
+			conditions = append(conditions, metav1.Condition{
+				Type:   ConditionCatalogSourceReadyPrefix + catalog,
+				Status: metav1.ConditionUnknown,
+				Reason: ReasonNotProbed,
+				Message: fmt.Sprintf("the status of CatalogSource %s/%s is not probed as it is not deployed on all the clusters",
+					o.namespaces.OLM, catalog),
+			})
+		}
+	}
+	return conditions, removed
+}
+
+// healthProber probes the OLM components through the status feedback of the ManifestWork.
+// The addon is available when one replica of each operator is ready and the packageserver ClusterServiceVersion,
+// which requires the packages APIService to be available, has succeeded.
+// The health of the individual components is reported in distinct conditions by the HealthController.
+func (o *olmAgent) healthProber() *agentfw.HealthProber {
+	return &agentfw.HealthProber{
+		Type: agentfw.HealthProberTypeWork,
+		WorkProber: &agentfw.WorkHealthProber{
+			ProbeFields: o.probeFields(),
+			HealthCheck: healthCheck,
+		},
+	}
+}
+
+// probeFields returns the resources of the ManifestWork whose status is fed back to the hub.
+func (o *olmAgent) probeFields() []agentfw.ProbeField {
+	fields := []agentfw.ProbeField{}
+	for _, component := range o.fedBackComponents() {
+		fields = append(fields, component.field)
+	}
+	return fields
+}
+
+// fedBackComponents returns the OLM components whose status is fed back to the hub.
+// The probe fields are the same for all the clusters and the addon framework reports the addon as unavailable
+// when a probed resource has no feedback. The optional probed resources which are not deployed on all the clusters
+// are therefore left out, which stops the feedback of their status on the other clusters.
+func (o *olmAgent) fedBackComponents() []probedComponent {
+	components := []probedComponent{}
+	for _, component := range o.probedComponents() {
+		identifier := component.field.ResourceIdentifier
+		if optionalProbe(identifier) && o.undeployedProbes.missingAnywhere(identifier) {
+			continue
+		}
+		components = append(components, component)
+	}
+	return components
+}
+
+// healthCheck returns an error when the probed resource is not healthy.
+// CatalogSources do not affect the availability of the addon, their health is only reported in their conditions.
+// Neither do the replicas of the packageserver, whose availability is the one of its ClusterServiceVersion.
+func healthCheck(identifier workapiv1.ResourceIdentifier, result workapiv1.StatusFeedbackResult) error {
+	switch identifier.Resource {
+	case "clusterserviceversions":
+		return csvHealthCheck(identifier, result)
+	case "catalogsources", "poddisruptionbudgets":
+		return nil
+	default:
+		return deploymentHealthCheck(identifier, result)
+	}
+}
+
+// deploymentStatus contains the replica counts of a deployment found in the status feedback.
+type deploymentStatus struct {
+	replicas        int64
+	readyReplicas   int64
+	updatedReplicas int64
+}
+
+// parseDeploymentFeedback extracts the replica counts of the well known status of a deployment.
+// Counts missing from the feedback are zero as they are omitted from the deployment status in that case.
+func parseDeploymentFeedback(result workapiv1.StatusFeedbackResult) deploymentStatus {
+	status := deploymentStatus{}
+	for _, value := range result.Values {
+		if value.Value.Integer == nil {
+			continue
+		}
+		switch value.Name {
+		case "Replicas":
+			status.replicas = *value.Value.Integer
+		case "ReadyReplicas":
+			status.readyReplicas = *value.Value.Integer
+		case "UpdatedReplicas":
+			status.updatedReplicas = *value.Value.Integer
+		}
+	}
+	return status
+}
+
+// parsePDBFeedback returns the ready and expected pods of a PodDisruptionBudget found in the status feedback.
+func parsePDBFeedback(result workapiv1.StatusFeedbackResult) (int64, int64) {
+	var healthy, expected int64
+	for _, value := range result.Values {
+		if value.Value.Integer == nil {
+			continue
+		}
+		switch value.Name {
+		case "CurrentHealthy":
+			healthy = *value.Value.Integer
+		case "ExpectedPods":
+			expected = *value.Value.Integer
+		}
+	}
+	return healthy, expected
+}
+
+// deploymentHealthCheck reports a deployment as unavailable when none of its replicas is ready.
+func deploymentHealthCheck(identifier workapiv1.ResourceIdentifier, result workapiv1.StatusFeedbackResult) error {
+	if parseDeploymentFeedback(result).readyReplicas < 1 {
+		return fmt.Errorf("no replica of deployment %s/%s is ready", identifier.Namespace, identifier.Name)
+	}
+	return nil
+}
+
+// csvFeedbackRule reports the phase and the version of ClusterServiceVersions.
+func csvFeedbackRule() workapiv1.FeedbackRule {
+	return workapiv1.FeedbackRule{
+		Type: workapiv1.JSONPathsType,
+		JsonPaths: []workapiv1.JsonPath{
+			{Name: "Phase", Path: ".status.phase"},
+			{Name: "Reason", Path: ".status.reason"},
+			{Name: "Message", Path: ".status.message"},
+			{Name: "Version", Path: ".spec.version"},
+		},
+	}
+}
+
+// csvStatus contains the phase of a ClusterServiceVersion and the reason for it found in the status feedback.
+type csvStatus struct {
+	phase   string
+	reason  string
+	message string
+	version string
+}
+
+func parseCSVFeedback(result workapiv1.StatusFeedbackResult) csvStatus {
+	status := csvStatus{}
+	for _, value := range result.Values {
+		if value.Value.String == nil {
+			continue
+		}
+		switch value.Name {
+		case "Phase":
+			status.phase = *value.Value.String
+		case "Reason":
+			status.reason = *value.Value.String
+		case "Message":
+			status.message = *value.Value.String
+		case "Version":
+			status.version = *value.Value.String
+		}
+	}
+	return status
+}
+
+// csvHealthCheck reports a ClusterServiceVersion as unavailable when it has not succeeded.
+func csvHealthCheck(identifier workapiv1.ResourceIdentifier, result workapiv1.StatusFeedbackResult) error {
+	status := parseCSVFeedback(result)
+	if status.phase != string(olmv1alpha1.CSVPhaseSucceeded) {
+		return fmt.Errorf("ClusterServiceVersion %s/%s is in phase %q: %s: %s", identifier.Namespace, identifier.Name,
+			status.phase, status.reason, status.message)
+	}
+	return nil
+}
+
+// healthConditions computes the conditions of the probed components from the status feedback of a ManifestWork.
+// The availability of the packages APIService is derived from the status of the packageserver ClusterServiceVersion
+// and the ready replicas of the packageserver, counted by its PodDisruptionBudget, are part of the Degraded condition.
+func healthConditions(components []probedComponent, work *workapiv1.ManifestWork) []metav1.Condition {
+	conditions := []metav1.Condition{}
+	notReady := []string{}
+	complete := true
+	for _, component := range components {
+		identifier := component.field.ResourceIdentifier
+		result := findFeedback(identifier, work)
+		if result == nil {
+			complete = complete && identifier.Resource != "deployments" && identifier.Resource != "poddisruptionbudgets"
+			if component.condition == "" {
+				continue
+			}
+			conditions = append(conditions, metav1.Condition{
+				Type:    component.condition,
+				Status:  metav1.ConditionUnknown,
+				Reason:  ReasonNoProbeResult,
+				Message: fmt.Sprintf("the status of %s %s/%s has not been reported", identifier.Resource, identifier.Namespace, identifier.Name),
+			})
+			if identifier.Resource == "clusterserviceversions" {
+				conditions = append(conditions, metav1.Condition{
+					Type:    ConditionPackagesAPIAvailable,
+					Status:  metav1.ConditionUnknown,
+					Reason:  ReasonNoProbeResult,
+					Message: fmt.Sprintf("the status of clusterserviceversions %s/%s has not been reported", identifier.Namespace, identifier.Name),
+				})
+			}
+			continue
+		}
+		switch identifier.Resource {
+		case "clusterserviceversions":
+			conditions = append(conditions, csvConditions(component.condition, identifier, *result)...)
+			continue
+		case "catalogsources":
+			conditions = append(conditions, catalogSourceCondition(component.condition, identifier, *result))
+			continue
+		case "poddisruptionbudgets":
+			if healthy, expected := parsePDBFeedback(*result); healthy < expected {
+				notReady = append(notReady, fmt.Sprintf("%s/%s has %d of %d replicas ready", identifier.Namespace,
+					identifier.Name, healthy, expected))
+			}
+			continue
+		}
+		status := parseDeploymentFeedback(*result)
+		if status.readyReplicas < status.replicas {
+			notReady = append(notReady, fmt.Sprintf("%s/%s has %d of %d replicas ready", identifier.Namespace,
+				identifier.Name, status.readyReplicas, status.replicas))
+		}
+		if err := deploymentHealthCheck(identifier, *result); err != nil {
+			conditions = append(conditions, metav1.Condition{
+				Type:    component.condition,
+				Status:  metav1.ConditionFalse,
+				Reason:  ReasonDeploymentNotReady,
+				Message: err.Error(),
+			})
+			continue
+		}
+		conditions = append(conditions, metav1.Condition{
+			Type:   component.condition,
+			Status: metav1.ConditionTrue,
+			Reason: ReasonDeploymentReady,
+			Message: fmt.Sprintf("%d of %d replicas of deployment %s/%s are ready", status.readyReplicas, status.replicas,
+				identifier.Namespace, identifier.Name),
+		})
+	}
+	switch {
+	case len(notReady) > 0:
+		conditions = append(conditions, metav1.Condition{
+			Type:    ConditionDegraded,
+			Status:  metav1.ConditionTrue,
+			Reason:  ReasonReplicasNotReady,
+			Message: strings.Join(notReady, ", "),
+		})
+	case complete:
+		conditions = append(conditions, metav1.Condition{
+			Type:    ConditionDegraded,
+			Status:  metav1.ConditionFalse,
+			Reason:  ReasonReplicasReady,
+			Message: "all the replicas of the OLM components are ready",
+		})
+	}
+	return conditions
+}
+
+// csvConditions returns the condition of a ClusterServiceVersion and the one of the packages APIService it owns.
+func csvConditions(conditionType string, identifier workapiv1.ResourceIdentifier,
+	result workapiv1.StatusFeedbackResult) []metav1.Condition {
+	if err := csvHealthCheck(identifier, result); err != nil {
+		status := parseCSVFeedback(result)
+		apiCondition := metav1.Condition{
+			Type:    ConditionPackagesAPIAvailable,
+			Status:  metav1.ConditionUnknown,
+			Reason:  ReasonCSVNotSucceeded,
+			Message: fmt.Sprintf("the availability of APIService %s is not known: %s", packagesAPIService, err.Error()),
+		}
+		// OLM does not set a dedicated reason when the APIService becomes unavailable after the installation
+		if csvAPIServiceReasons[status.reason] || strings.Contains(status.message, "apiServices not installed") {
+			apiCondition.Status = metav1.ConditionFalse
+			apiCondition.Reason = ReasonAPIServiceUnavailable
+			apiCondition.Message = fmt.Sprintf("APIService %s is not available: %s", packagesAPIService, status.message)
+		}
+		return []metav1.Condition{
+			{
+				Type:    conditionType,
+				Status:  metav1.ConditionFalse,
+				Reason:  ReasonCSVNotSucceeded,
+				Message: err.Error(),
+			},
+			apiCondition,
+		}
+	}
+	return []metav1.Condition{
+		{
+			Type:    conditionType,
+			Status:  metav1.ConditionTrue,
+			Reason:  ReasonCSVSucceeded,
+			Message: fmt.Sprintf("ClusterServiceVersion %s/%s has succeeded", identifier.Namespace, identifier.Name),
+		},
+		{
+			Type:    ConditionPackagesAPIAvailable,
+			Status:  metav1.ConditionTrue,
+			Reason:  ReasonAPIServiceAvailable,
+			Message: fmt.Sprintf("APIService %s is available", packagesAPIService),
+		},
+	}
+}
+
+// catalogSourceCondition reports the state of the connection of OLM to the registry of a CatalogSource,
+// e.g. TRANSIENT_FAILURE when the registry pod cannot pull its image, and when it last changed and got polled.
+func catalogSourceCondition(conditionType string, identifier workapiv1.ResourceIdentifier,
+	result workapiv1.StatusFeedbackResult) metav1.Condition {
+	values := map[string]string{}
+	for _, value := range result.Values {
+		if value.Value.String != nil {
+			values[value.Name] = *value.Value.String
+		}
+	}
+	state := values["ConnectionState"]
+	details := []string{}
+	if lastConnect := values["LastConnect"]; lastConnect != "" {
+		details = append(details, "last connection state change at "+lastConnect)
+	}
+	if poll := values["LatestImageRegistryPoll"]; poll != "" {
+		details = append(details, "last registry poll at "+poll)
+	}
+	if message := values["Message"]; message != "" {
+		details = append(details, message)
+	}
+	condition := metav1.Condition{
+		Type:    conditionType,
+		Status:  metav1.ConditionUnknown,
+		Reason:  ReasonConnecting,
+		Message: fmt.Sprintf("CatalogSource %s/%s connection state is %q", identifier.Namespace, identifier.Name, state),
+	}
+	if len(details) > 0 {
+		condition.Message += ", " + strings.Join(details, ", ")
+	}
+	switch state {
+	case "READY":
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonCatalogSourceReady
+	case "TRANSIENT_FAILURE", "SHUTDOWN":
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonConnectionFailure
+	}
+	return condition
+}
+
+// findFeedback returns the status feedback of a resource of the ManifestWork, nil when none has been reported.
+func findFeedback(identifier workapiv1.ResourceIdentifier, work *workapiv1.ManifestWork) *workapiv1.StatusFeedbackResult {
+	for _, manifest := range work.Status.ResourceStatus.Manifests {
+		if manifest.ResourceMeta.Group == identifier.Group && manifest.ResourceMeta.Resource == identifier.Resource &&
+			manifest.ResourceMeta.Name == identifier.Name && manifest.ResourceMeta.Namespace == identifier.Namespace {
+			if len(manifest.StatusFeedbacks.Values) == 0 {
+				return nil
+			}
+			return &manifest.StatusFeedbacks
+		}
+	}
+	return nil
+}
+
+// HealthController sets the conditions of the ManagedClusterAddOns that the addon framework does not manage
+// from the status feedback of the ManifestWorks deploying OLM.
+type HealthController struct {
+	agent   *olmAgent
+	factory workinformers.SharedInformerFactory
+	lister  worklisters.ManifestWorkLister
+	synced  cache.InformerSynced
+	queue   workqueue.RateLimitingInterface
+}
+
+// NewHealthController watches the ManifestWorks of the addon.
+func NewHealthController(agent *olmAgent, workClient workclientset.Interface) *HealthController {
+	factory := workinformers.NewSharedInformerFactoryWithOptions(workClient, healthResync,
+		workinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = fmt.Sprintf("%s=%s", addonapiv1alpha1.AddonLabelKey, agent.addonName)
+		}))
+	informer := factory.Work().V1().ManifestWorks()
+	c := &HealthController{
+		agent:   agent,
+		factory: factory,
+		lister:  informer.Lister(),
+		synced:  informer.Informer().HasSynced,
+		queue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "olm-addon-health"),
+	}
+	enqueue := func(obj interface{}) {
+		key, err := cache.MetaNamespaceKeyFunc(obj)
+		if err != nil {
+			utilruntime.HandleError(err)
+			return
+		}
+		c.queue.Add(key)
+	}
+	_, _ = informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+	})
+	return c
+}
+
+// Run processes the ManifestWorks until the context is cancelled.
+func (c *HealthController) Run(ctx context.Context) {
+	defer c.queue.ShutDown()
+	c.factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced) {
+		return
+	}
+	go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	<-ctx.Done()
+}
+
+func (c *HealthController) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *HealthController) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+	if err := c.sync(ctx, key.(string)); err != nil {
+		klog.ErrorS(err, "Not able to update the health of the addon", "work", key)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// sync updates the conditions of the ManagedClusterAddOn deployed by a ManifestWork.
+func (c *HealthController) sync(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(name, constants.DeployWorkNamePrefix(c.agent.addonName)) {
+		return nil
+	}
+	work, err := c.lister.ManifestWorks(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		// nothing is probed on clusters without OLM
+		c.agent.undeployedProbes.forget(namespace)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	conditions := healthConditions(c.agent.fedBackComponents(), work)
+	catalogConditions, removed := c.agent.catalogConditions(namespace)
+	conditions = append(conditions, catalogConditions...)
+	addon, err := c.agent.addonClient.AddonV1alpha1().ManagedClusterAddOns(namespace).Get(ctx, c.agent.addonName,
+		metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.agent.updateConditions(addon, conditions...)
+	c.agent.removeConditions(addon, removed...)
+	return nil
+}
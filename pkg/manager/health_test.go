@@ -0,0 +1,354 @@
+package manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	workfake "open-cluster-management.io/api/client/work/clientset/versioned/fake"
+	workapiv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stretchr/testify/require"
+)
+
+// deploymentFeedback returns the status feedback of a deployment, negative counts are omitted
+// as they are in the status of deployments.
+func deploymentFeedback(replicas, readyReplicas int64) workapiv1.StatusFeedbackResult {
+	result := workapiv1.StatusFeedbackResult{}
+	for name, value := range map[string]int64{"Replicas": replicas, "ReadyReplicas": readyReplicas} {
+		if value < 0 {
+			continue
+		}
+		value := value
+		result.Values = append(result.Values, workapiv1.FeedbackValue{
+			Name:  name,
+			Value: workapiv1.FieldValue{Type: workapiv1.Integer, Integer: &value},
+		})
+	}
+	return result
+}
+
+// stringFeedback returns status feedback with string values, empty values are omitted.
+func stringFeedback(values map[string]string) workapiv1.StatusFeedbackResult {
+	result := workapiv1.StatusFeedbackResult{}
+	for name, value := range values {
+		if value == "" {
+			continue
+		}
+		value := value
+		result.Values = append(result.Values, workapiv1.FeedbackValue{
+			Name:  name,
+			Value: workapiv1.FieldValue{Type: workapiv1.String, String: &value},
+		})
+	}
+	return result
+}
+
+// csvFeedback returns the status feedback of a ClusterServiceVersion.
+func csvFeedback(phase, reason, message string) workapiv1.StatusFeedbackResult {
+	return stringFeedback(map[string]string{"Phase": phase, "Reason": reason, "Message": message})
+}
+
+// catalogFeedback returns the status feedback of a CatalogSource.
+func catalogFeedback(state string) workapiv1.StatusFeedbackResult {
+	return stringFeedback(map[string]string{
+		"ConnectionState":         state,
+		"LastConnect":             "2023-06-01T10:00:00Z",
+		"LatestImageRegistryPoll": "2023-06-01T11:00:00Z",
+	})
+}
+
+// testWork returns the ManifestWork of the addon with status feedback for the resources
+// of the OLM namespace identified by resource and name, e.g. deployments/olm-operator.
+func testWork(feedbacks map[string]workapiv1.StatusFeedbackResult) *workapiv1.ManifestWork {
+	work := &workapiv1.ManifestWork{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "addon-olm-addon-deploy-0",
+			Namespace: "cluster1",
+			Labels:    map[string]string{addonapiv1alpha1.AddonLabelKey: "olm-addon"},
+		},
+	}
+	for key, feedback := range feedbacks {
+		resource, name, _ := strings.Cut(key, "/")
+		group := "operators.coreos.com"
+		switch resource {
+		case "deployments":
+			group = "apps"
+		case "poddisruptionbudgets":
+			group = "policy"
+		}
+		work.Status.ResourceStatus.Manifests = append(work.Status.ResourceStatus.Manifests, workapiv1.ManifestCondition{
+			ResourceMeta: workapiv1.ManifestResourceMeta{
+				Group:     group,
+				Resource:  resource,
+				Name:      name,
+				Namespace: DefaultOLMNamespace,
+			},
+			StatusFeedbacks: feedback,
+		})
+	}
+	return work
+}
+
+// healthyFeedbacks returns the status feedback of healthy OLM components.
+func healthyFeedbacks() map[string]workapiv1.StatusFeedbackResult {
+	return map[string]workapiv1.StatusFeedbackResult{
+		"deployments/olm-operator":             deploymentFeedback(1, 1),
+		"deployments/catalog-operator":         deploymentFeedback(1, 1),
+		"clusterserviceversions/packageserver": csvFeedback("Succeeded", "InstallSucceeded", "install strategy completed with no errors"),
+		"catalogsources/operatorhubio-catalog": catalogFeedback("READY"),
+		"poddisruptionbudgets/packageserver":   pdbFeedback(2, 2),
+	}
+}
+
+// pdbFeedback returns the status feedback of a PodDisruptionBudget.
+func pdbFeedback(expected, healthy int64) workapiv1.StatusFeedbackResult {
+	return workapiv1.StatusFeedbackResult{Values: []workapiv1.FeedbackValue{
+		{Name: "ExpectedPods", Value: workapiv1.FieldValue{Type: workapiv1.Integer, Integer: &expected}},
+		{Name: "CurrentHealthy", Value: workapiv1.FieldValue{Type: workapiv1.Integer, Integer: &healthy}},
+	}}
+}
+
+func TestHealthCheck(t *testing.T) {
+	deployment := workapiv1.ResourceIdentifier{Resource: "deployments", Name: "olm-operator", Namespace: DefaultOLMNamespace}
+	require.NoError(t, healthCheck(deployment, deploymentFeedback(2, 1)))
+	require.NoError(t, healthCheck(deployment, deploymentFeedback(1, 1)))
+	require.ErrorContains(t, healthCheck(deployment, deploymentFeedback(1, -1)),
+		"no replica of deployment olm/olm-operator is ready")
+
+	csv := workapiv1.ResourceIdentifier{Resource: "clusterserviceversions", Name: "packageserver", Namespace: DefaultOLMNamespace}
+	require.NoError(t, healthCheck(csv, csvFeedback("Succeeded", "InstallSucceeded", "")))
+	require.ErrorContains(t, healthCheck(csv, csvFeedback("Failed", "ComponentUnhealthy", "apiServices not installed")),
+		"ClusterServiceVersion olm/packageserver is in phase \"Failed\": ComponentUnhealthy: apiServices not installed")
+}
+
+// conditionStatuses returns the status of the conditions per type.
+func conditionStatuses(conditions []metav1.Condition) map[string]metav1.ConditionStatus {
+	statuses := map[string]metav1.ConditionStatus{}
+	for _, condition := range conditions {
+		statuses[condition.Type] = condition.Status
+	}
+	return statuses
+}
+
+func TestHealthConditions(t *testing.T) {
+	agent, _ := newTestAgent(t, PatchRenderingMode)
+	catalogCondition := ConditionCatalogSourceReadyPrefix + "operatorhubio-catalog"
+	tests := []struct {
+		name     string
+		update   func(map[string]workapiv1.StatusFeedbackResult)
+		expected map[string]metav1.ConditionStatus
+	}{
+		{
+			name:   "healthy",
+			update: func(map[string]workapiv1.StatusFeedbackResult) {},
+			expected: map[string]metav1.ConditionStatus{
+				ConditionOLMOperatorAvailable:     metav1.ConditionTrue,
+				ConditionCatalogOperatorAvailable: metav1.ConditionTrue,
+				ConditionPackageServerAvailable:   metav1.ConditionTrue,
+				ConditionPackagesAPIAvailable:     metav1.ConditionTrue,
+				catalogCondition:                  metav1.ConditionTrue,
+				ConditionDegraded:                 metav1.ConditionFalse,
+			},
+		},
+		{
+			name: "crashlooping catalog operator",
+			update: func(feedbacks map[string]workapiv1.StatusFeedbackResult) {
+				feedbacks["deployments/catalog-operator"] = deploymentFeedback(1, -1)
+			},
+			expected: map[string]metav1.ConditionStatus{
+				ConditionOLMOperatorAvailable:     metav1.ConditionTrue,
+				ConditionCatalogOperatorAvailable: metav1.ConditionFalse,
+				ConditionPackageServerAvailable:   metav1.ConditionTrue,
+				ConditionPackagesAPIAvailable:     metav1.ConditionTrue,
+				catalogCondition:                  metav1.ConditionTrue,
+				ConditionDegraded:                 metav1.ConditionTrue,
+			},
+		},
+		{
+			name: "partially ready olm operator",
+			update: func(feedbacks map[string]workapiv1.StatusFeedbackResult) {
+				feedbacks["deployments/olm-operator"] = deploymentFeedback(2, 1)
+			},
+			expected: map[string]metav1.ConditionStatus{
+				ConditionOLMOperatorAvailable:     metav1.ConditionTrue,
+				ConditionCatalogOperatorAvailable: metav1.ConditionTrue,
+				ConditionPackageServerAvailable:   metav1.ConditionTrue,
+				ConditionPackagesAPIAvailable:     metav1.ConditionTrue,
+				catalogCondition:                  metav1.ConditionTrue,
+				ConditionDegraded:                 metav1.ConditionTrue,
+			},
+		},
+		{
+			name: "partially ready packageserver",
+			update: func(feedbacks map[string]workapiv1.StatusFeedbackResult) {
+				feedbacks["poddisruptionbudgets/packageserver"] = pdbFeedback(3, 1)
+			},
+			expected: map[string]metav1.ConditionStatus{
+				ConditionOLMOperatorAvailable:     metav1.ConditionTrue,
+				ConditionCatalogOperatorAvailable: metav1.ConditionTrue,
+				ConditionPackageServerAvailable:   metav1.ConditionTrue,
+				ConditionPackagesAPIAvailable:     metav1.ConditionTrue,
+				catalogCondition:                  metav1.ConditionTrue,
+				ConditionDegraded:                 metav1.ConditionTrue,
+			},
+		},
+		{
+			name: "missing packageserver replicas feedback",
+			update: func(feedbacks map[string]workapiv1.StatusFeedbackResult) {
+				delete(feedbacks, "poddisruptionbudgets/packageserver")
+			},
+			expected: map[string]metav1.ConditionStatus{
+				ConditionOLMOperatorAvailable:     metav1.ConditionTrue,
+				ConditionCatalogOperatorAvailable: metav1.ConditionTrue,
+				ConditionPackageServerAvailable:   metav1.ConditionTrue,
+				ConditionPackagesAPIAvailable:     metav1.ConditionTrue,
+				catalogCondition:                  metav1.ConditionTrue,
+			},
+		},
+		{
+			name: "unavailable APIService",
+			update: func(feedbacks map[string]workapiv1.StatusFeedbackResult) {
+				feedbacks["clusterserviceversions/packageserver"] = csvFeedback("Failed", "ComponentUnhealthy",
+					"apiServices not installed")
+			},
+			expected: map[string]metav1.ConditionStatus{
+				ConditionOLMOperatorAvailable:     metav1.ConditionTrue,
+				ConditionCatalogOperatorAvailable: metav1.ConditionTrue,
+				ConditionPackageServerAvailable:   metav1.ConditionFalse,
+				ConditionPackagesAPIAvailable:     metav1.ConditionFalse,
+				catalogCondition:                  metav1.ConditionTrue,
+				ConditionDegraded:                 metav1.ConditionFalse,
+			},
+		},
+		{
+			name: "installing packageserver",
+			update: func(feedbacks map[string]workapiv1.StatusFeedbackResult) {
+				feedbacks["clusterserviceversions/packageserver"] = csvFeedback("InstallReady", "AllRequirementsMet", "")
+			},
+			expected: map[string]metav1.ConditionStatus{
+				ConditionOLMOperatorAvailable:     metav1.ConditionTrue,
+				ConditionCatalogOperatorAvailable: metav1.ConditionTrue,
+				ConditionPackageServerAvailable:   metav1.ConditionFalse,
+				ConditionPackagesAPIAvailable:     metav1.ConditionUnknown,
+				catalogCondition:                  metav1.ConditionTrue,
+				ConditionDegraded:                 metav1.ConditionFalse,
+			},
+		},
+		{
+			name: "missing catalog feedback",
+			update: func(feedbacks map[string]workapiv1.StatusFeedbackResult) {
+				delete(feedbacks, "catalogsources/operatorhubio-catalog")
+			},
+			expected: map[string]metav1.ConditionStatus{
+				ConditionOLMOperatorAvailable:     metav1.ConditionTrue,
+				ConditionCatalogOperatorAvailable: metav1.ConditionTrue,
+				ConditionPackageServerAvailable:   metav1.ConditionTrue,
+				ConditionPackagesAPIAvailable:     metav1.ConditionTrue,
+				catalogCondition:                  metav1.ConditionUnknown,
+				ConditionDegraded:                 metav1.ConditionFalse,
+			},
+		},
+		{
+			name: "missing feedback",
+			update: func(feedbacks map[string]workapiv1.StatusFeedbackResult) {
+				delete(feedbacks, "deployments/catalog-operator")
+				delete(feedbacks, "clusterserviceversions/packageserver")
+				feedbacks["catalogsources/operatorhubio-catalog"] = catalogFeedback("TRANSIENT_FAILURE")
+			},
+			expected: map[string]metav1.ConditionStatus{
+				ConditionOLMOperatorAvailable:     metav1.ConditionTrue,
+				ConditionCatalogOperatorAvailable: metav1.ConditionUnknown,
+				ConditionPackageServerAvailable:   metav1.ConditionUnknown,
+				ConditionPackagesAPIAvailable:     metav1.ConditionUnknown,
+				catalogCondition:                  metav1.ConditionFalse,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feedbacks := healthyFeedbacks()
+			tt.update(feedbacks)
+			conditions := healthConditions(agent.probedComponents(), testWork(feedbacks))
+			require.Equal(t, tt.expected, conditionStatuses(conditions))
+		})
+	}
+}
+
+func TestHealthControllerSync(t *testing.T) {
+	agent, _ := newTestAgent(t, PatchRenderingMode)
+	feedbacks := healthyFeedbacks()
+	feedbacks["deployments/olm-operator"] = deploymentFeedback(2, 1)
+	work := testWork(feedbacks)
+	controller := NewHealthController(agent, workfake.NewSimpleClientset(work))
+	require.NoError(t, controller.factory.Work().V1().ManifestWorks().Informer().GetIndexer().Add(work))
+
+	require.NoError(t, controller.sync(context.Background(), "cluster1/addon-olm-addon-deploy-0"))
+	addon, err := agent.addonClient.AddonV1alpha1().ManagedClusterAddOns("cluster1").Get(context.Background(),
+		"olm-addon", metav1.GetOptions{})
+	require.NoError(t, err)
+	condition := meta.FindStatusCondition(addon.Status.Conditions, ConditionDegraded)
+	require.NotNil(t, condition)
+	require.Equal(t, metav1.ConditionTrue, condition.Status)
+	require.Equal(t, "olm/olm-operator has 1 of 2 replicas ready", condition.Message)
+
+	feedbacks["poddisruptionbudgets/packageserver"] = pdbFeedback(3, 1)
+	work = testWork(feedbacks)
+	require.NoError(t, controller.factory.Work().V1().ManifestWorks().Informer().GetIndexer().Update(work))
+	require.NoError(t, controller.sync(context.Background(), "cluster1/addon-olm-addon-deploy-0"))
+	addon, err = agent.addonClient.AddonV1alpha1().ManagedClusterAddOns("cluster1").Get(context.Background(),
+		"olm-addon", metav1.GetOptions{})
+	require.NoError(t, err)
+	condition = meta.FindStatusCondition(addon.Status.Conditions, ConditionDegraded)
+	require.Equal(t, "olm/olm-operator has 1 of 2 replicas ready, olm/packageserver has 1 of 3 replicas ready",
+		condition.Message)
+	require.Len(t, addon.Status.Conditions, 6)
+
+	// works of other addons and deleted works are ignored
+	require.NoError(t, controller.sync(context.Background(), "cluster1/addon-other-deploy-0"))
+	require.NoError(t, controller.sync(context.Background(), "cluster2/addon-olm-addon-deploy-0"))
+}
+
+func TestCatalogSourceCondition(t *testing.T) {
+	identifier := workapiv1.ResourceIdentifier{Resource: "catalogsources", Name: "operatorhubio-catalog", Namespace: DefaultOLMNamespace}
+	condition := catalogSourceCondition("CatalogSourceReady-operatorhubio-catalog", identifier, catalogFeedback("TRANSIENT_FAILURE"))
+	require.Equal(t, metav1.ConditionFalse, condition.Status)
+	require.Equal(t, ReasonConnectionFailure, condition.Reason)
+	require.Equal(t, "CatalogSource olm/operatorhubio-catalog connection state is \"TRANSIENT_FAILURE\", "+
+		"last connection state change at 2023-06-01T10:00:00Z, last registry poll at 2023-06-01T11:00:00Z", condition.Message)
+
+	condition = catalogSourceCondition("CatalogSourceReady-operatorhubio-catalog", identifier, catalogFeedback("CONNECTING"))
+	require.Equal(t, metav1.ConditionUnknown, condition.Status)
+	require.NoError(t, healthCheck(identifier, catalogFeedback("TRANSIENT_FAILURE")), "catalogs do not affect the availability")
+}
+
+func TestOptionalProbes(t *testing.T) {
+	agent, addon := newTestAgent(t, PatchRenderingMode)
+	objects, err := agent.Manifests(newTestCluster("v1.27.3"), addon)
+	require.NoError(t, err)
+	require.Len(t, agent.probeFields(), 5)
+
+	// manifest sets without the disruption budget of the packageserver are not probed for it
+	withoutPDB := []runtime.Object{}
+	for _, obj := range objects {
+		if _, ok := obj.(*policyv1.PodDisruptionBudget); !ok {
+			withoutPDB = append(withoutPDB, obj)
+		}
+	}
+	agent.recordProbes("cluster2", withoutPDB)
+	for _, field := range agent.probeFields() {
+		require.NotEqual(t, "poddisruptionbudgets", field.ResourceIdentifier.Resource)
+	}
+	feedbacks := healthyFeedbacks()
+	delete(feedbacks, "poddisruptionbudgets/packageserver")
+	conditions := healthConditions(agent.fedBackComponents(), testWork(feedbacks))
+	require.True(t, meta.IsStatusConditionFalse(conditions, ConditionDegraded))
+
+	agent.undeployedProbes.forget("cluster2")
+	require.Len(t, agent.probeFields(), 5)
+}
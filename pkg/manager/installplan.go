@@ -0,0 +1,142 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+
+	workapiv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stolostron/olm-addon/pkg/apis/v1alpha1"
+)
+
+// subscriptionStatus is the status of a Subscription reported by the status feedback of its ManifestWork.
+type subscriptionStatus struct {
+	state        string
+	installedCSV string
+	// currentCSV is the ClusterServiceVersion being installed, the one of the pending InstallPlan
+	currentCSV  string
+	installPlan string
+}
+
+// pendingInstallPlan returns whether the Subscription waits for the approval of its InstallPlan.
+func (s subscriptionStatus) pendingInstallPlan() bool {
+	return s.state == string(olmv1alpha1.SubscriptionStateUpgradePending) && s.installPlan != "" &&
+		len(validation.IsDNS1123Subdomain(s.installPlan)) == 0
+}
+
+// installPlanIdentifier identifies an InstallPlan in the ManifestWorks of a FleetSubscription.
+func installPlanIdentifier(subscription *v1alpha1.FleetSubscription, name string) workapiv1.ResourceIdentifier {
+	return workapiv1.ResourceIdentifier{
+		Group:     olmv1alpha1.GroupName,
+		Resource:  "installplans",
+		Name:      name,
+		Namespace: subscription.Spec.Namespace,
+	}
+}
+
+// installPlanFeedbackRule reports the phase, the approval and the ClusterServiceVersions of InstallPlans.
+// The ClusterServiceVersion list is only reported by work agents running with the RawFeedbackJsonString feature gate.
+// The steps of the plan are not reported: JSON paths only return their first match and each step embeds
+// the whole manifest of the resource it creates, so the list does not fit in the status feedback.
+func installPlanFeedbackRule() workapiv1.FeedbackRule {
+	return workapiv1.FeedbackRule{
+		Type: workapiv1.JSONPathsType,
+		JsonPaths: []workapiv1.JsonPath{
+			{Name: "Phase", Path: ".status.phase"},
+			{Name: "Approved", Path: ".spec.approved"},
+			{Name: "ClusterServiceVersionNames", Path: ".spec.clusterServiceVersionNames"},
+		},
+	}
+}
+
+// installPlanManifest renders the InstallPlan applied to the managed cluster with server side apply.
+// Only the approval is set and only once approved from the hub, OLM owns the rest of the InstallPlan.
+func installPlanManifest(namespace, name string, approved bool) *unstructured.Unstructured {
+	plan := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	plan.SetAPIVersion(olmv1alpha1.SchemeGroupVersion.String())
+	plan.SetKind(olmv1alpha1.InstallPlanKind)
+	plan.SetNamespace(namespace)
+	plan.SetName(name)
+	if approved {
+		plan.Object["spec"] = map[string]interface{}{"approved": true}
+	}
+	return plan
+}
+
+// pendingInstallPlanStatus reports a pending InstallPlan from the status feedback of the ManifestWork.
+func pendingInstallPlanStatus(subscription *v1alpha1.FleetSubscription, observed subscriptionStatus,
+	work *workapiv1.ManifestWork) *v1alpha1.PendingInstallPlan {
+	status := &v1alpha1.PendingInstallPlan{Name: observed.installPlan, CSV: observed.currentCSV}
+	result := findFeedback(installPlanIdentifier(subscription, observed.installPlan), work)
+	if result == nil {
+		return status
+	}
+	for _, value := range result.Values {
+		switch {
+		case value.Name == "Phase" && value.Value.String != nil:
+			status.Phase = *value.Value.String
+		case value.Name == "Approved" && value.Value.Boolean != nil:
+			status.Approved = *value.Value.Boolean
+		case value.Name == "ClusterServiceVersionNames" && value.Value.JsonRaw != nil:
+			if err := json.Unmarshal([]byte(*value.Value.JsonRaw), &status.ClusterServiceVersionNames); err != nil {
+				status.ClusterServiceVersionNames = nil
+			}
+		}
+	}
+	return status
+}
+
+// decodeInstallPlanApproval converts an InstallPlanApproval from its unstructured representation.
+func decodeInstallPlanApproval(obj runtime.Object) (*v1alpha1.InstallPlanApproval, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+	approval := &v1alpha1.InstallPlanApproval{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, approval); err != nil {
+		return nil, fmt.Errorf("not able to decode InstallPlanApproval %s/%s: %w", u.GetNamespace(), u.GetName(), err)
+	}
+	return approval, nil
+}
+
+// installPlanApprovals returns the ClusterServiceVersions approved per cluster for a FleetSubscription.
+// InstallPlanApprovals without ClusterServiceVersion are ignored.
+func (c *FleetSubscriptionController) installPlanApprovals(
+	subscription *v1alpha1.FleetSubscription) (map[string]map[string]bool, error) {
+	objects, err := c.approvalLister.ByNamespace(subscription.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	approved := map[string]map[string]bool{}
+	for _, obj := range objects {
+		approval, err := decodeInstallPlanApproval(obj)
+		if err != nil {
+			return nil, err
+		}
+		if approval.Spec.FleetSubscription != subscription.Name || approval.Spec.CSV == "" {
+			continue
+		}
+		clusters := approval.Spec.Clusters
+		if approval.Spec.Placement != "" {
+			decided, err := c.decidedClusters(approval.Namespace, approval.Spec.Placement)
+			if err != nil {
+				return nil, err
+			}
+			clusters = append(append([]string{}, clusters...), decided...)
+		}
+		for _, cluster := range clusters {
+			if approved[cluster] == nil {
+				approved[cluster] = map[string]bool{}
+			}
+			approved[cluster][approval.Spec.CSV] = true
+		}
+	}
+	return approved, nil
+}
@@ -0,0 +1,143 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clusterfake "open-cluster-management.io/api/client/cluster/clientset/versioned/fake"
+	workfake "open-cluster-management.io/api/client/work/clientset/versioned/fake"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	workapiv1 "open-cluster-management.io/api/work/v1"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stolostron/olm-addon/pkg/apis/v1alpha1"
+)
+
+// pendingSubscriptionWork returns the ManifestWork of a cluster whose Subscription waits for the approval
+// of the InstallPlan installing etcdoperator.v0.9.4.
+func pendingSubscriptionWork(t *testing.T, subscription *v1alpha1.FleetSubscription, cluster string) *workapiv1.ManifestWork {
+	work, err := fleetSubscriptionWork(subscription, cluster, DefaultNamespaces(), subscriptionStatus{}, false)
+	require.NoError(t, err)
+	csvNames := `["etcdoperator.v0.9.4"]`
+	approved := false
+	feedback := stringFeedback(map[string]string{"Phase": "RequiresApproval"})
+	feedback.Values = append(feedback.Values,
+		workapiv1.FeedbackValue{Name: "Approved", Value: workapiv1.FieldValue{Type: workapiv1.Boolean, Boolean: &approved}},
+		workapiv1.FeedbackValue{Name: "ClusterServiceVersionNames",
+			Value: workapiv1.FieldValue{Type: workapiv1.JsonRaw, JsonRaw: &csvNames}})
+	work.Status.ResourceStatus.Manifests = []workapiv1.ManifestCondition{{
+		ResourceMeta: workapiv1.ManifestResourceMeta{Group: olmv1alpha1.GroupName, Resource: "subscriptions",
+			Name: "etcd", Namespace: "etcd"},
+		StatusFeedbacks: stringFeedback(map[string]string{"State": "UpgradePending", "CurrentCSV": "etcdoperator.v0.9.4",
+			"InstallPlan": "install-abcde"}),
+	}, {
+		ResourceMeta: workapiv1.ManifestResourceMeta{Group: olmv1alpha1.GroupName, Resource: "installplans",
+			Name: "install-abcde", Namespace: "etcd"},
+		StatusFeedbacks: feedback,
+	}}
+	return work
+}
+
+func toUnstructured(t *testing.T, obj interface{}) *unstructured.Unstructured {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	require.NoError(t, err)
+	return &unstructured.Unstructured{Object: content}
+}
+
+func TestInstallPlanWork(t *testing.T) {
+	subscription := newTestFleetSubscription()
+	observed := subscriptionStatus{state: "UpgradePending", currentCSV: "etcdoperator.v0.9.4", installPlan: "install-abcde"}
+
+	work, err := fleetSubscriptionWork(subscription, "cluster1", DefaultNamespaces(), observed, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Namespace", "OperatorGroup", "Subscription", "InstallPlan"}, manifestKinds(t, work))
+	require.Len(t, work.Spec.DeleteOption.SelectivelyOrphan.OrphaningRules, 3, "the InstallPlan is orphaned")
+	plan := &unstructured.Unstructured{}
+	require.NoError(t, json.Unmarshal(work.Spec.Workload.Manifests[3].Raw, &plan.Object))
+	require.Equal(t, "install-abcde", plan.GetName())
+	require.NotContains(t, plan.Object, "spec", "not approved")
+
+	work, err = fleetSubscriptionWork(subscription, "cluster1", DefaultNamespaces(), observed, true)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(work.Spec.Workload.Manifests[3].Raw, &plan.Object))
+	approved, _, _ := unstructured.NestedBool(plan.Object, "spec", "approved")
+	require.True(t, approved)
+
+	observed.state = "AtLatestKnown"
+	work, err = fleetSubscriptionWork(subscription, "cluster1", DefaultNamespaces(), observed, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Namespace", "OperatorGroup", "Subscription"}, manifestKinds(t, work),
+		"the InstallPlan is only added while pending")
+}
+
+func TestInstallPlanApproval(t *testing.T) {
+	ctx := context.Background()
+	subscription := newTestFleetSubscription()
+	subscription.Spec.InstallPlanApproval = olmv1alpha1.ApprovalManual
+	u := toUnstructured(t, subscription)
+	approval := toUnstructured(t, &v1alpha1.InstallPlanApproval{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "InstallPlanApproval"},
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd-0.9.4", Namespace: "fleet"},
+		Spec: v1alpha1.InstallPlanApprovalSpec{FleetSubscription: "etcd", CSV: "etcdoperator.v0.9.4",
+			Clusters: []string{"cluster1"}},
+	})
+	decision := &clusterv1beta1.PlacementDecision{
+		ObjectMeta: metav1.ObjectMeta{Name: "all-decision-1", Namespace: "fleet",
+			Labels: map[string]string{clusterv1beta1.PlacementLabel: "all"}},
+		Status: clusterv1beta1.PlacementDecisionStatus{Decisions: []clusterv1beta1.ClusterDecision{
+			{ClusterName: "cluster1"}, {ClusterName: "cluster2"},
+		}},
+	}
+	works := []*workapiv1.ManifestWork{
+		pendingSubscriptionWork(t, subscription, "cluster1"),
+		pendingSubscriptionWork(t, subscription, "cluster2"),
+	}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), u)
+	workClient := workfake.NewSimpleClientset(works[0], works[1])
+	controller := NewFleetSubscriptionController(dynamicClient, clusterfake.NewSimpleClientset(decision), workClient,
+		DefaultNamespaces())
+	require.NoError(t, controller.subscriptionFactory.ForResource(v1alpha1.FleetSubscriptionGVR).Informer().GetIndexer().Add(u))
+	require.NoError(t, controller.subscriptionFactory.ForResource(v1alpha1.InstallPlanApprovalGVR).Informer().GetIndexer().
+		Add(approval))
+	require.NoError(t, controller.clusterFactory.Cluster().V1beta1().PlacementDecisions().Informer().GetIndexer().Add(decision))
+	for _, work := range works {
+		require.NoError(t, controller.workFactory.Work().V1().ManifestWorks().Informer().GetIndexer().Add(work))
+	}
+
+	require.NoError(t, controller.sync(ctx, "fleet/etcd"))
+	for _, cluster := range []string{"cluster1", "cluster2"} {
+		work, err := workClient.WorkV1().ManifestWorks(cluster).Get(ctx, works[0].Name, metav1.GetOptions{})
+		require.NoError(t, err)
+		plan := &unstructured.Unstructured{}
+		require.NoError(t, json.Unmarshal(work.Spec.Workload.Manifests[3].Raw, &plan.Object))
+		approved, _, _ := unstructured.NestedBool(plan.Object, "spec", "approved")
+		require.Equal(t, cluster == "cluster1", approved, "InstallPlan of %s", cluster)
+	}
+
+	updated, err := dynamicClient.Resource(v1alpha1.FleetSubscriptionGVR).Namespace("fleet").Get(ctx, "etcd", metav1.GetOptions{})
+	require.NoError(t, err)
+	result, err := decodeFleetSubscription(updated)
+	require.NoError(t, err)
+	require.Equal(t, &v1alpha1.PendingInstallPlan{Name: "install-abcde", CSV: "etcdoperator.v0.9.4",
+		ClusterServiceVersionNames: []string{"etcdoperator.v0.9.4"}, Phase: "RequiresApproval"},
+		result.Status.Clusters[1].PendingInstallPlan)
+
+	// approvals of another version are ignored
+	approval = approval.DeepCopy()
+	approval.Object["spec"].(map[string]interface{})["csv"] = "etcdoperator.v0.9.2"
+	approval.Object["spec"].(map[string]interface{})["placement"] = "all"
+	require.NoError(t, controller.subscriptionFactory.ForResource(v1alpha1.InstallPlanApprovalGVR).Informer().GetIndexer().
+		Update(approval))
+	approvals, err := controller.installPlanApprovals(subscription)
+	require.NoError(t, err)
+	require.False(t, approvals["cluster1"]["etcdoperator.v0.9.4"])
+	require.True(t, approvals["cluster2"]["etcdoperator.v0.9.2"], "clusters selected by the Placement")
+}
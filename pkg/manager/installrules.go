@@ -0,0 +1,149 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+const (
+	// ConditionInstallAllowed reports whether the install rules allow deploying OLM on the cluster.
+	ConditionInstallAllowed = "InstallAllowed"
+
+	ReasonVendorProvidesOLM    = "VendorProvidesOLM"
+	ReasonNoInstallRuleMatched = "NoInstallRuleMatched"
+)
+
+// reasonPattern is the format of the reasons of the conditions.
+var reasonPattern = regexp.MustCompile(`^[A-Za-z]([A-Za-z0-9_,:]*[A-Za-z0-9_])?$`)
+
+// InstallRule decides whether OLM is deployed on the clusters with a label or a ClusterClaim.
+type InstallRule struct {
+	Name string `json:"name"`
+	// Label is the name of the label of the ManagedCluster matched by the rule.
+	Label string `json:"label,omitempty"`
+	// Claim is the name of the ClusterClaim of the ManagedCluster matched by the rule.
+	Claim string `json:"claim,omitempty"`
+	// Values of the label or claim matched by the rule, case insensitively. Any value matches when empty.
+	Values []string `json:"values,omitempty"`
+	// Install tells whether OLM is deployed on the matching clusters.
+	Install bool `json:"install"`
+	// Reason is reported in the InstallAllowed condition of the matching clusters.
+	Reason string `json:"reason"`
+}
+
+// DefaultInstallRules do not deploy OLM on OpenShift clusters, which ship it,
+// recognized by their vendor label or the claims of their product and OpenShift version.
+var DefaultInstallRules = []InstallRule{
+	{Name: "openshift-vendor", Label: "vendor", Values: []string{OpenShiftVendor}, Reason: ReasonVendorProvidesOLM},
+	{
+		Name:   "openshift-product",
+		Claim:  "product.open-cluster-management.io",
+		Values: []string{OpenShiftVendor, "OpenShiftDedicated", "ROSA", "ARO", "ROKS"},
+		Reason: ReasonVendorProvidesOLM,
+	},
+	{Name: "openshift-version", Claim: "version.openshift.io", Reason: ReasonVendorProvidesOLM},
+}
+
+// LoadInstallRules reads the install rules from a YAML file, the default rules are returned when path is empty.
+func LoadInstallRules(path string) ([]InstallRule, error) {
+	if path == "" {
+		return DefaultInstallRules, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := []InstallRule{}
+	if err := yaml.UnmarshalStrict(content, &rules); err != nil {
+		return nil, fmt.Errorf("invalid install rules %s: %w", path, err)
+	}
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid install rules %s: %w", path, err)
+		}
+	}
+	return rules, nil
+}
+
+// Validate checks that the rule matches either a label or a claim and that its reason can be used in conditions.
+func (r InstallRule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("the name of the install rule is required")
+	}
+	if (r.Label == "") == (r.Claim == "") {
+		return fmt.Errorf("install rule %s must match either a label or a claim", r.Name)
+	}
+	if !reasonPattern.MatchString(r.Reason) {
+		return fmt.Errorf("install rule %s has an invalid reason %q, a CamelCase reason is expected", r.Name, r.Reason)
+	}
+	return nil
+}
+
+// matches returns the label or claim of the cluster matched by the rule.
+func (r InstallRule) matches(cluster *clusterv1.ManagedCluster) (string, bool) {
+	kind, name, value, found := "label", r.Label, "", false
+	if r.Label != "" {
+		value, found = cluster.Labels[r.Label]
+	} else {
+		kind, name = "claim", r.Claim
+		for _, claim := range cluster.Status.ClusterClaims {
+			if claim.Name == r.Claim {
+				value, found = claim.Value, true
+				break
+			}
+		}
+	}
+	if !found {
+		return "", false
+	}
+	if len(r.Values) == 0 {
+		return fmt.Sprintf("%s %s", kind, name), true
+	}
+	for _, expected := range r.Values {
+		if strings.EqualFold(value, expected) {
+			return fmt.Sprintf("%s %s=%s", kind, name, value), true
+		}
+	}
+	return "", false
+}
+
+// installCondition evaluates the install rules in order against a cluster, the first matching rule decides whether
+// OLM is deployed. OLM is deployed on the clusters matching no rule.
+func installCondition(rules []InstallRule, cluster *clusterv1.ManagedCluster) (bool, metav1.Condition) {
+	for _, rule := range rules {
+		matched, ok := rule.matches(cluster)
+		if !ok {
+			continue
+		}
+		condition := metav1.Condition{
+			Type:    ConditionInstallAllowed,
+			Status:  metav1.ConditionTrue,
+			Reason:  rule.Reason,
+			Message: fmt.Sprintf("install rule %s allows deploying OLM on clusters with %s", rule.Name, matched),
+		}
+		if !rule.Install {
+			condition.Status = metav1.ConditionFalse
+			condition.Message = fmt.Sprintf("install rule %s does not deploy OLM on clusters with %s", rule.Name, matched)
+		}
+		return rule.Install, condition
+	}
+	return true, metav1.Condition{
+		Type:    ConditionInstallAllowed,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonNoInstallRuleMatched,
+		Message: "no install rule matches the cluster",
+	}
+}
+
+// clusterSupportsAddonInstall filters the clusters with the install rules.
+func (o *olmAgent) clusterSupportsAddonInstall(cluster *clusterv1.ManagedCluster) bool {
+	install, _ := installCondition(o.installRules, cluster)
+	return install
+}
@@ -0,0 +1,104 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstallCondition(t *testing.T) {
+	testCases := []struct {
+		name    string
+		labels  map[string]string
+		claims  map[string]string
+		install bool
+		reason  string
+		message string
+	}{
+		{name: "kind", labels: map[string]string{"vendor": "Kind"}, install: true, reason: ReasonNoInstallRuleMatched},
+		{name: "unlabeled", install: true, reason: ReasonNoInstallRuleMatched},
+		{
+			name:    "openshift vendor",
+			labels:  map[string]string{"vendor": "openshift"},
+			reason:  ReasonVendorProvidesOLM,
+			message: "install rule openshift-vendor does not deploy OLM on clusters with label vendor=openshift",
+		},
+		{
+			name:    "unlabeled openshift",
+			claims:  map[string]string{"product.open-cluster-management.io": "ROSA"},
+			reason:  ReasonVendorProvidesOLM,
+			message: "install rule openshift-product does not deploy OLM on clusters with claim product.open-cluster-management.io=ROSA",
+		},
+		{
+			name:    "openshift version",
+			claims:  map[string]string{"version.openshift.io": "4.13.4"},
+			reason:  ReasonVendorProvidesOLM,
+			message: "install rule openshift-version does not deploy OLM on clusters with claim version.openshift.io",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cluster := newTestCluster("v1.27.3")
+			cluster.Labels = tc.labels
+			for name, value := range tc.claims {
+				cluster.Status.ClusterClaims = append(cluster.Status.ClusterClaims,
+					clusterv1.ManagedClusterClaim{Name: name, Value: value})
+			}
+			install, condition := installCondition(DefaultInstallRules, cluster)
+			require.Equal(t, tc.install, install)
+			require.Equal(t, tc.reason, condition.Reason)
+			if tc.message != "" {
+				require.Equal(t, tc.message, condition.Message)
+			}
+			if install {
+				require.Equal(t, metav1.ConditionTrue, condition.Status)
+			} else {
+				require.Equal(t, metav1.ConditionFalse, condition.Status)
+			}
+		})
+	}
+}
+
+func TestLoadInstallRules(t *testing.T) {
+	rules, err := LoadInstallRules("")
+	require.NoError(t, err)
+	require.Equal(t, DefaultInstallRules, rules)
+
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+- name: microshift-with-olm
+  label: olm.addon.stolostron.io/install
+  values: ["true"]
+  install: true
+  reason: InstallRequested
+- name: managed-platform
+  claim: platform.open-cluster-management.io
+  values: [IBM]
+  reason: VendorProvidesOLM
+`), 0o600))
+	rules, err = LoadInstallRules(path)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	cluster := newTestCluster("v1.27.3")
+	cluster.Labels = map[string]string{"olm.addon.stolostron.io/install": "true"}
+	cluster.Status.ClusterClaims = []clusterv1.ManagedClusterClaim{{Name: "platform.open-cluster-management.io", Value: "IBM"}}
+	install, condition := installCondition(rules, cluster)
+	require.True(t, install, "the first matching rule decides")
+	require.Equal(t, "InstallRequested", condition.Reason)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+- name: both
+  label: vendor
+  claim: product.open-cluster-management.io
+  reason: VendorProvidesOLM
+`), 0o600))
+	_, err = LoadInstallRules(path)
+	require.ErrorContains(t, err, "must match either a label or a claim")
+	require.ErrorContains(t, InstallRule{Name: "spaces", Label: "vendor", Reason: "not a reason"}.Validate(),
+		"invalid reason")
+}
@@ -0,0 +1,287 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"k8s.io/klog/v2"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	workclientset "open-cluster-management.io/api/client/work/clientset/versioned"
+	workinformers "open-cluster-management.io/api/client/work/informers/externalversions"
+	worklisters "open-cluster-management.io/api/client/work/listers/work/v1"
+	workapiv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stolostron/olm-addon/pkg/apis/v1alpha1"
+)
+
+const (
+	// FleetOperatorInventoryName is the name of the FleetOperatorInventory.
+	FleetOperatorInventoryName = "fleet"
+
+	// fleetInventoryKey is the queue key of the FleetOperatorInventory,
+	// the other keys are cluster names which cannot contain a slash.
+	fleetInventoryKey = "/" + FleetOperatorInventoryName
+
+	// inventoryResync is the interval at which the inventories are recomputed from the ManifestWorks.
+	inventoryResync = 10 * time.Minute
+)
+
+// workOperators returns the ClusterServiceVersions whose status is fed back by a ManifestWork:
+// the packageserver ClusterServiceVersion of OLM and the ones installed by FleetSubscriptions.
+// The status feedback only covers the resources a ManifestWork applies, the ClusterServiceVersions
+// installed on the cluster by other means cannot be listed.
+func workOperators(work *workapiv1.ManifestWork) []v1alpha1.InstalledOperator {
+	operators := []v1alpha1.InstalledOperator{}
+	for _, manifest := range work.Status.ResourceStatus.Manifests {
+		if manifest.ResourceMeta.Group != olmv1alpha1.GroupName || manifest.ResourceMeta.Resource != "clusterserviceversions" ||
+			len(manifest.StatusFeedbacks.Values) == 0 {
+			continue
+		}
+		csv := parseCSVFeedback(manifest.StatusFeedbacks)
+		operators = append(operators, v1alpha1.InstalledOperator{
+			Name:              manifest.ResourceMeta.Name,
+			Namespace:         manifest.ResourceMeta.Namespace,
+			Version:           csv.version,
+			Phase:             csv.phase,
+			FleetSubscription: work.Annotations[FleetSubscriptionAnnotation],
+		})
+	}
+	return operators
+}
+
+// clusterInventory lists the operators reported by the ManifestWorks of a cluster, sorted by namespace and name.
+func clusterInventory(works []*workapiv1.ManifestWork) v1alpha1.OperatorInventoryStatus {
+	status := v1alpha1.OperatorInventoryStatus{}
+	for _, work := range works {
+		status.Operators = append(status.Operators, workOperators(work)...)
+	}
+	sort.Slice(status.Operators, func(i, j int) bool {
+		if status.Operators[i].Namespace != status.Operators[j].Namespace {
+			return status.Operators[i].Namespace < status.Operators[j].Namespace
+		}
+		return status.Operators[i].Name < status.Operators[j].Name
+	})
+	return status
+}
+
+// fleetInventory aggregates the operators of the clusters by name and version.
+func fleetInventory(clusters map[string][]*workapiv1.ManifestWork) v1alpha1.FleetOperatorInventoryStatus {
+	operators := map[string]*v1alpha1.FleetOperator{}
+	for cluster, works := range clusters {
+		for _, operator := range clusterInventory(works).Operators {
+			key := operator.Name + "@" + operator.Version
+			if operators[key] == nil {
+				operators[key] = &v1alpha1.FleetOperator{Name: operator.Name, Version: operator.Version}
+			}
+			operators[key].Clusters = append(operators[key].Clusters, v1alpha1.ClusterOperator{
+				Cluster:   cluster,
+				Namespace: operator.Namespace,
+				Phase:     operator.Phase,
+			})
+		}
+	}
+	status := v1alpha1.FleetOperatorInventoryStatus{}
+	for _, operator := range operators {
+		sort.Slice(operator.Clusters, func(i, j int) bool {
+			if operator.Clusters[i].Cluster != operator.Clusters[j].Cluster {
+				return operator.Clusters[i].Cluster < operator.Clusters[j].Cluster
+			}
+			return operator.Clusters[i].Namespace < operator.Clusters[j].Namespace
+		})
+		status.Operators = append(status.Operators, *operator)
+	}
+	sort.Slice(status.Operators, func(i, j int) bool {
+		if status.Operators[i].Name != status.Operators[j].Name {
+			return status.Operators[i].Name < status.Operators[j].Name
+		}
+		return status.Operators[i].Version < status.Operators[j].Version
+	})
+	return status
+}
+
+// InventoryController maintains an OperatorInventory per managed cluster and the FleetOperatorInventory
+// from the status feedback of the ManifestWorks deploying OLM and the FleetSubscriptions.
+type InventoryController struct {
+	agent                   *olmAgent
+	dynamicClient           dynamic.Interface
+	addonWorkFactory        workinformers.SharedInformerFactory
+	subscriptionWorkFactory workinformers.SharedInformerFactory
+	addonWorkLister         worklisters.ManifestWorkLister
+	subscriptionWorkLister  worklisters.ManifestWorkLister
+	synced                  []cache.InformerSynced
+	queue                   workqueue.RateLimitingInterface
+}
+
+// NewInventoryController watches the ManifestWorks of the addon and of the FleetSubscriptions.
+func NewInventoryController(agent *olmAgent, dynamicClient dynamic.Interface,
+	workClient workclientset.Interface) *InventoryController {
+	addonWorkFactory := workinformers.NewSharedInformerFactoryWithOptions(workClient, inventoryResync,
+		workinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = fmt.Sprintf("%s=%s", addonapiv1alpha1.AddonLabelKey, agent.addonName)
+		}))
+	subscriptionWorkFactory := workinformers.NewSharedInformerFactoryWithOptions(workClient, inventoryResync,
+		workinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = FleetSubscriptionLabel
+		}))
+	addonWorkInformer := addonWorkFactory.Work().V1().ManifestWorks()
+	subscriptionWorkInformer := subscriptionWorkFactory.Work().V1().ManifestWorks()
+	c := &InventoryController{
+		agent:                   agent,
+		dynamicClient:           dynamicClient,
+		addonWorkFactory:        addonWorkFactory,
+		subscriptionWorkFactory: subscriptionWorkFactory,
+		addonWorkLister:         addonWorkInformer.Lister(),
+		subscriptionWorkLister:  subscriptionWorkInformer.Lister(),
+		synced: []cache.InformerSynced{addonWorkInformer.Informer().HasSynced,
+			subscriptionWorkInformer.Informer().HasSynced},
+		queue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "olm-addon-inventory"),
+	}
+	enqueue := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		if work, ok := obj.(*workapiv1.ManifestWork); ok {
+			c.queue.Add(work.Namespace)
+			c.queue.Add(fleetInventoryKey)
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+		DeleteFunc: enqueue,
+	}
+	_, _ = addonWorkInformer.Informer().AddEventHandler(handler)
+	_, _ = subscriptionWorkInformer.Informer().AddEventHandler(handler)
+	return c
+}
+
+// Run maintains the inventories until the context is cancelled.
+func (c *InventoryController) Run(ctx context.Context) {
+	defer c.queue.ShutDown()
+	c.addonWorkFactory.Start(ctx.Done())
+	c.subscriptionWorkFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		return
+	}
+	go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	<-ctx.Done()
+}
+
+func (c *InventoryController) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *InventoryController) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+	if err := c.sync(ctx, key.(string)); err != nil {
+		klog.ErrorS(err, "Not able to update the operator inventory", "key", key)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// works returns the ManifestWorks reporting operators by cluster, all clusters when cluster is empty.
+func (c *InventoryController) works(cluster string) (map[string][]*workapiv1.ManifestWork, error) {
+	addonWorks, err := c.addonWorkLister.ManifestWorks(cluster).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	subscriptionWorks, err := c.subscriptionWorkLister.ManifestWorks(cluster).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	works := map[string][]*workapiv1.ManifestWork{}
+	for _, work := range addonWorks {
+		if strings.HasPrefix(work.Name, constants.DeployWorkNamePrefix(c.agent.addonName)) {
+			works[work.Namespace] = append(works[work.Namespace], work)
+		}
+	}
+	for _, work := range subscriptionWorks {
+		works[work.Namespace] = append(works[work.Namespace], work)
+	}
+	return works, nil
+}
+
+// sync updates the OperatorInventory of a cluster or the FleetOperatorInventory.
+// The OperatorInventory of a cluster without ManifestWork is deleted.
+func (c *InventoryController) sync(ctx context.Context, key string) error {
+	if key == fleetInventoryKey {
+		works, err := c.works(metav1.NamespaceAll)
+		if err != nil {
+			return err
+		}
+		status := fleetInventory(works)
+		return c.apply(ctx, v1alpha1.FleetOperatorInventoryGVR, "FleetOperatorInventory", "",
+			FleetOperatorInventoryName, &status)
+	}
+	works, err := c.works(key)
+	if err != nil {
+		return err
+	}
+	if len(works[key]) == 0 {
+		err := c.dynamicClient.Resource(v1alpha1.OperatorInventoryGVR).Namespace(key).Delete(ctx, c.agent.addonName,
+			metav1.DeleteOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	status := clusterInventory(works[key])
+	return c.apply(ctx, v1alpha1.OperatorInventoryGVR, "OperatorInventory", key, c.agent.addonName, &status)
+}
+
+// apply creates the inventory or updates it when its status, a pointer, changed.
+func (c *InventoryController) apply(ctx context.Context, gvr schema.GroupVersionResource, kind, namespace, name string,
+	status interface{}) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+	if err != nil {
+		return err
+	}
+	client := c.dynamicClient.Resource(gvr).Namespace(namespace)
+	existing, err := client.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		inventory := &unstructured.Unstructured{Object: map[string]interface{}{"status": content}}
+		inventory.SetAPIVersion(gvr.GroupVersion().String())
+		inventory.SetKind(kind)
+		inventory.SetNamespace(namespace)
+		inventory.SetName(name)
+		_, err = client.Create(ctx, inventory, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if current, _, _ := unstructured.NestedMap(existing.Object, "status"); equality.Semantic.DeepEqual(current, content) {
+		return nil
+	}
+	updated := existing.DeepCopy()
+	updated.Object["status"] = content
+	_, err = client.Update(ctx, updated, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	workfake "open-cluster-management.io/api/client/work/clientset/versioned/fake"
+	workapiv1 "open-cluster-management.io/api/work/v1"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stolostron/olm-addon/pkg/apis/v1alpha1"
+)
+
+// csvWork returns a ManifestWork of a cluster reporting the status of ClusterServiceVersions in a namespace,
+// given as name to version.
+func csvWork(cluster, name, namespace string, csvs map[string]string) *workapiv1.ManifestWork {
+	work := &workapiv1.ManifestWork{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: cluster}}
+	for csv, version := range csvs {
+		work.Status.ResourceStatus.Manifests = append(work.Status.ResourceStatus.Manifests, workapiv1.ManifestCondition{
+			ResourceMeta: workapiv1.ManifestResourceMeta{Group: olmv1alpha1.GroupName, Resource: "clusterserviceversions",
+				Name: csv, Namespace: namespace},
+			StatusFeedbacks: stringFeedback(map[string]string{"Phase": "Succeeded", "Version": version}),
+		})
+	}
+	return work
+}
+
+func TestFleetInventory(t *testing.T) {
+	subscriptionWork := csvWork("cluster1", "fleet-subscription-1", "etcd", map[string]string{"etcdoperator.v0.9.4": "0.9.4"})
+	subscriptionWork.Annotations = map[string]string{FleetSubscriptionAnnotation: "fleet/etcd"}
+	// the subscription ManifestWork also reports the Subscription, which is not an operator
+	subscriptionWork.Status.ResourceStatus.Manifests = append(subscriptionWork.Status.ResourceStatus.Manifests,
+		workapiv1.ManifestCondition{
+			ResourceMeta:    workapiv1.ManifestResourceMeta{Group: olmv1alpha1.GroupName, Resource: "subscriptions", Name: "etcd", Namespace: "etcd"},
+			StatusFeedbacks: stringFeedback(map[string]string{"State": "AtLatestKnown"}),
+		})
+	clusters := map[string][]*workapiv1.ManifestWork{
+		"cluster1": {
+			csvWork("cluster1", "addon-olm-addon-deploy-0", "olm", map[string]string{"packageserver": "0.25.0"}),
+			subscriptionWork,
+		},
+		"cluster2": {
+			csvWork("cluster2", "addon-olm-addon-deploy-0", "olm", map[string]string{"packageserver": "0.24.0"}),
+		},
+		"cluster3": {
+			csvWork("cluster3", "addon-olm-addon-deploy-0", "olm", map[string]string{"packageserver": "0.25.0"}),
+		},
+	}
+
+	require.Equal(t, []v1alpha1.InstalledOperator{
+		{Name: "etcdoperator.v0.9.4", Namespace: "etcd", Version: "0.9.4", Phase: "Succeeded", FleetSubscription: "fleet/etcd"},
+		{Name: "packageserver", Namespace: "olm", Version: "0.25.0", Phase: "Succeeded"},
+	}, clusterInventory(clusters["cluster1"]).Operators)
+
+	require.Equal(t, []v1alpha1.FleetOperator{
+		{Name: "etcdoperator.v0.9.4", Version: "0.9.4", Clusters: []v1alpha1.ClusterOperator{
+			{Cluster: "cluster1", Namespace: "etcd", Phase: "Succeeded"},
+		}},
+		{Name: "packageserver", Version: "0.24.0", Clusters: []v1alpha1.ClusterOperator{
+			{Cluster: "cluster2", Namespace: "olm", Phase: "Succeeded"},
+		}},
+		{Name: "packageserver", Version: "0.25.0", Clusters: []v1alpha1.ClusterOperator{
+			{Cluster: "cluster1", Namespace: "olm", Phase: "Succeeded"},
+			{Cluster: "cluster3", Namespace: "olm", Phase: "Succeeded"},
+		}},
+	}, fleetInventory(clusters).Operators)
+}
+
+func TestInventoryControllerSync(t *testing.T) {
+	ctx := context.Background()
+	agent, _ := newTestAgent(t, PatchRenderingMode)
+	addonWork := csvWork("cluster1", "addon-olm-addon-deploy-0", "olm", map[string]string{"packageserver": "0.25.0"})
+	otherWork := csvWork("cluster1", "addon-other-deploy-0", "other", map[string]string{"other": "1.0.0"})
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	controller := NewInventoryController(agent, dynamicClient, workfake.NewSimpleClientset(addonWork))
+	indexer := controller.addonWorkFactory.Work().V1().ManifestWorks().Informer().GetIndexer()
+	require.NoError(t, indexer.Add(addonWork))
+	require.NoError(t, indexer.Add(otherWork))
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, controller.sync(ctx, "cluster1"))
+		require.NoError(t, controller.sync(ctx, fleetInventoryKey))
+	}
+	inventory, err := dynamicClient.Resource(v1alpha1.OperatorInventoryGVR).Namespace("cluster1").Get(ctx, "olm-addon",
+		metav1.GetOptions{})
+	require.NoError(t, err)
+	status := v1alpha1.OperatorInventory{}
+	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(inventory.Object, &status))
+	require.Equal(t, "OperatorInventory", status.Kind)
+	require.Equal(t, []v1alpha1.InstalledOperator{{Name: "packageserver", Namespace: "olm", Version: "0.25.0",
+		Phase: "Succeeded"}}, status.Status.Operators, "the works of other addons are ignored")
+
+	fleet, err := dynamicClient.Resource(v1alpha1.FleetOperatorInventoryGVR).Get(ctx, FleetOperatorInventoryName,
+		metav1.GetOptions{})
+	require.NoError(t, err)
+	fleetStatus := v1alpha1.FleetOperatorInventory{}
+	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(fleet.Object, &fleetStatus))
+	require.Len(t, fleetStatus.Status.Operators, 1)
+
+	// the inventory of a cluster without ManifestWork is deleted
+	require.NoError(t, indexer.Delete(addonWork))
+	require.NoError(t, controller.sync(ctx, "cluster1"))
+	_, err = dynamicClient.Resource(v1alpha1.OperatorInventoryGVR).Namespace("cluster1").Get(ctx, "olm-addon",
+		metav1.GetOptions{})
+	require.Error(t, err)
+	require.NoError(t, controller.sync(ctx, "cluster2"))
+}
@@ -3,22 +3,27 @@ package manager
 import (
 	"bufio"
 	"bytes"
-	"embed"
+	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"strings"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/version"
 
 	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 
 	"k8s.io/klog/v2"
 
@@ -28,10 +33,11 @@ import (
 
 	"open-cluster-management.io/addon-framework/pkg/addonfactory"
 	agentfw "open-cluster-management.io/addon-framework/pkg/agent"
-	"open-cluster-management.io/addon-framework/pkg/utils"
 	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	addonv1alpha1client "open-cluster-management.io/api/client/addon/clientset/versioned"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	"github.com/stolostron/olm-addon/pkg/apis/v1alpha1"
 )
 
 const (
@@ -43,27 +49,136 @@ var manifestFiles = [4]string{"crds.yaml", "permissions.yaml", "olm.yaml", "clea
 
 // olmAgent implements the AgentAddon interface and contains the addon configuration.
 type olmAgent struct {
-	addonClient  addonv1alpha1client.Interface
-	addonName    string
-	olmManifests embed.FS
+	addonClient addonv1alpha1client.Interface
+	// dynamicClient retrieves the OLMCatalogs referenced by the addons
+	dynamicClient dynamic.Interface
+	addonName     string
+	// source provides the manifest sets, they are reloaded when it changes
+	source        ManifestSource
+	versionPolicy VersionPolicy
+	// catalog contains the manifest sets currently served, it is replaced when the manifests are reloaded
+	catalog    *manifestCatalog
+	namespaces Namespaces
+	// probedCatalogs are the CatalogSources of the OLM namespace whose health is reported
+	probedCatalogs []string
+	// undeployedProbes tracks the clusters on which optional probed resources are not deployed
+	undeployedProbes *probeTracker
+	// renderingMode defines whether the configuration is patched into the objects or used for rendering the templates.
+	renderingMode RenderingMode
+	// installRules decide on which clusters OLM is deployed
+	installRules []InstallRule
+	// recorder records the hub events about the ManagedClusterAddOns, none are recorded when nil
+	recorder record.EventRecorder
 }
 
 // NewOLMAgent instantiates a new olmAgent, which implements the AgentAddon interface and contains the addon configuration.
-func NewOLMAgent(addonClient addonv1alpha1client.Interface, addonName string, olmManifests embed.FS) (olmAgent, error) {
-	if err := olmv1alpha1.AddToScheme(scheme.Scheme); err != nil {
+// The manifests are retrieved from the source at creation and reloaded by WatchManifests when the source changes.
+// versionPolicy defines which manifest set is used for clusters whose Kubernetes version is not supported.
+// renderingMode defines how the AddOnDeploymentConfig is applied to the manifest templates.
+// namespaces are the namespaces OLM gets installed into on all the managed clusters.
+// probedCatalogs are the CatalogSources of the OLM namespace whose connection state is reported to the hub.
+// dynamicClient retrieves the OLMCatalogs configuring the catalogs, they are ignored when nil.
+// installRules decide on which clusters OLM is deployed, DefaultInstallRules are used when nil.
+// recorder records the hub events about the ManagedClusterAddOns, e.g. when the installation is skipped.
+func NewOLMAgent(addonClient addonv1alpha1client.Interface, dynamicClient dynamic.Interface, addonName string,
+	source ManifestSource, versionPolicy VersionPolicy, renderingMode RenderingMode, namespaces Namespaces,
+	probedCatalogs []string, installRules []InstallRule, recorder record.EventRecorder) (olmAgent, error) {
+	if err := addToScheme(); err != nil {
 		return olmAgent{}, err
 	}
-	if err := olmv1alpha2.AddToScheme(scheme.Scheme); err != nil {
+	if err := namespaces.Validate(); err != nil {
 		return olmAgent{}, err
 	}
-	if err := olmv1.AddToScheme(scheme.Scheme); err != nil {
+	if installRules == nil {
+		installRules = DefaultInstallRules
+	}
+	for _, rule := range installRules {
+		if err := rule.Validate(); err != nil {
+			return olmAgent{}, err
+		}
+	}
+	for _, catalog := range probedCatalogs {
+		if errs := validation.IsDNS1123Subdomain(catalog); len(errs) > 0 {
+			return olmAgent{}, fmt.Errorf("invalid CatalogSource name %q: %s", catalog, strings.Join(errs, ", "))
+		}
+	}
+	agent := olmAgent{
+		addonClient:      addonClient,
+		dynamicClient:    dynamicClient,
+		addonName:        addonName,
+		source:           source,
+		versionPolicy:    versionPolicy,
+		catalog:          &manifestCatalog{},
+		namespaces:       namespaces,
+		probedCatalogs:   probedCatalogs,
+		undeployedProbes: newProbeTracker(),
+		renderingMode:    renderingMode,
+		installRules:     installRules,
+		recorder:         recorder,
+	}
+	olmManifests, err := source.Manifests(context.Background())
+	if err != nil {
+		return olmAgent{}, fmt.Errorf("not able to retrieve the manifests: %w", err)
+	}
+	loaded, err := agent.loadManifests(olmManifests)
+	if err != nil {
 		return olmAgent{}, err
 	}
-	return olmAgent{
-		addonClient:  addonClient,
-		addonName:    addonName,
-		olmManifests: olmManifests,
-	}, nil
+	agent.catalog.set(loaded)
+	// the addon framework renders the manifests of all the clusters at start
+	agent.catalog.setRequeued(loaded.revision)
+	return agent, nil
+}
+
+// loadManifests parses and validates the manifest sets of the file system.
+func (o *olmAgent) loadManifests(olmManifests fs.FS) (loadedManifests, error) {
+	revision, err := manifestsRevision(olmManifests)
+	if err != nil {
+		return loadedManifests{}, err
+	}
+	resolver, err := newVersionResolver(olmManifests, o.versionPolicy)
+	if err != nil {
+		return loadedManifests{}, err
+	}
+	matrix, err := loadCompatibilityMatrix(olmManifests)
+	if err != nil {
+		return loadedManifests{}, err
+	}
+	if err := matrix.apply(resolver, olmManifests); err != nil {
+		return loadedManifests{}, err
+	}
+	files := map[string][]manifestFile{}
+	for i := range resolver.sets {
+		resolver.sets[i].namespaces = o.namespaces
+		set := resolver.sets[i]
+		files[set.Name()], err = loadManifestSet(olmManifests, set)
+		if err != nil {
+			return loadedManifests{}, err
+		}
+		if err := checkNamespaces(set, files[set.Name()]); err != nil {
+			return loadedManifests{}, err
+		}
+		objects := 0
+		for _, file := range files[set.Name()] {
+			objects += len(file.objects)
+		}
+		klog.InfoS("Manifest set available", "manifests", set.Name(), "olmVersion", set.olmVersion,
+			"kubernetesMin", set.minVersion.String(), "kubernetesMax", set.maxVersion.String(), "policy", o.versionPolicy,
+			"renderingMode", o.renderingMode, "olmNamespace", o.namespaces.OLM, "operatorsNamespace", o.namespaces.Operators,
+			"objects", objects, "revision", revision)
+	}
+	return loadedManifests{resolver: resolver, files: files, revision: revision}, nil
+}
+
+// addToScheme registers the OLM types so that they can be decoded from the manifests.
+func addToScheme() error {
+	if err := olmv1alpha1.AddToScheme(scheme.Scheme); err != nil {
+		return err
+	}
+	if err := olmv1alpha2.AddToScheme(scheme.Scheme); err != nil {
+		return err
+	}
+	return olmv1.AddToScheme(scheme.Scheme)
 }
 
 // Manifests returns a list of objects to be deployed on the managed clusters for this addon.
@@ -71,9 +186,13 @@ func NewOLMAgent(addonClient addonv1alpha1client.Interface, addonName string, ol
 // otherwise the addon deployment will constantly fail.
 func (o *olmAgent) Manifests(cluster *clusterv1.ManagedCluster,
 	addon *addonapiv1alpha1.ManagedClusterAddOn) ([]runtime.Object, error) {
-	if !clusterSupportsAddonInstall(cluster) {
-		klog.V(1).InfoS("Cluster may be OpenShift, not deploying olm addon. Please label the cluster with a \"vendor\" value different from \"OpenShift\" otherwise.", "addonName",
-			o.addonName, "cluster", cluster.GetName())
+	install, condition := installCondition(o.installRules, cluster)
+	o.updateConditions(addon, condition)
+	if !install {
+		klog.V(1).InfoS("Install rules do not allow the cluster, not deploying olm addon", "addonName",
+			o.addonName, "cluster", cluster.GetName(), "reason", condition.Reason, "message", condition.Message)
+		o.reportInstall(addon, true, condition.Reason, condition.Message)
+		o.undeployedProbes.forget(cluster.Name)
 		return []runtime.Object{}, nil
 	}
 
@@ -84,19 +203,46 @@ func (o *olmAgent) Manifests(cluster *clusterv1.ManagedCluster,
 			cluster.GetName(), "version", cluster.Status.Version.Kubernetes)
 		kubeVersion, _ = version.ParseSemantic(defaultVersion)
 	}
-	klog.V(1).InfoS("Cluster version", "cluster",
-		cluster.GetName(), "version", kubeVersion.String())
-
-	objects := []runtime.Object{}
-	// Keep the ordering defined in the file list and content
-	for _, file := range manifestFiles {
-		file = fmt.Sprintf("manifests/v%d.%d/%s", kubeVersion.Major(), kubeVersion.Minor(), file)
-		fileContent, err := loadManifestsFromFile(file, o.olmManifests)
-		if err != nil {
-			return nil, err
+	// the same manifests are used for the whole rendering even if they get reloaded meanwhile
+	manifests := o.catalog.get()
+	set, reason, err := manifests.resolver.resolve(kubeVersion)
+	if err != nil {
+		klog.V(1).InfoS("No manifest set for the cluster version, not deploying olm addon", "cluster",
+			cluster.GetName(), "version", kubeVersion.String(), "reason", err.Error())
+		o.updateConditions(addon, metav1.Condition{
+			Type:    ConditionManifestSetSelected,
+			Status:  metav1.ConditionFalse,
+			Reason:  reason,
+			Message: err.Error(),
+		})
+		o.reportInstall(addon, true, reason, err.Error())
+		o.undeployedProbes.forget(cluster.Name)
+		return []runtime.Object{}, nil
+	}
+	message := fmt.Sprintf("manifest set %s with OLM %s selected for Kubernetes %s", set.Name(), set.olmVersion,
+		kubeVersion.String())
+	// a rollback takes precedence over the manifest set selected for the cluster version
+	rollback := rolledBackRelease(addon)
+	if rollback != nil && rollback.Manifests != "" {
+		if previous, previousSet, ok := o.catalog.lookup(rollback.Manifests, rollback.Revision); ok {
+			manifests, set, reason = previous, previousSet, ReasonLastKnownGood
+			message = fmt.Sprintf("manifest set %s with OLM %s of revision %s selected for Kubernetes %s by the rollback",
+				set.Name(), set.olmVersion, shortRevision(manifests.revision), kubeVersion.String())
+		} else {
+			klog.InfoS("The manifest set of the last known good release is not available, using the selected one",
+				"cluster", cluster.GetName(), "manifests", rollback.Manifests, "revision", rollback.Revision)
 		}
-		objects = append(objects, fileContent...)
 	}
+	klog.V(1).InfoS("Cluster version", "cluster",
+		cluster.GetName(), "version", kubeVersion.String(), "manifests", set.Name(), "olmVersion", set.olmVersion,
+		"revision", manifests.revision, "reason", reason)
+	o.updateConditions(addon, metav1.Condition{
+		Type:    ConditionManifestSetSelected,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+
 	// Get settings from AddOnDeploymentConfig
 	config, err := addonfactory.GetAddOnDeploymentConfigValues(
 		addonfactory.NewAddOnDeloymentConfigGetter(o.addonClient),
@@ -108,11 +254,125 @@ func (o *olmAgent) Manifests(cluster *clusterv1.ManagedCluster,
 		} else {
 			klog.V(1).InfoS("No AddOnDeploymentConfig, using defaults", "cluster", cluster.GetName())
 		}
-		return objects, nil
+		config = nil
+	}
+	o.updateConditions(addon, namespacesCondition(config, o.namespaces))
+	deploy, condition := existingOLMCondition(cluster, addon, set, config)
+	o.updateConditions(addon, condition)
+	if !deploy {
+		klog.V(1).InfoS("OLM installed without the addon is not adopted, not deploying olm addon", "cluster",
+			cluster.GetName(), "reason", condition.Message)
+		o.reportInstall(addon, true, condition.Reason, condition.Message)
+		o.undeployedProbes.forget(cluster.Name)
+		return []runtime.Object{}, nil
+	}
+	release, err := pinnedRelease(addon)
+	if err != nil {
+		klog.ErrorS(err, "Ignoring the pinned OLM images", "cluster", cluster.GetName())
+	} else if release != nil {
+		config = pinRelease(config, *release)
+	}
+	// a rollback takes precedence over the images pinned by an OLMRollout
+	if rollback != nil {
+		config = pinRelease(config, rollback.OLMRelease)
+	}
+	klog.V(6).InfoS("configuration", "config", config, "renderingMode", o.renderingMode)
+
+	catalogs, err := o.getCatalogs(context.Background(), addon)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []runtime.Object
+	if o.renderingMode == TemplateRenderingMode {
+		objects, err = o.renderManifests(set, manifests.files[set.Name()], config)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		objects, err = o.configureManifests(set, manifests.files[set.Name()], config)
+		if err != nil {
+			return nil, fmt.Errorf("not able to configure the manifests: %w", err)
+		}
+	}
+	// the adopted OLM is left on the cluster when the addon is removed, unlike the CatalogSources configured for it
+	if condition.Reason == ReasonExistingOLMAdopted {
+		if err := setOrphanAnnotations(objects); err != nil {
+			return nil, err
+		}
+	}
+	objects, err = setCatalogs(objects, catalogs, o.namespaces.OLM)
+	if err != nil {
+		return nil, fmt.Errorf("not able to configure the catalogs: %w", err)
+	}
+	if err := setHealAnnotation(objects, addon); err != nil {
+		return nil, err
+	}
+	setManifestSetAnnotations(objects, o.namespaces.OLM, set.Name(), manifests.revision)
+	o.recordProbes(cluster.Name, objects)
+	o.reportInstall(addon, false, ReasonOLMDeployed, fmt.Sprintf("OLM %s of manifest set %s is deployed",
+		set.olmVersion, set.Name()))
+	return objects, nil
+}
+
+// configureManifests patches copies of the cached objects of the files of a manifest set with the configuration.
+func (o *olmAgent) configureManifests(set manifestSet, files []manifestFile,
+	config addonfactory.Values) ([]runtime.Object, error) {
+	// The cached objects are shared between clusters and must not be modified
+	objects := []runtime.Object{}
+	for _, file := range files {
+		for _, obj := range file.objects {
+			objects = append(objects, obj.DeepCopyObject())
+		}
 	}
-	klog.V(6).InfoS("configuration", "config", config)
 	for _, obj := range objects {
-		setConfiguration(obj, config)
+		if err := setConfiguration(obj, config); err != nil {
+			return nil, err
+		}
+	}
+	objects, err := setHAConfiguration(objects, config, o.namespaces.OLM, set.leaderElection)
+	if err != nil {
+		return nil, err
+	}
+	return setDefaultCatalogConfiguration(objects, config, o.namespaces.OLM)
+}
+
+// renderManifests renders the templates of the manifest set with the configuration merged into the default values.
+// Objects of files without template action are copied from the cache.
+// The namespaces are the same for all clusters and cannot be overridden by the configuration.
+func (o *olmAgent) renderManifests(set manifestSet, files []manifestFile,
+	config addonfactory.Values) ([]runtime.Object, error) {
+	defaults := defaultValues(set)
+	values := addonfactory.MergeValues(defaults, config)
+	// customized namespaces are reported by the CustomizedNamespacesIgnored condition
+	for _, name := range namespaceVariables {
+		values[name] = defaults[name]
+	}
+	if err := setResourceValues(values, defaults, config); err != nil {
+		return nil, err
+	}
+	if err := setPlacementValues(values, config); err != nil {
+		return nil, err
+	}
+	if err := setHAValues(values, config, set.leaderElection); err != nil {
+		return nil, err
+	}
+	if err := setDefaultCatalogValues(values, defaults, config); err != nil {
+		return nil, err
+	}
+	objects := []runtime.Object{}
+	for _, file := range files {
+		if file.template == nil {
+			for _, obj := range file.objects {
+				objects = append(objects, obj.DeepCopyObject())
+			}
+			continue
+		}
+		rendered, err := renderManifests(file.template, values)
+		if err != nil {
+			return nil, fmt.Errorf("not able to render %s: %w", file.name, err)
+		}
+		objects = append(objects, rendered...)
 	}
 	return objects, nil
 }
@@ -121,81 +381,103 @@ func (o *olmAgent) GetAgentAddonOptions() agentfw.AgentAddonOptions {
 	return agentfw.AgentAddonOptions{
 		AddonName: o.addonName,
 		// InstallStrategy is driven by placements handled by the addon-manager
-		// Check the status of the deployment of the olm-operator
-		// TODO: an agent would be required to surface more fine grained information
-		HealthProber: utils.NewDeploymentProber(
-			types.NamespacedName{
-				Name:      "olm-operator",
-				Namespace: "olm",
-			},
-		),
+		// Check the status of the OLM operators and of the packageserver,
+		// the HealthController reports them in distinct conditions
+		HealthProber: o.healthProber(),
 		SupportedConfigGVRs: []schema.GroupVersionResource{
 			addonfactory.AddOnDeploymentConfigGVR,
+			v1alpha1.OLMCatalogGVR,
 		},
 	}
 }
 
-// clusterSupportsAddonInstall filters cluster according to the vendor label.
-// OLM is part of the OpenShift distribution and should not be installed on these clusters.
-func clusterSupportsAddonInstall(cluster *clusterv1.ManagedCluster) bool {
-	vendor, ok := cluster.Labels["vendor"]
-	if !ok {
-		return true
-	} else {
-		return !strings.EqualFold(vendor, OpenShiftVendor)
+// loadManifestSet returns all the files of a manifest set.
+func loadManifestSet(manifests fs.FS, set manifestSet) ([]manifestFile, error) {
+	files := []manifestFile{}
+	// Keep the ordering defined in the file list and content
+	for _, name := range manifestFiles {
+		file, err := loadManifestFile(manifests, set, name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
 	}
+	return files, nil
 }
 
 // loadManifestsFromFile read files containing manifest lists and returns
 // a matching slice of runtime objects.
-func loadManifestsFromFile(file string, manifests embed.FS) ([]runtime.Object, error) {
-	objects := []runtime.Object{}
-	content, err := manifests.ReadFile(file)
+func loadManifestsFromFile(file string, manifests fs.FS) ([]runtime.Object, error) {
+	content, err := fs.ReadFile(manifests, file)
 	if err != nil {
 		return nil, err
 	}
+	return decodeManifests(file, content)
+}
+
+// decodeManifests streams the YAML documents of content and decodes each of them.
+// name identifies the content in error messages.
+func decodeManifests(name string, content []byte) ([]runtime.Object, error) {
+	objects := []runtime.Object{}
 	reader := yaml.NewYAMLReader(bufio.NewReaderSize(bytes.NewReader(content), 4096))
-	for {
+	for index := 1; ; index++ {
 		raw, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%s: document %d: %w", name, index, err)
 		}
-		chunk, err := toObjects(raw)
+		obj, err := decodeDocument(raw)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%s: document %d: %w", name, index, err)
+		}
+		if obj != nil {
+			objects = append(objects, obj)
 		}
-		objects = append(objects, chunk...)
 	}
 	return objects, nil
 }
 
-// toObjects takes raw yaml and returns a runtime object
-func toObjects(raw []byte) ([]runtime.Object, error) {
-	fileAsString := string(raw[:])
-	sepYamlfiles := strings.Split(fileAsString, "---")
-	results := make([]runtime.Object, 0, len(sepYamlfiles))
-	for _, f := range sepYamlfiles {
-		if f == "\n" || f == "" {
-			// ignore empty cases
-			continue
-		}
-		decode := scheme.Codecs.UniversalDeserializer().Decode
-		obj, _, err := decode(raw, nil, nil)
-		if err != nil {
-			return nil, err
-		}
-		results = append(results, obj)
+// decodeDocument decodes a single YAML document into a typed object
+// or into an unstructured one when its kind is not registered in the scheme.
+// nil is returned for documents without content.
+func decodeDocument(raw []byte) (runtime.Object, error) {
+	data, err := yaml.ToJSON(raw)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil, nil
+	}
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	if err == nil {
+		return obj, nil
+	}
+	if !runtime.IsNotRegisteredError(err) {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(data); err != nil {
+		return nil, err
 	}
-	return results, nil
+	return u, nil
 }
 
-// setConfiguration replaces the node selector, toleration and images in deployment manifests
-// with what has been configured.
-func setConfiguration(obj runtime.Object, config addonfactory.Values) {
+// setConfiguration replaces the node selector, tolerations, scheduling settings, images and image flags in deployment manifests
+// with what has been configured. Objects of types not registered in the scheme are
+// configured through the pod templates they contain.
+func setConfiguration(obj runtime.Object, config addonfactory.Values) error {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		setUnstructuredConfiguration(u, config)
+		return nil
+	}
+	placement, err := parsePodPlacement(config)
+	if err != nil {
+		return err
+	}
 	if deployment, ok := obj.(*appsv1.Deployment); ok {
+		setPodPlacement(deployment.Name, &deployment.Spec.Template.Spec, placement)
 		if nodeSelector, ok := config["NodeSelector"]; ok {
 			deployment.Spec.Template.Spec.NodeSelector = nodeSelector.(map[string]string)
 		}
@@ -206,14 +488,11 @@ func setConfiguration(obj runtime.Object, config addonfactory.Values) {
 			for i := range deployment.Spec.Template.Spec.Containers {
 				deployment.Spec.Template.Spec.Containers[i].Image = img.(string)
 			}
-			if deployment.Name == "catalog-operator" {
-				deployment.Spec.Template.Spec.Containers[0].Args[4] = img.(string)
-			}
 		}
-		if cmsImg, ok := config["ConfigMapServerImage"]; ok && deployment.Name == "catalog-operator" {
-			deployment.Spec.Template.Spec.Containers[0].Args[2] = "--configmapServerImage=" + cmsImg.(string)
+		if err := setContainerResources(deployment.Spec.Template.Spec.Containers, config); err != nil {
+			return err
 		}
-		return
+		return setContainerFlags(deployment.Name, deployment.Spec.Template.Spec.Containers, config)
 	}
 	if csv, ok := obj.(*olmv1alpha1.ClusterServiceVersion); ok {
 		if nodeSelector, ok := config["NodeSelector"]; ok {
@@ -233,5 +512,16 @@ func setConfiguration(obj runtime.Object, config addonfactory.Values) {
 				}
 			}
 		}
+		for i := range csv.Spec.InstallStrategy.StrategySpec.DeploymentSpecs {
+			deployment := &csv.Spec.InstallStrategy.StrategySpec.DeploymentSpecs[i]
+			setPodPlacement(deployment.Name, &deployment.Spec.Template.Spec, placement)
+			if err := setContainerResources(deployment.Spec.Template.Spec.Containers, config); err != nil {
+				return err
+			}
+			if err := setContainerFlags(deployment.Name, deployment.Spec.Template.Spec.Containers, config); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
 }
@@ -1,13 +1,25 @@
 package manager
 
 import (
+	"context"
 	"embed"
+	"io/fs"
+	"path"
+	"regexp"
 	"testing"
 
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/tools/record"
 	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	addonfake "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
 
 	"github.com/stretchr/testify/require"
 )
@@ -24,6 +36,327 @@ func TestLoadManifestsFromFile(t *testing.T) {
 	require.Equal(t, "ClusterRole", results[2].GetObjectKind().GroupVersionKind().Kind, "Expected ClusterRole, got: %s", results[2].GetObjectKind().GroupVersionKind().Kind)
 }
 
+func TestDecodeManifests(t *testing.T) {
+	require.NoError(t, addToScheme())
+	tests := []struct {
+		name          string
+		content       string
+		expectedKinds []string
+		expectedError string
+	}{
+		{
+			name:          "multiple documents",
+			content:       "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: olm\n---\napiVersion: v1\nkind: ServiceAccount\nmetadata:\n  name: olm\n",
+			expectedKinds: []string{"Namespace", "ServiceAccount"},
+		},
+		{
+			name:          "separator in a string value",
+			content:       "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\ndata:\n  description: \"first --- second\"\n",
+			expectedKinds: []string{"ConfigMap"},
+		},
+		{
+			name:          "empty documents and comments",
+			content:       "---\n# comment\n---\napiVersion: v1\nkind: Namespace\nmetadata:\n  name: olm\n---\n",
+			expectedKinds: []string{"Namespace"},
+		},
+		{
+			name:          "kind not registered in the scheme",
+			content:       "apiVersion: apiregistration.k8s.io/v1\nkind: APIService\nmetadata:\n  name: v1.packages.operators.coreos.com\n",
+			expectedKinds: []string{"APIService"},
+		},
+		{
+			name:          "invalid document",
+			content:       "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: olm\n---\nmetadata:\n  name: no-kind\n",
+			expectedError: "test.yaml: document 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := decodeManifests("test.yaml", []byte(tt.content))
+			if tt.expectedError != "" {
+				require.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			kinds := []string{}
+			for _, obj := range objects {
+				kinds = append(kinds, obj.GetObjectKind().GroupVersionKind().Kind)
+			}
+			require.Equal(t, tt.expectedKinds, kinds)
+		})
+	}
+}
+
+func TestLoadEmbeddedManifests(t *testing.T) {
+	require.NoError(t, addToScheme())
+	resolver, err := newVersionResolver(repoFS, RefusePolicy)
+	require.NoError(t, err)
+	matrix, err := loadCompatibilityMatrix(repoFS)
+	require.NoError(t, err)
+	require.NoError(t, matrix.apply(resolver, repoFS))
+	kindLine := regexp.MustCompile(`(?m)^kind: `)
+	for _, set := range resolver.sets {
+		for _, name := range manifestFiles {
+			set, name := set, name
+			t.Run(path.Join(set.dir, name), func(t *testing.T) {
+				content, err := fs.ReadFile(repoFS, path.Join(set.dir, name))
+				require.NoError(t, err)
+				file, err := loadManifestFile(repoFS, set, name)
+				require.NoError(t, err)
+				objects := file.objects
+				if file.template != nil {
+					// optional documents are only rendered in high-availability mode
+					values := defaultValues(set)
+					values["HAMode"] = true
+					objects, err = renderManifests(file.template, values)
+					require.NoError(t, err)
+				}
+				require.Equal(t, len(kindLine.FindAll(content, -1)), len(objects))
+				seen := map[string]bool{}
+				for _, obj := range file.objects {
+					_, isUnstructured := obj.(*unstructured.Unstructured)
+					require.False(t, isUnstructured, "%s is expected to be registered in the scheme",
+						obj.GetObjectKind().GroupVersionKind())
+					accessor, err := meta.Accessor(obj)
+					require.NoError(t, err)
+					key := obj.GetObjectKind().GroupVersionKind().Kind + "/" + accessor.GetNamespace() + "/" + accessor.GetName()
+					require.False(t, seen[key], "duplicated object %s", key)
+					seen[key] = true
+				}
+			})
+		}
+	}
+}
+
+// newTestAgent returns an agent serving the manifests of the repository in the rendering mode and
+// a ManagedClusterAddOn referencing an AddOnDeploymentConfig with the provided variables.
+func newTestAgent(t testing.TB, mode RenderingMode,
+	variables ...addonapiv1alpha1.CustomizedVariable) (*olmAgent, *addonapiv1alpha1.ManagedClusterAddOn) {
+	addon := &addonapiv1alpha1.ManagedClusterAddOn{
+		ObjectMeta: metav1.ObjectMeta{Name: "olm-addon", Namespace: "cluster1"},
+	}
+	objects := []runtime.Object{addon}
+	if len(variables) > 0 {
+		objects = append(objects, &addonapiv1alpha1.AddOnDeploymentConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "olm-config", Namespace: "cluster1"},
+			Spec:       addonapiv1alpha1.AddOnDeploymentConfigSpec{CustomizedVariables: variables},
+		})
+		addon.Status.ConfigReferences = []addonapiv1alpha1.ConfigReference{{
+			ConfigGroupResource: addonapiv1alpha1.ConfigGroupResource{
+				Group:    addonfactory.AddOnDeploymentConfigGVR.Group,
+				Resource: addonfactory.AddOnDeploymentConfigGVR.Resource,
+			},
+			ConfigReferent: addonapiv1alpha1.ConfigReferent{Name: "olm-config", Namespace: "cluster1"},
+		}}
+	}
+	agent, err := NewOLMAgent(addonfake.NewSimpleClientset(objects...), nil, "olm-addon",
+		&dirSource{dir: "../../manifests"}, NearestLowerPolicy, mode, DefaultNamespaces(), DefaultProbedCatalogSources, nil, nil)
+	require.NoError(t, err)
+	return &agent, addon
+}
+
+func newTestCluster(kubeVersion string) *clusterv1.ManagedCluster {
+	return &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster1"},
+		Status: clusterv1.ManagedClusterStatus{
+			Version: clusterv1.ManagedClusterVersion{Kubernetes: kubeVersion},
+		},
+	}
+}
+
+// deploymentImages returns the container images of the deployments per deployment name.
+func deploymentImages(objects []runtime.Object) map[string]string {
+	images := map[string]string{}
+	for _, obj := range objects {
+		if deployment, ok := obj.(*appsv1.Deployment); ok {
+			images[deployment.Name] = deployment.Spec.Template.Spec.Containers[0].Image
+		}
+	}
+	return images
+}
+
+func TestManifestSetSelectedCondition(t *testing.T) {
+	tests := []struct {
+		name            string
+		kubeVersion     string
+		policy          VersionPolicy
+		expectedStatus  metav1.ConditionStatus
+		expectedReason  string
+		expectedMessage string
+	}{
+		{
+			name:            "supported version",
+			kubeVersion:     "v1.27.3",
+			policy:          NearestLowerPolicy,
+			expectedStatus:  metav1.ConditionTrue,
+			expectedReason:  ReasonSupportedVersion,
+			expectedMessage: "manifest set v1.27 with OLM v0.25.0 selected for Kubernetes 1.27.3",
+		},
+		{
+			name:            "nearest lower",
+			kubeVersion:     "v1.29.1",
+			policy:          NearestLowerPolicy,
+			expectedStatus:  metav1.ConditionTrue,
+			expectedReason:  ReasonNearestLower,
+			expectedMessage: "manifest set v1.27 with OLM v0.25.0 selected for Kubernetes 1.29.1",
+		},
+		{
+			name:            "refused",
+			kubeVersion:     "v1.29.1",
+			policy:          RefusePolicy,
+			expectedStatus:  metav1.ConditionFalse,
+			expectedReason:  ReasonUnsupported,
+			expectedMessage: "no manifest set for Kubernetes v1.29, supported versions are: v1.23, v1.24, v1.25, v1.26, v1.27",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, addon := newTestAgent(t, PatchRenderingMode)
+			agent.catalog.get().resolver.policy = tt.policy
+			client := agent.addonClient.(*addonfake.Clientset)
+			objects, err := agent.Manifests(newTestCluster(tt.kubeVersion), addon)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedStatus == metav1.ConditionTrue, len(objects) > 0)
+			updated, err := client.AddonV1alpha1().ManagedClusterAddOns("cluster1").Get(context.Background(), "olm-addon",
+				metav1.GetOptions{})
+			require.NoError(t, err)
+			condition := meta.FindStatusCondition(updated.Status.Conditions, ConditionManifestSetSelected)
+			require.NotNil(t, condition)
+			require.Equal(t, tt.expectedStatus, condition.Status)
+			require.Equal(t, tt.expectedReason, condition.Reason)
+			require.Equal(t, tt.expectedMessage, condition.Message)
+
+			// The status is not updated again when the condition did not change
+			client.ClearActions()
+			_, err = agent.Manifests(newTestCluster(tt.kubeVersion), updated)
+			require.NoError(t, err)
+			for _, action := range client.Actions() {
+				require.NotEqual(t, "update", action.GetVerb(), "unexpected status update")
+			}
+		})
+	}
+}
+
+func TestManifestsInstallSkipped(t *testing.T) {
+	tests := []struct {
+		name           string
+		cluster        func() *clusterv1.ManagedCluster
+		policy         VersionPolicy
+		expectedStatus metav1.ConditionStatus
+		expectedReason string
+	}{
+		{
+			name:           "deployed",
+			cluster:        func() *clusterv1.ManagedCluster { return newTestCluster("v1.27.3") },
+			expectedStatus: metav1.ConditionFalse,
+			expectedReason: ReasonOLMDeployed,
+		},
+		{
+			name: "openshift",
+			cluster: func() *clusterv1.ManagedCluster {
+				cluster := newTestCluster("v1.26.5")
+				cluster.Labels = map[string]string{"vendor": "OpenShift"}
+				return cluster
+			},
+			expectedStatus: metav1.ConditionTrue,
+			expectedReason: ReasonVendorProvidesOLM,
+		},
+		{
+			name:           "unsupported version",
+			cluster:        func() *clusterv1.ManagedCluster { return newTestCluster("v1.29.1") },
+			policy:         RefusePolicy,
+			expectedStatus: metav1.ConditionTrue,
+			expectedReason: ReasonUnsupported,
+		},
+		{
+			name: "existing OLM",
+			cluster: func() *clusterv1.ManagedCluster {
+				cluster := newTestCluster("v1.27.3")
+				cluster.Status.ClusterClaims = []clusterv1.ManagedClusterClaim{{Name: ExistingOLMClaim, Value: "v0.24.0"}}
+				return cluster
+			},
+			expectedStatus: metav1.ConditionTrue,
+			expectedReason: ReasonExistingOLMRefused,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, addon := newTestAgent(t, PatchRenderingMode)
+			if tt.policy != "" {
+				agent.catalog.get().resolver.policy = tt.policy
+			}
+			recorder := record.NewFakeRecorder(10)
+			agent.recorder = recorder
+			objects, err := agent.Manifests(tt.cluster(), addon)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedStatus == metav1.ConditionFalse, len(objects) > 0)
+			updated, err := agent.addonClient.AddonV1alpha1().ManagedClusterAddOns("cluster1").Get(context.Background(),
+				"olm-addon", metav1.GetOptions{})
+			require.NoError(t, err)
+			condition := meta.FindStatusCondition(updated.Status.Conditions, ConditionInstallSkipped)
+			require.NotNil(t, condition)
+			require.Equal(t, tt.expectedStatus, condition.Status)
+			require.Equal(t, tt.expectedReason, condition.Reason)
+			if tt.expectedStatus == metav1.ConditionTrue {
+				require.Len(t, recorder.Events, 1)
+				require.Contains(t, <-recorder.Events, "Normal InstallSkipped OLM is not deployed on cluster cluster1 ("+
+					tt.expectedReason+")")
+			}
+
+			// The event is not recorded again when the installation is still skipped for the same reason
+			_, err = agent.Manifests(tt.cluster(), updated)
+			require.NoError(t, err)
+			require.Empty(t, recorder.Events)
+		})
+	}
+}
+
+func TestManifestsDoNotModifyCache(t *testing.T) {
+	agent, addon := newTestAgent(t, PatchRenderingMode, addonapiv1alpha1.CustomizedVariable{Name: "OLMImage", Value: testOLMImage})
+	cluster := newTestCluster("v1.27.3")
+	objects, err := agent.Manifests(cluster, addon)
+	require.NoError(t, err)
+	for name, image := range deploymentImages(objects) {
+		require.Equal(t, testOLMImage, image, "image of %s not configured", name)
+	}
+	for _, file := range agent.catalog.get().files["v1.27"] {
+		for name, image := range deploymentImages(file.objects) {
+			require.NotEqual(t, testOLMImage, image, "cached %s modified", name)
+		}
+	}
+}
+
+func BenchmarkManifests(b *testing.B) {
+	agent, addon := newTestAgent(b, PatchRenderingMode, addonapiv1alpha1.CustomizedVariable{Name: "OLMImage", Value: testOLMImage})
+	cluster := newTestCluster("v1.27.3")
+	// Use the addon with the condition already reported so that only the rendering gets measured
+	if _, err := agent.Manifests(cluster, addon); err != nil {
+		b.Fatal(err)
+	}
+	addon, err := agent.addonClient.AddonV1alpha1().ManagedClusterAddOns("cluster1").Get(context.Background(),
+		"olm-addon", metav1.GetOptions{})
+	require.NoError(b, err)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := agent.Manifests(cluster, addon); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkManifestsUncached measures parsing the manifest files on every call for comparison.
+func BenchmarkManifestsUncached(b *testing.B) {
+	agent, _ := newTestAgent(b, PatchRenderingMode)
+	set, _, err := agent.catalog.get().resolver.resolve(version.MustParseSemantic("v1.27.3"))
+	require.NoError(b, err)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := loadManifestSet(repoFS, set); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 const (
 	testOLMImage             = "quay.io/operator-framework/olm@sha256:111"
 	testConfigMapServerImage = "quay.io/operator-framework/configmap-operator-registry@sha256:222"
@@ -92,11 +425,11 @@ func TestSetConfiguration(t *testing.T) {
 			},
 		},
 	}
-	setConfiguration(&olmDepl, addonfactory.Values{
+	require.NoError(t, setConfiguration(&olmDepl, addonfactory.Values{
 		"OLMImage":             testOLMImage,
 		"ConfigMapServerImage": testConfigMapServerImage,
 		"NodeSelector":         map[string]string{testNodeSelectorKey: testNodeSelectorVal},
-	})
+	}))
 	require.Equal(t, olmDeplRes, olmDepl)
 
 	catDepl := appsv1.Deployment{
@@ -163,10 +496,10 @@ func TestSetConfiguration(t *testing.T) {
 			},
 		},
 	}
-	setConfiguration(&catDepl, addonfactory.Values{
+	require.NoError(t, setConfiguration(&catDepl, addonfactory.Values{
 		"OLMImage":             testOLMImage,
 		"ConfigMapServerImage": testConfigMapServerImage,
 		"NodeSelector":         map[string]string{testNodeSelectorKey: testNodeSelectorVal},
-	})
+	}))
 	require.Equal(t, catDeplRes, catDepl)
 }
@@ -0,0 +1,150 @@
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	olmv1 "github.com/operator-framework/api/pkg/operators/v1"
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+)
+
+const (
+	DefaultOLMNamespace       = "olm"
+	DefaultOperatorsNamespace = "operators"
+
+	// ConditionNamespacesIgnored reports that namespaces configured for the cluster
+	// with customized variables are not used.
+	ConditionNamespacesIgnored = "CustomizedNamespacesIgnored"
+
+	ReasonNamespacesNotCustomized         = "NamespacesNotCustomized"
+	ReasonPerClusterNamespacesUnsupported = "PerClusterNamespacesUnsupported"
+)
+
+// namespaceVariables are the customized variables of the namespaces, which cannot differ from the flags.
+var namespaceVariables = []string{"OLMNamespace", "OperatorsNamespace"}
+
+// Namespaces are the namespaces the OLM components get installed into on the managed clusters.
+// They are the same for all clusters as the health of the addon is probed in the OLM namespace.
+type Namespaces struct {
+	// OLM is the namespace of the OLM operators, the packageserver and the default catalog.
+	OLM string
+	// Operators is the namespace of the global operator group.
+	Operators string
+}
+
+// DefaultNamespaces returns the namespaces used by upstream OLM.
+func DefaultNamespaces() Namespaces {
+	return Namespaces{OLM: DefaultOLMNamespace, Operators: DefaultOperatorsNamespace}
+}
+
+// Validate checks that the namespaces are valid and distinct.
+func (n Namespaces) Validate() error {
+	for _, ns := range []string{n.OLM, n.Operators} {
+		if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace %q: %s", ns, strings.Join(errs, ", "))
+		}
+	}
+	if n.OLM == n.Operators {
+		return fmt.Errorf("the OLM and operators namespaces need to be different, both are %q", n.OLM)
+	}
+	return nil
+}
+
+// namespacesCondition reports the OLMNamespace and OperatorsNamespace customized variables differing
+// from the namespaces of the addon controller flags. They are rejected as the health of the addon is probed
+// in the same namespace on all the clusters.
+func namespacesCondition(config map[string]interface{}, namespaces Namespaces) metav1.Condition {
+	flags := map[string]string{"OLMNamespace": namespaces.OLM, "OperatorsNamespace": namespaces.Operators}
+	rejected := []string{}
+	for _, name := range namespaceVariables {
+		if value, ok := config[name]; ok && value != flags[name] {
+			rejected = append(rejected, fmt.Sprintf("%s=%v", name, value))
+		}
+	}
+	if len(rejected) > 0 {
+		return metav1.Condition{
+			Type:   ConditionNamespacesIgnored,
+			Status: metav1.ConditionTrue,
+			Reason: ReasonPerClusterNamespacesUnsupported,
+			Message: fmt.Sprintf("%s ignored, OLM is deployed into the namespaces %s and %s of the addon controller flags "+
+				"as they are the same for all the clusters", strings.Join(rejected, ", "), namespaces.OLM, namespaces.Operators),
+		}
+	}
+	return metav1.Condition{
+		Type:    ConditionNamespacesIgnored,
+		Status:  metav1.ConditionFalse,
+		Reason:  ReasonNamespacesNotCustomized,
+		Message: fmt.Sprintf("OLM is deployed into the namespaces %s and %s", namespaces.OLM, namespaces.Operators),
+	}
+}
+
+// checkNamespaces verifies that the objects of a manifest set have been rendered into the configured namespaces.
+// It detects manifest sets that hardcode the default namespaces when other namespaces are configured.
+func checkNamespaces(set manifestSet, files []manifestFile) error {
+	if set.namespaces == DefaultNamespaces() {
+		return nil
+	}
+	defaults := map[string]bool{DefaultOLMNamespace: true, DefaultOperatorsNamespace: true}
+	for _, file := range files {
+		for _, obj := range file.objects {
+			for _, ns := range referencedNamespaces(obj) {
+				if defaults[ns] && ns != set.namespaces.OLM && ns != set.namespaces.Operators {
+					accessor, _ := meta.Accessor(obj)
+					return fmt.Errorf("manifest set %s does not support custom namespaces: %s %s in %s references the namespace %s",
+						set.Name(), obj.GetObjectKind().GroupVersionKind().Kind, accessor.GetName(), file.name, ns)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// referencedNamespaces returns the namespaces an object is in or refers to:
+// binding subjects, operator group targets and namespace flags of the OLM containers.
+func referencedNamespaces(obj runtime.Object) []string {
+	namespaces := []string{}
+	if accessor, err := meta.Accessor(obj); err == nil && accessor.GetNamespace() != "" {
+		namespaces = append(namespaces, accessor.GetNamespace())
+	}
+	containerNamespaces := func(containers []corev1.Container) {
+		for _, container := range containers {
+			for _, flag := range []string{"--namespace", "--global-namespace"} {
+				if ns := argValue(append(container.Command, container.Args...), flag); ns != "" {
+					namespaces = append(namespaces, ns)
+				}
+			}
+		}
+	}
+	switch o := obj.(type) {
+	case *corev1.Namespace:
+		namespaces = append(namespaces, o.Name)
+	case *rbacv1.ClusterRoleBinding:
+		for _, subject := range o.Subjects {
+			namespaces = append(namespaces, subject.Namespace)
+		}
+	case *rbacv1.RoleBinding:
+		for _, subject := range o.Subjects {
+			namespaces = append(namespaces, subject.Namespace)
+		}
+	case *olmv1.OperatorGroup:
+		namespaces = append(namespaces, o.Spec.TargetNamespaces...)
+	case *appsv1.Deployment:
+		containerNamespaces(o.Spec.Template.Spec.Containers)
+	case *batchv1.Job:
+		containerNamespaces(o.Spec.Template.Spec.Containers)
+	case *olmv1alpha1.ClusterServiceVersion:
+		for _, deployment := range o.Spec.InstallStrategy.StrategySpec.DeploymentSpecs {
+			containerNamespaces(deployment.Spec.Template.Spec.Containers)
+		}
+	}
+	return namespaces
+}
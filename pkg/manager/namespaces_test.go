@@ -0,0 +1,98 @@
+package manager
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	addonfake "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespacesValidate(t *testing.T) {
+	require.NoError(t, DefaultNamespaces().Validate())
+	require.NoError(t, Namespaces{OLM: "olm-system", Operators: "olm-operators"}.Validate())
+	require.Error(t, Namespaces{OLM: "OLM", Operators: "operators"}.Validate())
+	require.Error(t, Namespaces{OLM: "olm", Operators: ""}.Validate())
+	require.Error(t, Namespaces{OLM: "olm", Operators: "olm"}.Validate())
+}
+
+func TestCustomNamespaces(t *testing.T) {
+	namespaces := Namespaces{OLM: "olm-system", Operators: "olm-operators"}
+	for _, mode := range []RenderingMode{PatchRenderingMode, TemplateRenderingMode} {
+		t.Run(string(mode), func(t *testing.T) {
+			_, addon := newTestAgent(t, mode)
+			agent, err := NewOLMAgent(addonfake.NewSimpleClientset(addon), nil, "olm-addon",
+				&dirSource{dir: "../../manifests"}, NearestLowerPolicy, mode, namespaces, DefaultProbedCatalogSources, nil, nil)
+			require.NoError(t, err)
+			prober := agent.GetAgentAddonOptions().HealthProber
+			for _, field := range prober.WorkProber.ProbeFields {
+				require.Equal(t, "olm-system", field.ResourceIdentifier.Namespace)
+			}
+			for _, kubeVersion := range []string{"v1.23.0", "v1.24.0", "v1.25.0", "v1.26.0", "v1.27.0"} {
+				objects, err := agent.Manifests(newTestCluster(kubeVersion), addon)
+				require.NoError(t, err)
+				catalogOperator := false
+				for _, obj := range objects {
+					for _, ns := range referencedNamespaces(obj) {
+						// the olm-operator gets its namespace from the downward API
+						require.Contains(t, []string{"olm-system", "olm-operators", "open-cluster-management-agent",
+							"$(OPERATOR_NAMESPACE)"}, ns,
+							"namespace of %s in %s", obj.GetObjectKind().GroupVersionKind().Kind, kubeVersion)
+					}
+					if deployment, ok := obj.(*appsv1.Deployment); ok && deployment.Name == "catalog-operator" {
+						require.Equal(t, "olm-system", argValue(deployment.Spec.Template.Spec.Containers[0].Args, "--namespace"))
+						catalogOperator = true
+					}
+				}
+				require.True(t, catalogOperator)
+			}
+		})
+	}
+}
+
+func TestCustomizedNamespaceIgnored(t *testing.T) {
+	for _, mode := range []RenderingMode{PatchRenderingMode, TemplateRenderingMode} {
+		t.Run(string(mode), func(t *testing.T) {
+			agent, addon := newTestAgent(t, mode,
+				addonapiv1alpha1.CustomizedVariable{Name: "OLMNamespace", Value: "other"},
+				addonapiv1alpha1.CustomizedVariable{Name: "OperatorsNamespace", Value: DefaultOperatorsNamespace})
+			objects, err := agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.NoError(t, err)
+			for _, obj := range objects {
+				require.NotContains(t, referencedNamespaces(obj), "other")
+			}
+			updated, err := agent.addonClient.AddonV1alpha1().ManagedClusterAddOns("cluster1").Get(context.Background(),
+				"olm-addon", metav1.GetOptions{})
+			require.NoError(t, err)
+			condition := meta.FindStatusCondition(updated.Status.Conditions, ConditionNamespacesIgnored)
+			require.NotNil(t, condition)
+			require.Equal(t, metav1.ConditionTrue, condition.Status)
+			require.Equal(t, ReasonPerClusterNamespacesUnsupported, condition.Reason)
+			require.Equal(t, "OLMNamespace=other ignored, OLM is deployed into the namespaces olm and operators of the "+
+				"addon controller flags as they are the same for all the clusters", condition.Message)
+		})
+	}
+
+	condition := namespacesCondition(map[string]interface{}{}, DefaultNamespaces())
+	require.Equal(t, metav1.ConditionFalse, condition.Status)
+	require.Equal(t, ReasonNamespacesNotCustomized, condition.Reason)
+}
+
+func TestCheckNamespacesRejectsHardcodedNamespaces(t *testing.T) {
+	require.NoError(t, addToScheme())
+	manifests := fstest.MapFS{
+		"v1.27/olm.yaml": {Data: []byte("apiVersion: v1\nkind: ServiceAccount\nmetadata:\n  name: olm\n  namespace: olm\n")},
+	}
+	set := manifestSet{dir: "v1.27", namespaces: DefaultNamespaces()}
+	file, err := loadManifestFile(manifests, set, "olm.yaml")
+	require.NoError(t, err)
+	require.NoError(t, checkNamespaces(set, []manifestFile{file}))
+	set.namespaces = Namespaces{OLM: "olm-system", Operators: "operators"}
+	require.ErrorContains(t, checkNamespaces(set, []manifestFile{file}), "does not support custom namespaces")
+}
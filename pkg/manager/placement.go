@@ -0,0 +1,126 @@
+package manager
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+)
+
+// podPlacement contains the scheduling settings applied to the pods of all the OLM components
+// in addition to the node placement of the AddOnDeploymentConfig.
+type podPlacement struct {
+	affinity                  *corev1.Affinity
+	topologySpreadConstraints []corev1.TopologySpreadConstraint
+	priorityClassName         string
+}
+
+// parsePodPlacement reads the scheduling settings from the customized variables:
+//   - Affinity: YAML or JSON of a pod affinity
+//   - TopologySpreadConstraints: YAML or JSON list of topology spread constraints
+//   - PriorityClassName: name of the priority class
+func parsePodPlacement(config addonfactory.Values) (podPlacement, error) {
+	placement := podPlacement{}
+	if value, ok := config["Affinity"].(string); ok {
+		placement.affinity = &corev1.Affinity{}
+		if err := yaml.UnmarshalStrict([]byte(value), placement.affinity); err != nil {
+			return placement, fmt.Errorf("invalid Affinity: %w", err)
+		}
+	}
+	if value, ok := config["TopologySpreadConstraints"].(string); ok {
+		if err := yaml.UnmarshalStrict([]byte(value), &placement.topologySpreadConstraints); err != nil {
+			return placement, fmt.Errorf("invalid TopologySpreadConstraints: %w", err)
+		}
+		for _, constraint := range placement.topologySpreadConstraints {
+			if constraint.TopologyKey == "" || constraint.MaxSkew < 1 || constraint.WhenUnsatisfiable == "" {
+				return placement, fmt.Errorf("invalid TopologySpreadConstraints: topologyKey, maxSkew and whenUnsatisfiable are required")
+			}
+		}
+	}
+	if value, ok := config["PriorityClassName"].(string); ok {
+		placement.priorityClassName = value
+	}
+	return placement, nil
+}
+
+// forComponent returns the affinity and topology spread constraints of a component.
+// Pod affinity terms and constraints without label selector get one matching the pods of the component,
+// so that a single setting spreads the replicas of each component.
+func (p podPlacement) forComponent(component string) (*corev1.Affinity, []corev1.TopologySpreadConstraint) {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": component}}
+	affinity := p.affinity.DeepCopy()
+	if affinity != nil {
+		if affinity.PodAffinity != nil {
+			defaultAffinityTerms(affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution,
+				affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution, selector)
+		}
+		if affinity.PodAntiAffinity != nil {
+			defaultAffinityTerms(affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution,
+				affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution, selector)
+		}
+	}
+	var constraints []corev1.TopologySpreadConstraint
+	for _, constraint := range p.topologySpreadConstraints {
+		constraint := *constraint.DeepCopy()
+		if constraint.LabelSelector == nil {
+			constraint.LabelSelector = selector.DeepCopy()
+		}
+		constraints = append(constraints, constraint)
+	}
+	return affinity, constraints
+}
+
+func defaultAffinityTerms(required []corev1.PodAffinityTerm, preferred []corev1.WeightedPodAffinityTerm,
+	selector *metav1.LabelSelector) {
+	for i := range required {
+		if required[i].LabelSelector == nil {
+			required[i].LabelSelector = selector.DeepCopy()
+		}
+	}
+	for i := range preferred {
+		if preferred[i].PodAffinityTerm.LabelSelector == nil {
+			preferred[i].PodAffinityTerm.LabelSelector = selector.DeepCopy()
+		}
+	}
+}
+
+// setPodPlacement applies the scheduling settings to the pod spec of a component.
+// Settings that are not configured are left untouched.
+func setPodPlacement(component string, spec *corev1.PodSpec, placement podPlacement) {
+	affinity, constraints := placement.forComponent(component)
+	if affinity != nil {
+		spec.Affinity = affinity
+	}
+	if constraints != nil {
+		spec.TopologySpreadConstraints = constraints
+	}
+	if placement.priorityClassName != "" {
+		spec.PriorityClassName = placement.priorityClassName
+	}
+}
+
+// affinityValueName and topologySpreadValueName return the names of the template values
+// with the scheduling settings of a component, e.g. OLMOperatorAffinity.
+func affinityValueName(component string) string {
+	return componentVariablePrefixes[component] + "Affinity"
+}
+
+func topologySpreadValueName(component string) string {
+	return componentVariablePrefixes[component] + "TopologySpreadConstraints"
+}
+
+// setPlacementValues sets the scheduling settings of the OLM components in the template values.
+func setPlacementValues(values addonfactory.Values, config addonfactory.Values) error {
+	placement, err := parsePodPlacement(config)
+	if err != nil {
+		return err
+	}
+	for component := range componentVariablePrefixes {
+		values[affinityValueName(component)], values[topologySpreadValueName(component)] = placement.forComponent(component)
+	}
+	values["PriorityClassName"] = placement.priorityClassName
+	return nil
+}
@@ -0,0 +1,148 @@
+package manager
+
+import (
+	"testing"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testAntiAffinity = `
+podAntiAffinity:
+  preferredDuringSchedulingIgnoredDuringExecution:
+  - weight: 100
+    podAffinityTerm:
+      topologyKey: kubernetes.io/hostname
+`
+
+const testTopologySpreadConstraints = `
+- maxSkew: 1
+  topologyKey: topology.kubernetes.io/zone
+  whenUnsatisfiable: ScheduleAnyway
+- maxSkew: 1
+  topologyKey: kubernetes.io/hostname
+  whenUnsatisfiable: DoNotSchedule
+  labelSelector:
+    matchLabels:
+      tier: control-plane
+`
+
+func TestParsePodPlacement(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        addonfactory.Values
+		expectedError string
+	}{
+		{
+			name:   "not configured",
+			config: addonfactory.Values{},
+		},
+		{
+			name: "valid settings",
+			config: addonfactory.Values{
+				"Affinity":                  testAntiAffinity,
+				"TopologySpreadConstraints": testTopologySpreadConstraints,
+				"PriorityClassName":         "system-cluster-critical",
+			},
+		},
+		{
+			name:          "unknown affinity field",
+			config:        addonfactory.Values{"Affinity": "podAntiAffinity:\n  preferred: []\n"},
+			expectedError: "invalid Affinity",
+		},
+		{
+			name:          "constraint without topology key",
+			config:        addonfactory.Values{"TopologySpreadConstraints": "[{\"maxSkew\": 1, \"whenUnsatisfiable\": \"DoNotSchedule\"}]"},
+			expectedError: "topologyKey, maxSkew and whenUnsatisfiable are required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePodPlacement(tt.config)
+			if tt.expectedError != "" {
+				require.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestPodPlacementForComponent(t *testing.T) {
+	placement, err := parsePodPlacement(addonfactory.Values{
+		"Affinity":                  testAntiAffinity,
+		"TopologySpreadConstraints": testTopologySpreadConstraints,
+	})
+	require.NoError(t, err)
+
+	affinity, constraints := placement.forComponent("catalog-operator")
+	componentSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "catalog-operator"}}
+	require.Equal(t, componentSelector,
+		affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].PodAffinityTerm.LabelSelector)
+	require.Equal(t, componentSelector, constraints[0].LabelSelector)
+	require.Equal(t, map[string]string{"tier": "control-plane"}, constraints[1].LabelSelector.MatchLabels)
+
+	// the parsed settings are left untouched for the other components
+	require.Nil(t, placement.affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].PodAffinityTerm.LabelSelector)
+	require.Nil(t, placement.topologySpreadConstraints[0].LabelSelector)
+}
+
+// componentPodSpecs returns the pod specs of the OLM components per deployment name.
+func componentPodSpecs(objects []runtime.Object) map[string]corev1.PodSpec {
+	specs := map[string]corev1.PodSpec{}
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *appsv1.Deployment:
+			specs[o.Name] = o.Spec.Template.Spec
+		case *olmv1alpha1.ClusterServiceVersion:
+			for _, deployment := range o.Spec.InstallStrategy.StrategySpec.DeploymentSpecs {
+				specs[deployment.Name] = deployment.Spec.Template.Spec
+			}
+		}
+	}
+	return specs
+}
+
+func TestPodPlacementConfiguration(t *testing.T) {
+	for _, mode := range []RenderingMode{PatchRenderingMode, TemplateRenderingMode} {
+		t.Run(string(mode), func(t *testing.T) {
+			agent, addon := newTestAgent(t, mode,
+				addonapiv1alpha1.CustomizedVariable{Name: "Affinity", Value: testAntiAffinity},
+				addonapiv1alpha1.CustomizedVariable{Name: "TopologySpreadConstraints", Value: testTopologySpreadConstraints},
+				addonapiv1alpha1.CustomizedVariable{Name: "PriorityClassName", Value: "system-cluster-critical"},
+			)
+			objects, err := agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.NoError(t, err)
+			specs := componentPodSpecs(objects)
+			require.Len(t, specs, len(componentVariablePrefixes))
+			placement, err := parsePodPlacement(addonfactory.Values{
+				"Affinity":                  testAntiAffinity,
+				"TopologySpreadConstraints": testTopologySpreadConstraints,
+			})
+			require.NoError(t, err)
+			for component, spec := range specs {
+				affinity, constraints := placement.forComponent(component)
+				require.Equal(t, affinity, spec.Affinity, component)
+				require.Equal(t, constraints, spec.TopologySpreadConstraints, component)
+				require.Equal(t, "system-cluster-critical", spec.PriorityClassName, component)
+			}
+			for _, file := range agent.catalog.get().files["v1.27"] {
+				for component, spec := range componentPodSpecs(file.objects) {
+					require.Nil(t, spec.Affinity, "cached %s modified", component)
+				}
+			}
+
+			agent, addon = newTestAgent(t, mode,
+				addonapiv1alpha1.CustomizedVariable{Name: "TopologySpreadConstraints", Value: "maxSkew: 1"})
+			_, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.ErrorContains(t, err, "invalid TopologySpreadConstraints")
+		})
+	}
+}
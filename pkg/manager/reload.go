@@ -0,0 +1,185 @@
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ManifestsRevisionAnnotation holds the revision of the manifests last served to a cluster on its ManagedClusterAddOn.
+	// Its change makes the addon framework render the manifests of the cluster again.
+	// The OLM deployments carry the revision of the manifests they are rendered from.
+	ManifestsRevisionAnnotation = "olm.addon.stolostron.io/manifests-revision"
+
+	// manifestsHistory is the number of previous revisions of the manifests kept for rollbacks.
+	manifestsHistory = 3
+)
+
+// loadedManifests are the parsed manifest sets of a revision of the manifest source.
+type loadedManifests struct {
+	resolver *versionResolver
+	// files caches the parsed files per manifest set. They are only read once loaded,
+	// objects are deep-copied before being handed out.
+	files map[string][]manifestFile
+	// revision is the sha256 digest of the content of the manifest source
+	revision string
+}
+
+// manifestCatalog holds the manifests currently served, they get replaced as a whole when reloaded.
+type manifestCatalog struct {
+	mu     sync.RWMutex
+	loaded loadedManifests
+	// previous are the last revisions replaced by a reload, the most recent first
+	previous []loadedManifests
+	// requeued is the revision of the manifests all the ManagedClusterAddOns have been requeued for
+	requeued string
+}
+
+func (c *manifestCatalog) get() loadedManifests {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.loaded
+}
+
+func (c *manifestCatalog) set(loaded loadedManifests) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loaded.resolver != nil && c.loaded.revision != loaded.revision {
+		c.previous = append([]loadedManifests{c.loaded}, c.previous...)
+		if len(c.previous) > manifestsHistory {
+			c.previous = c.previous[:manifestsHistory]
+		}
+	}
+	c.loaded = loaded
+}
+
+// lookup returns a manifest set of a revision of the manifests. The set of the manifests currently served
+// is returned when the revision is no longer kept.
+func (c *manifestCatalog) lookup(name, revision string) (loadedManifests, manifestSet, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, loaded := range append([]loadedManifests{c.loaded}, c.previous...) {
+		if loaded.revision != revision {
+			continue
+		}
+		if set, ok := loaded.resolver.get(name); ok {
+			return loaded, set, true
+		}
+	}
+	set, ok := c.loaded.resolver.get(name)
+	return c.loaded, set, ok
+}
+
+func (c *manifestCatalog) requeuedRevision() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.requeued
+}
+
+func (c *manifestCatalog) setRequeued(revision string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requeued = revision
+}
+
+// WatchManifests reloads the manifests whenever the manifest source changes until the context is done.
+// Nothing is watched for sources which cannot change, e.g. the embedded manifests.
+func (o *olmAgent) WatchManifests(ctx context.Context) {
+	watcher, ok := o.source.(ManifestWatcher)
+	if !ok {
+		return
+	}
+	watcher.Watch(ctx, func() {
+		if err := o.reloadManifests(ctx); err != nil {
+			klog.ErrorS(err, "Not able to reload the manifests, serving the previous ones", "revision",
+				o.catalog.get().revision)
+		}
+	})
+}
+
+// reloadManifests retrieves the manifests from the source and serves them when their revision changed.
+// The manifests are validated as at start and the previous ones are kept when they are not valid.
+func (o *olmAgent) reloadManifests(ctx context.Context) error {
+	olmManifests, err := o.source.Manifests(ctx)
+	if err != nil {
+		return fmt.Errorf("not able to retrieve the manifests: %w", err)
+	}
+	revision, err := manifestsRevision(olmManifests)
+	if err != nil {
+		return err
+	}
+	if revision != o.catalog.get().revision {
+		loaded, err := o.loadManifests(olmManifests)
+		if err != nil {
+			return err
+		}
+		o.catalog.set(loaded)
+		klog.InfoS("Manifests reloaded", "revision", loaded.revision)
+	}
+	// requeuing the addons is retried on the next check of the source when it failed
+	if revision == o.catalog.requeuedRevision() {
+		return nil
+	}
+	if err := o.requeueAddons(ctx, revision); err != nil {
+		return err
+	}
+	o.catalog.setRequeued(revision)
+	return nil
+}
+
+// requeueAddons sets the revision of the manifests on the ManagedClusterAddOns,
+// the addon framework renders their manifests again when they get updated.
+func (o *olmAgent) requeueAddons(ctx context.Context, revision string) error {
+	addons, err := o.addonClient.AddonV1alpha1().ManagedClusterAddOns(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	errs := []error{}
+	for i := range addons.Items {
+		addon := &addons.Items[i]
+		if addon.Name != o.addonName || addon.Annotations[ManifestsRevisionAnnotation] == revision {
+			continue
+		}
+		updated := addon.DeepCopy()
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[ManifestsRevisionAnnotation] = revision
+		if _, err := o.addonClient.AddonV1alpha1().ManagedClusterAddOns(addon.Namespace).Update(ctx, updated,
+			metav1.UpdateOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", addon.Namespace, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// manifestsRevision returns the sha256 digest of the paths and content of the files of the manifest source.
+func manifestsRevision(manifests fs.FS) (string, error) {
+	hash := sha256.New()
+	err := fs.WalkDir(manifests, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(manifests, path)
+		if err != nil {
+			return err
+		}
+		// lengths prevent different files from producing the same stream
+		fmt.Fprintf(hash, "%d:%s%d:", len(path), path, len(content))
+		hash.Write(content)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
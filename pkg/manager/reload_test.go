@@ -0,0 +1,150 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	addonfake "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
+
+	"github.com/stretchr/testify/require"
+)
+
+// repositoryManifests returns the files of the manifests directory of the repository.
+func repositoryManifests(t *testing.T) map[string]string {
+	files := map[string]string{}
+	manifests := os.DirFS("../../manifests")
+	require.NoError(t, fs.WalkDir(manifests, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(manifests, path)
+		files[path] = string(content)
+		return err
+	}))
+	return files
+}
+
+func TestReloadManifests(t *testing.T) {
+	files := repositoryManifests(t)
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "olm-manifests", Namespace: "open-cluster-management"},
+		BinaryData: map[string][]byte{configMapArchiveKey: testArchive(t, files)},
+	}
+	kubeClient := kubefake.NewSimpleClientset(configMap)
+	source, err := ParseManifestSource("configmap:open-cluster-management/olm-manifests", ManifestSourceOptions{
+		KubeClient: kubeClient,
+		CacheDir:   t.TempDir(),
+	})
+	require.NoError(t, err)
+	addon := &addonapiv1alpha1.ManagedClusterAddOn{ObjectMeta: metav1.ObjectMeta{Name: "olm-addon", Namespace: "cluster1"}}
+	addonClient := addonfake.NewSimpleClientset(addon)
+	agent, err := NewOLMAgent(addonClient, nil, "olm-addon", source, NearestLowerPolicy, PatchRenderingMode,
+		DefaultNamespaces(), DefaultProbedCatalogSources, nil, nil)
+	require.NoError(t, err)
+	revision := agent.catalog.get().revision
+
+	// unchanged manifests are not reloaded and the addons are not requeued
+	require.NoError(t, agent.reloadManifests(context.Background()))
+	require.Equal(t, revision, agent.catalog.get().revision)
+	require.Empty(t, addonClient.Actions())
+
+	files["v1.27/olm.yaml"] += "\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: reloaded\n  namespace: olm\n"
+	configMap.BinaryData[configMapArchiveKey] = testArchive(t, files)
+	_, err = kubeClient.CoreV1().ConfigMaps(configMap.Namespace).Update(context.Background(), configMap,
+		metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, agent.reloadManifests(context.Background()))
+	reloaded := agent.catalog.get().revision
+	require.NotEqual(t, revision, reloaded)
+	updated, err := addonClient.AddonV1alpha1().ManagedClusterAddOns("cluster1").Get(context.Background(), "olm-addon",
+		metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, reloaded, updated.Annotations[ManifestsRevisionAnnotation])
+	objects, err := agent.Manifests(newTestCluster("v1.27.3"), updated)
+	require.NoError(t, err)
+	found := false
+	for _, obj := range objects {
+		if cm, ok := obj.(*corev1.ConfigMap); ok && cm.Name == "reloaded" {
+			found = true
+		}
+	}
+	require.True(t, found, "the reloaded manifests are served")
+
+	// invalid manifests are not served
+	files["compatibility.yaml"] = "version: v0\n"
+	configMap.BinaryData[configMapArchiveKey] = testArchive(t, files)
+	_, err = kubeClient.CoreV1().ConfigMaps(configMap.Namespace).Update(context.Background(), configMap,
+		metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.Error(t, agent.reloadManifests(context.Background()))
+	require.Equal(t, reloaded, agent.catalog.get().revision)
+}
+
+func TestConfigMapSourceWatch(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "olm-manifests", Namespace: "open-cluster-management"},
+		BinaryData: map[string][]byte{configMapArchiveKey: testArchive(t, testArchiveFiles)},
+	}
+	kubeClient := kubefake.NewSimpleClientset(configMap)
+	source, err := ParseManifestSource("configmap:open-cluster-management/olm-manifests", ManifestSourceOptions{
+		KubeClient: kubeClient,
+		CacheDir:   t.TempDir(),
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan struct{}, 10)
+	go source.(ManifestWatcher).Watch(ctx, func() { changes <- struct{}{} })
+	select {
+	case <-changes:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the existing ConfigMap has not been reported")
+	}
+	configMap.Labels = map[string]string{"updated": "true"}
+	_, err = kubeClient.CoreV1().ConfigMaps(configMap.Namespace).Update(context.Background(), configMap,
+		metav1.UpdateOptions{})
+	require.NoError(t, err)
+	select {
+	case <-changes:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the update of the ConfigMap has not been reported")
+	}
+}
+
+func TestOCISourceWatchDigest(t *testing.T) {
+	source, err := ParseManifestSource("oci:registry.example.com/olm/manifests@sha256:"+
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		ManifestSourceOptions{RefreshInterval: time.Millisecond})
+	require.NoError(t, err)
+	called := false
+	// returns immediately as the content of a digest never changes
+	source.(ManifestWatcher).Watch(context.Background(), func() { called = true })
+	require.False(t, called)
+}
+
+func TestManifestCatalogLookup(t *testing.T) {
+	agent, _ := newTestAgent(t, PatchRenderingMode)
+	current := agent.catalog.get()
+	catalog := &manifestCatalog{}
+	for i := 0; i <= manifestsHistory+1; i++ {
+		catalog.set(loadedManifests{resolver: current.resolver, files: current.files, revision: fmt.Sprintf("r%d", i)})
+	}
+	loaded, set, ok := catalog.lookup("v1.27", "r1")
+	require.True(t, ok)
+	require.Equal(t, "r1", loaded.revision)
+	require.Equal(t, "v1.27", set.Name())
+	// the oldest revisions are not kept, the manifests currently served are used instead
+	loaded, _, ok = catalog.lookup("v1.27", "r0")
+	require.True(t, ok)
+	require.Equal(t, fmt.Sprintf("r%d", manifestsHistory+1), loaded.revision)
+	_, _, ok = catalog.lookup("v0.1", "r1")
+	require.False(t, ok)
+}
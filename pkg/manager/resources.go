@@ -0,0 +1,114 @@
+package manager
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+)
+
+// componentVariablePrefixes maps the containers of the OLM components to the prefix
+// of the customized variables used for configuring them, e.g. OLMOperatorCPURequest.
+var componentVariablePrefixes = map[string]string{
+	"olm-operator":     "OLMOperator",
+	"catalog-operator": "CatalogOperator",
+	"packageserver":    "PackageServer",
+}
+
+// defaultResources are the resources of the OLM components in the upstream manifests.
+var defaultResources = map[string]corev1.ResourceRequirements{
+	"olm-operator": {Requests: corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("10m"),
+		corev1.ResourceMemory: resource.MustParse("160Mi"),
+	}},
+	"catalog-operator": {Requests: corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("10m"),
+		corev1.ResourceMemory: resource.MustParse("80Mi"),
+	}},
+	"packageserver": {Requests: corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("10m"),
+		corev1.ResourceMemory: resource.MustParse("50Mi"),
+	}},
+}
+
+// resourceVariableSuffixes maps the suffix of the customized variables to the resources they configure.
+var resourceVariableSuffixes = []struct {
+	suffix   string
+	resource corev1.ResourceName
+	limit    bool
+}{
+	{"CPURequest", corev1.ResourceCPU, false},
+	{"MemoryRequest", corev1.ResourceMemory, false},
+	{"CPULimit", corev1.ResourceCPU, true},
+	{"MemoryLimit", corev1.ResourceMemory, true},
+}
+
+// resourcesValueName returns the name of the template value containing the resources of a component,
+// e.g. OLMOperatorResources.
+func resourcesValueName(component string) string {
+	return componentVariablePrefixes[component] + "Resources"
+}
+
+// configuredResources returns the resources of a component with the requests and limits
+// of the customized variables overriding the ones of base.
+func configuredResources(component string, base corev1.ResourceRequirements,
+	config addonfactory.Values) (corev1.ResourceRequirements, error) {
+	result := *base.DeepCopy()
+	for _, v := range resourceVariableSuffixes {
+		name := componentVariablePrefixes[component] + v.suffix
+		value, ok := config[name].(string)
+		if !ok {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return result, fmt.Errorf("invalid value %q for %s: %w", value, name, err)
+		}
+		list := &result.Requests
+		if v.limit {
+			list = &result.Limits
+		}
+		if *list == nil {
+			*list = corev1.ResourceList{}
+		}
+		(*list)[v.resource] = quantity
+	}
+	for name, request := range result.Requests {
+		if limit, ok := result.Limits[name]; ok && request.Cmp(limit) > 0 {
+			return result, fmt.Errorf("%s request %s of %s is greater than its limit %s", name, request.String(),
+				component, limit.String())
+		}
+	}
+	return result, nil
+}
+
+// setContainerResources configures the requests and limits of the containers of OLM components.
+func setContainerResources(containers []corev1.Container, config addonfactory.Values) error {
+	for i := range containers {
+		if _, ok := componentVariablePrefixes[containers[i].Name]; !ok {
+			continue
+		}
+		resources, err := configuredResources(containers[i].Name, containers[i].Resources, config)
+		if err != nil {
+			return err
+		}
+		containers[i].Resources = resources
+	}
+	return nil
+}
+
+// setResourceValues sets the resources of the OLM components in the template values
+// from the default values and the customized variables.
+func setResourceValues(values, defaults, config addonfactory.Values) error {
+	for component := range componentVariablePrefixes {
+		base, _ := defaults[resourcesValueName(component)].(corev1.ResourceRequirements)
+		resources, err := configuredResources(component, base, config)
+		if err != nil {
+			return err
+		}
+		values[resourcesValueName(component)] = resources
+	}
+	return nil
+}
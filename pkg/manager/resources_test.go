@@ -0,0 +1,121 @@
+package manager
+
+import (
+	"testing"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfiguredResources(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        addonfactory.Values
+		expected      map[string]string
+		expectedError string
+	}{
+		{
+			name:     "defaults",
+			config:   addonfactory.Values{},
+			expected: map[string]string{"requests.cpu": "10m", "requests.memory": "80Mi"},
+		},
+		{
+			name: "requests and limits",
+			config: addonfactory.Values{
+				"CatalogOperatorCPURequest":  "100m",
+				"CatalogOperatorMemoryLimit": "1Gi",
+				"OLMOperatorCPULimit":        "1",
+			},
+			expected: map[string]string{"requests.cpu": "100m", "requests.memory": "80Mi", "limits.memory": "1Gi"},
+		},
+		{
+			name:          "invalid quantity",
+			config:        addonfactory.Values{"CatalogOperatorMemoryRequest": "lots"},
+			expectedError: "invalid value \"lots\" for CatalogOperatorMemoryRequest",
+		},
+		{
+			name:          "request greater than limit",
+			config:        addonfactory.Values{"CatalogOperatorCPULimit": "5m"},
+			expectedError: "cpu request 10m of catalog-operator is greater than its limit 5m",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resources, err := configuredResources("catalog-operator", defaultResources["catalog-operator"], tt.config)
+			if tt.expectedError != "" {
+				require.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, flattenResources(resources))
+		})
+	}
+	require.Nil(t, defaultResources["catalog-operator"].Limits, "defaults modified")
+}
+
+func flattenResources(resources corev1.ResourceRequirements) map[string]string {
+	flattened := map[string]string{}
+	for name, quantity := range resources.Requests {
+		flattened["requests."+string(name)] = quantity.String()
+	}
+	for name, quantity := range resources.Limits {
+		flattened["limits."+string(name)] = quantity.String()
+	}
+	return flattened
+}
+
+// componentResources returns the resources of the OLM containers per container name.
+func componentResources(objects []runtime.Object) map[string]map[string]string {
+	resources := map[string]map[string]string{}
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *appsv1.Deployment:
+			for _, container := range o.Spec.Template.Spec.Containers {
+				resources[container.Name] = flattenResources(container.Resources)
+			}
+		case *olmv1alpha1.ClusterServiceVersion:
+			for _, deployment := range o.Spec.InstallStrategy.StrategySpec.DeploymentSpecs {
+				for _, container := range deployment.Spec.Template.Spec.Containers {
+					resources[container.Name] = flattenResources(container.Resources)
+				}
+			}
+		}
+	}
+	return resources
+}
+
+func TestResourcesConfiguration(t *testing.T) {
+	expected := map[string]map[string]string{
+		"olm-operator":     {"requests.cpu": "10m", "requests.memory": "256Mi", "limits.memory": "512Mi"},
+		"catalog-operator": {"requests.cpu": "50m", "requests.memory": "80Mi", "limits.cpu": "500m"},
+		"packageserver":    {"requests.cpu": "10m", "requests.memory": "50Mi", "limits.memory": "100Mi"},
+	}
+	for _, mode := range []RenderingMode{PatchRenderingMode, TemplateRenderingMode} {
+		t.Run(string(mode), func(t *testing.T) {
+			agent, addon := newTestAgent(t, mode,
+				addonapiv1alpha1.CustomizedVariable{Name: "OLMOperatorMemoryRequest", Value: "256Mi"},
+				addonapiv1alpha1.CustomizedVariable{Name: "OLMOperatorMemoryLimit", Value: "512Mi"},
+				addonapiv1alpha1.CustomizedVariable{Name: "CatalogOperatorCPURequest", Value: "50m"},
+				addonapiv1alpha1.CustomizedVariable{Name: "CatalogOperatorCPULimit", Value: "500m"},
+				addonapiv1alpha1.CustomizedVariable{Name: "PackageServerMemoryLimit", Value: "100Mi"},
+			)
+			objects, err := agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.NoError(t, err)
+			require.Equal(t, expected, componentResources(objects))
+			for _, file := range agent.catalog.get().files["v1.27"] {
+				require.NotEqual(t, expected, componentResources(file.objects), "cached objects modified")
+			}
+
+			agent, addon = newTestAgent(t, mode,
+				addonapiv1alpha1.CustomizedVariable{Name: "PackageServerCPURequest", Value: "a lot"})
+			_, err = agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.ErrorContains(t, err, "PackageServerCPURequest")
+		})
+	}
+}
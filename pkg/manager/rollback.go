@@ -0,0 +1,414 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	"k8s.io/klog/v2"
+
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	addoninformers "open-cluster-management.io/api/client/addon/informers/externalversions"
+	addonlisters "open-cluster-management.io/api/client/addon/listers/addon/v1alpha1"
+	clusterclientset "open-cluster-management.io/api/client/cluster/clientset/versioned"
+	clusterinformers "open-cluster-management.io/api/client/cluster/informers/externalversions"
+	clusterlistersv1 "open-cluster-management.io/api/client/cluster/listers/cluster/v1"
+	workclientset "open-cluster-management.io/api/client/work/clientset/versioned"
+	workinformers "open-cluster-management.io/api/client/work/informers/externalversions"
+	worklisters "open-cluster-management.io/api/client/work/listers/work/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	"github.com/stolostron/olm-addon/pkg/apis/v1alpha1"
+)
+
+const (
+	// RollbackAnnotation holds the rollback state of the OLM release of a cluster on its ManagedClusterAddOn.
+	RollbackAnnotation = "olm.addon.stolostron.io/rollback"
+
+	// ManifestSetAnnotation holds on the OLM deployments the name of the manifest set they are rendered from.
+	ManifestSetAnnotation = "olm.addon.stolostron.io/manifest-set"
+
+	// ConditionRolledBack reports whether the OLM release of the cluster has been rolled back
+	// to the last known good one.
+	ConditionRolledBack = "RolledBack"
+
+	ReasonHealthCheckFailed      = "HealthCheckFailed"
+	ReasonConfigurationChanged   = "ConfigurationChanged"
+	ReasonLastKnownGood          = "LastKnownGood"
+	ReasonInvalidRollbackTimeout = "InvalidRollbackTimeout"
+	EventReasonRolledBack        = "OLMRolledBack"
+	EventReasonRollbackReverted  = "OLMRollbackReverted"
+
+	// rollbackResync is the interval at which the health of the clusters is checked again.
+	rollbackResync = 10 * time.Minute
+)
+
+// deployedRelease is what the addon deploys on a cluster: the OLM images and the manifests they are rendered with.
+type deployedRelease struct {
+	v1alpha1.OLMRelease `json:",inline"`
+	// Manifests is the name of the selected manifest set, e.g. v1.27.
+	Manifests string `json:"manifests,omitempty"`
+	// Revision is the revision of the manifests the manifest set is taken from.
+	Revision string `json:"revision,omitempty"`
+}
+
+// rollbackState is the content of the rollback annotation.
+type rollbackState struct {
+	// LastKnownGood is the last release the OLM components were healthy with.
+	LastKnownGood *deployedRelease `json:"lastKnownGood,omitempty"`
+	// Changed is the release deployed since ChangeTime which is not known to be good yet.
+	Changed    *deployedRelease `json:"changed,omitempty"`
+	ChangeTime *metav1.Time     `json:"changeTime,omitempty"`
+	// RolledBack is the release which failed and got replaced by the last known good one.
+	RolledBack *deployedRelease `json:"rolledBack,omitempty"`
+}
+
+// parseRollbackState reads the rollback annotation. An invalid annotation is ignored.
+func parseRollbackState(addon *addonapiv1alpha1.ManagedClusterAddOn) rollbackState {
+	state := rollbackState{}
+	value, ok := addon.Annotations[RollbackAnnotation]
+	if !ok {
+		return state
+	}
+	if err := json.Unmarshal([]byte(value), &state); err != nil {
+		klog.ErrorS(err, "Ignoring invalid rollback annotation", "cluster", addon.Namespace)
+		return rollbackState{}
+	}
+	return state
+}
+
+// rolledBackRelease returns the release a cluster has been rolled back to, nil when it has not been rolled back.
+// The manifest set is not set for the states recorded before manifest sets were.
+func rolledBackRelease(addon *addonapiv1alpha1.ManagedClusterAddOn) *deployedRelease {
+	state := parseRollbackState(addon)
+	if state.RolledBack == nil {
+		return nil
+	}
+	return state.LastKnownGood
+}
+
+// parseRollbackTimeout reads the RollbackTimeout customized variable, 0 when automatic rollbacks are disabled.
+func parseRollbackTimeout(config addonfactory.Values) (time.Duration, error) {
+	value, ok := config["RollbackTimeout"].(string)
+	if !ok || value == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid value %q for RollbackTimeout, a positive duration is expected", value)
+	}
+	return timeout, nil
+}
+
+// RollbackController records the last OLM release the OLM components of each cluster were healthy with
+// and rolls back to it when the health check keeps failing for the RollbackTimeout after a change.
+type RollbackController struct {
+	agent          *olmAgent
+	recorder       record.EventRecorder
+	clusterFactory clusterinformers.SharedInformerFactory
+	addonFactory   addoninformers.SharedInformerFactory
+	workFactory    workinformers.SharedInformerFactory
+	clusterLister  clusterlistersv1.ManagedClusterLister
+	addonLister    addonlisters.ManagedClusterAddOnLister
+	workLister     worklisters.ManifestWorkLister
+	synced         []cache.InformerSynced
+	queue          workqueue.RateLimitingInterface
+	now            func() time.Time
+}
+
+// NewRollbackController watches the ManagedClusterAddOns and the ManifestWorks of the addon.
+// Rollbacks are recorded as events of the ManagedClusterAddOns.
+func NewRollbackController(agent *olmAgent, clusterClient clusterclientset.Interface, workClient workclientset.Interface,
+	recorder record.EventRecorder) *RollbackController {
+	clusterFactory := clusterinformers.NewSharedInformerFactory(clusterClient, rollbackResync)
+	addonFactory := addoninformers.NewSharedInformerFactory(agent.addonClient, rollbackResync)
+	workFactory := workinformers.NewSharedInformerFactoryWithOptions(workClient, rollbackResync,
+		workinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = fmt.Sprintf("%s=%s", addonapiv1alpha1.AddonLabelKey, agent.addonName)
+		}))
+	clusterInformer := clusterFactory.Cluster().V1().ManagedClusters()
+	addonInformer := addonFactory.Addon().V1alpha1().ManagedClusterAddOns()
+	workInformer := workFactory.Work().V1().ManifestWorks()
+	c := &RollbackController{
+		agent:          agent,
+		recorder:       recorder,
+		clusterFactory: clusterFactory,
+		addonFactory:   addonFactory,
+		workFactory:    workFactory,
+		clusterLister:  clusterInformer.Lister(),
+		addonLister:    addonInformer.Lister(),
+		workLister:     workInformer.Lister(),
+		synced: []cache.InformerSynced{clusterInformer.Informer().HasSynced, addonInformer.Informer().HasSynced,
+			workInformer.Informer().HasSynced},
+		queue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "olm-addon-rollback"),
+		now:   time.Now,
+	}
+	// the queue keys are the cluster namespaces
+	enqueue := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return
+		}
+		if addon, ok := obj.(*addonapiv1alpha1.ManagedClusterAddOn); ok && addon.Name != agent.addonName {
+			return
+		}
+		c.queue.Add(accessor.GetNamespace())
+	}
+	for _, informer := range []cache.SharedIndexInformer{addonInformer.Informer(), workInformer.Informer()} {
+		_, _ = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    enqueue,
+			UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+		})
+	}
+	return c
+}
+
+// Run checks the health of the clusters until the context is cancelled.
+func (c *RollbackController) Run(ctx context.Context) {
+	defer c.queue.ShutDown()
+	c.clusterFactory.Start(ctx.Done())
+	c.addonFactory.Start(ctx.Done())
+	c.workFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		return
+	}
+	go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	<-ctx.Done()
+}
+
+func (c *RollbackController) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *RollbackController) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+	if err := c.sync(ctx, key.(string)); err != nil {
+		klog.ErrorS(err, "Not able to check the OLM release of the cluster for rollback", "cluster", key)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// sync records the release of a healthy cluster as the last known good one, rolls back to it
+// when the release changed and the OLM components are not healthy after the RollbackTimeout,
+// and retries the configured release when it changes again.
+func (c *RollbackController) sync(ctx context.Context, cluster string) error {
+	addon, err := c.addonLister.ManagedClusterAddOns(cluster).Get(c.agent.addonName)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	managedCluster, err := c.clusterLister.Get(cluster)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !c.agent.clusterSupportsAddonInstall(managedCluster) {
+		return nil
+	}
+	config, err := c.agent.getConfig(managedCluster, addon)
+	if err != nil {
+		return err
+	}
+	state := parseRollbackState(addon)
+	timeout, err := parseRollbackTimeout(config)
+	if err != nil {
+		c.agent.updateConditions(addon, metav1.Condition{
+			Type:    ConditionRolledBack,
+			Status:  metav1.ConditionFalse,
+			Reason:  ReasonInvalidRollbackTimeout,
+			Message: err.Error(),
+		})
+		return nil
+	}
+	if timeout == 0 {
+		// automatic rollbacks are disabled, the configured images are deployed
+		if _, ok := addon.Annotations[RollbackAnnotation]; ok {
+			return c.saveState(ctx, addon, nil)
+		}
+		return nil
+	}
+	target, err := c.agent.targetRelease(managedCluster, addon)
+	if err != nil {
+		return nil
+	}
+	now := metav1.NewTime(c.now())
+	if state.RolledBack != nil {
+		if *state.RolledBack == *target {
+			return nil
+		}
+		message := fmt.Sprintf("OLM release changed to %s after the rollback of %s, retrying", releaseString(*target),
+			releaseString(*state.RolledBack))
+		klog.InfoS("Retrying the OLM release after a rollback", "cluster", cluster, "release", releaseString(*target))
+		c.recorder.Event(addon, corev1.EventTypeNormal, EventReasonRollbackReverted, message)
+		state.RolledBack = nil
+		state.Changed, state.ChangeTime = target, &now
+		if err := c.saveState(ctx, addon, &state); err != nil {
+			return err
+		}
+		c.agent.updateConditions(addon, metav1.Condition{
+			Type:    ConditionRolledBack,
+			Status:  metav1.ConditionFalse,
+			Reason:  ReasonConfigurationChanged,
+			Message: message,
+		})
+		c.queue.AddAfter(cluster, timeout)
+		return nil
+	}
+	healthErr := c.agent.deployHealthCheck(c.workLister, cluster, *target)
+	if healthErr == nil {
+		if state.LastKnownGood != nil && *state.LastKnownGood == *target && state.Changed == nil {
+			return nil
+		}
+		state.LastKnownGood, state.Changed, state.ChangeTime = target, nil, nil
+		if err := c.saveState(ctx, addon, &state); err != nil {
+			return err
+		}
+		c.agent.updateConditions(addon, metav1.Condition{
+			Type:    ConditionRolledBack,
+			Status:  metav1.ConditionFalse,
+			Reason:  ReasonLastKnownGood,
+			Message: fmt.Sprintf("OLM components are healthy with %s", releaseString(*target)),
+		})
+		return nil
+	}
+	if state.LastKnownGood == nil || *state.LastKnownGood == *target {
+		// there is nothing to roll back to
+		return nil
+	}
+	if state.Changed == nil || *state.Changed != *target || state.ChangeTime == nil {
+		state.Changed, state.ChangeTime = target, &now
+		c.queue.AddAfter(cluster, timeout)
+		return c.saveState(ctx, addon, &state)
+	}
+	if elapsed := now.Sub(state.ChangeTime.Time); elapsed < timeout {
+		c.queue.AddAfter(cluster, timeout-elapsed)
+		return nil
+	}
+	message := fmt.Sprintf("OLM release rolled back from %s to %s, the OLM components are not healthy after %s: %s",
+		releaseString(*target), releaseString(*state.LastKnownGood), timeout, healthErr.Error())
+	klog.InfoS("Rolling back the OLM release", "cluster", cluster, "release", releaseString(*target),
+		"lastKnownGood", releaseString(*state.LastKnownGood), "reason", healthErr.Error())
+	state.RolledBack, state.Changed, state.ChangeTime = target, nil, nil
+	if err := c.saveState(ctx, addon, &state); err != nil {
+		return err
+	}
+	c.recorder.Event(addon, corev1.EventTypeWarning, EventReasonRolledBack, message)
+	c.agent.updateConditions(addon, metav1.Condition{
+		Type:    ConditionRolledBack,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonHealthCheckFailed,
+		Message: message,
+	})
+	return nil
+}
+
+// targetRelease returns the release deployed on a cluster without rollback: the images pinned by an OLMRollout
+// or the configured ones, rendered with the manifest set selected for the cluster in the manifests served.
+func (o *olmAgent) targetRelease(cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) (*deployedRelease, error) {
+	kubeVersion, err := version.ParseSemantic(cluster.Status.Version.Kubernetes)
+	if err != nil {
+		kubeVersion, _ = version.ParseSemantic(defaultVersion)
+	}
+	manifests := o.catalog.get()
+	set, _, err := manifests.resolver.resolve(kubeVersion)
+	if err != nil {
+		return nil, err
+	}
+	images, err := pinnedRelease(addon)
+	if err != nil || images == nil {
+		desired, err := o.desiredRelease(cluster, addon)
+		if err != nil {
+			return nil, err
+		}
+		images = &desired
+	}
+	return &deployedRelease{OLMRelease: *images, Manifests: set.Name(), Revision: manifests.revision}, nil
+}
+
+// setManifestSetAnnotations records the manifest set and the revision of the manifests on the OLM deployments.
+func setManifestSetAnnotations(objects []runtime.Object, namespace, name, revision string) {
+	for _, obj := range objects {
+		deployment, ok := obj.(*appsv1.Deployment)
+		if !ok || deployment.Namespace != namespace {
+			continue
+		}
+		if deployment.Annotations == nil {
+			deployment.Annotations = map[string]string{}
+		}
+		deployment.Annotations[ManifestSetAnnotation] = name
+		deployment.Annotations[ManifestsRevisionAnnotation] = revision
+	}
+}
+
+// saveState sets the rollback annotation, which is removed when the state is nil.
+func (c *RollbackController) saveState(ctx context.Context, addon *addonapiv1alpha1.ManagedClusterAddOn,
+	state *rollbackState) error {
+	updated := addon.DeepCopy()
+	if state == nil {
+		delete(updated.Annotations, RollbackAnnotation)
+	} else {
+		value, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[RollbackAnnotation] = string(value)
+	}
+	previous, existed := addon.Annotations[RollbackAnnotation]
+	if current, exists := updated.Annotations[RollbackAnnotation]; existed == exists && previous == current {
+		return nil
+	}
+	_, err := c.agent.addonClient.AddonV1alpha1().ManagedClusterAddOns(addon.Namespace).Update(ctx, updated,
+		metav1.UpdateOptions{})
+	return err
+}
+
+// releaseString describes the images and the manifest set of a release.
+func releaseString(release deployedRelease) string {
+	description := fmt.Sprintf("olm %s and configmap server %s", release.OLM, release.ConfigMapServer)
+	if release.Manifests == "" {
+		return description
+	}
+	return fmt.Sprintf("%s of manifest set %s (revision %s)", description, release.Manifests,
+		shortRevision(release.Revision))
+}
+
+// shortRevision abbreviates the digest of a revision of the manifests for messages.
+func shortRevision(revision string) string {
+	if len(revision) > len("sha256:")+12 {
+		return revision[:len("sha256:")+12]
+	}
+	return revision
+}
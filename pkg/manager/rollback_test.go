@@ -0,0 +1,187 @@
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterfake "open-cluster-management.io/api/client/cluster/clientset/versioned/fake"
+	workfake "open-cluster-management.io/api/client/work/clientset/versioned/fake"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stolostron/olm-addon/pkg/apis/v1alpha1"
+)
+
+func TestParseRollbackTimeout(t *testing.T) {
+	timeout, err := parseRollbackTimeout(nil)
+	require.NoError(t, err)
+	require.Zero(t, timeout, "disabled by default")
+	timeout, err = parseRollbackTimeout(map[string]interface{}{"RollbackTimeout": "15m"})
+	require.NoError(t, err)
+	require.Equal(t, 15*time.Minute, timeout)
+	_, err = parseRollbackTimeout(map[string]interface{}{"RollbackTimeout": "-1m"})
+	require.ErrorContains(t, err, "a positive duration is expected")
+}
+
+func TestRollbackControllerSync(t *testing.T) {
+	ctx := context.Background()
+	agent, _ := newTestAgent(t, PatchRenderingMode,
+		addonapiv1alpha1.CustomizedVariable{Name: "RollbackTimeout", Value: "10m"})
+	cluster := newTestCluster("v1.27.3")
+	recorder := record.NewFakeRecorder(10)
+	controller := NewRollbackController(agent, clusterfake.NewSimpleClientset(), workfake.NewSimpleClientset(), recorder)
+	now := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	controller.now = func() time.Time { return now }
+	addonIndexer := controller.addonFactory.Addon().V1alpha1().ManagedClusterAddOns().Informer().GetIndexer()
+	workIndexer := controller.workFactory.Work().V1().ManifestWorks().Informer().GetIndexer()
+	require.NoError(t, controller.clusterFactory.Cluster().V1().ManagedClusters().Informer().GetIndexer().Add(cluster))
+
+	addon := func() *addonapiv1alpha1.ManagedClusterAddOn {
+		addon, err := agent.addonClient.AddonV1alpha1().ManagedClusterAddOns("cluster1").Get(ctx, "olm-addon",
+			metav1.GetOptions{})
+		require.NoError(t, err)
+		return addon
+	}
+	// sync deploys the manifests of the ManagedClusterAddOn with the given readiness and syncs the cluster
+	sync := func(readyReplicas int64) rollbackState {
+		require.NoError(t, addonIndexer.Update(addon()))
+		require.NoError(t, workIndexer.Update(rolloutWork(t, agent, cluster, addon(), readyReplicas)))
+		require.NoError(t, controller.sync(ctx, "cluster1"))
+		return parseRollbackState(addon())
+	}
+	// pin simulates an OLMRollout pinning the images of the cluster, nil to release them
+	pin := func(release *v1alpha1.OLMRelease) {
+		updated := addon()
+		if release == nil {
+			delete(updated.Annotations, OLMReleaseAnnotation)
+		} else {
+			for key, value := range pinnedAddon("cluster1", *release).Annotations {
+				updated.Annotations[key] = value
+			}
+		}
+		_, err := agent.addonClient.AddonV1alpha1().ManagedClusterAddOns("cluster1").Update(ctx, updated,
+			metav1.UpdateOptions{})
+		require.NoError(t, err)
+	}
+	images, err := agent.desiredRelease(cluster, addon())
+	require.NoError(t, err)
+	// release returns the images rendered with the manifest set of the cluster
+	release := func(images v1alpha1.OLMRelease) *deployedRelease {
+		return &deployedRelease{OLMRelease: images, Manifests: "v1.27", Revision: agent.catalog.get().revision}
+	}
+	desired := release(images)
+
+	// healthy images are recorded as the last known good ones
+	state := sync(1)
+	require.Equal(t, desired, state.LastKnownGood)
+	require.Nil(t, state.RolledBack)
+
+	// failing images are not rolled back before the timeout
+	pin(&oldRelease)
+	state = sync(0)
+	require.Equal(t, release(oldRelease), state.Changed)
+	require.Equal(t, now, state.ChangeTime.Time.UTC())
+	now = now.Add(5 * time.Minute)
+	require.Nil(t, sync(0).RolledBack)
+
+	// failing images are rolled back after the timeout
+	now = now.Add(6 * time.Minute)
+	state = sync(0)
+	require.Equal(t, release(oldRelease), state.RolledBack)
+	require.Equal(t, desired, rolledBackRelease(addon()))
+	objects, err := agent.Manifests(cluster, addon())
+	require.NoError(t, err)
+	require.Equal(t, desired.OLM, deploymentImages(objects)["olm-operator"], "the last known good images are deployed")
+	condition := meta.FindStatusCondition(addon().Status.Conditions, ConditionRolledBack)
+	require.NotNil(t, condition)
+	require.Equal(t, metav1.ConditionTrue, condition.Status)
+	require.Equal(t, ReasonHealthCheckFailed, condition.Reason)
+	require.Contains(t, <-recorder.Events, "Warning OLMRolledBack")
+
+	// the cluster stays rolled back until the configuration changes
+	now = now.Add(time.Hour)
+	require.Equal(t, release(oldRelease), sync(1).RolledBack)
+	pin(nil)
+	state = sync(1)
+	require.Nil(t, state.RolledBack)
+	require.Nil(t, rolledBackRelease(addon()))
+	condition = meta.FindStatusCondition(addon().Status.Conditions, ConditionRolledBack)
+	require.Equal(t, ReasonConfigurationChanged, condition.Reason)
+	require.Contains(t, <-recorder.Events, "Normal OLMRollbackReverted")
+}
+
+func TestRollbackManifests(t *testing.T) {
+	ctx := context.Background()
+	agent, _ := newTestAgent(t, PatchRenderingMode,
+		addonapiv1alpha1.CustomizedVariable{Name: "RollbackTimeout", Value: "10m"})
+	cluster := newTestCluster("v1.27.3")
+	controller := NewRollbackController(agent, clusterfake.NewSimpleClientset(), workfake.NewSimpleClientset(),
+		record.NewFakeRecorder(10))
+	now := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	controller.now = func() time.Time { return now }
+	addonIndexer := controller.addonFactory.Addon().V1alpha1().ManagedClusterAddOns().Informer().GetIndexer()
+	workIndexer := controller.workFactory.Work().V1().ManifestWorks().Informer().GetIndexer()
+	require.NoError(t, controller.clusterFactory.Cluster().V1().ManagedClusters().Informer().GetIndexer().Add(cluster))
+	addon := func() *addonapiv1alpha1.ManagedClusterAddOn {
+		addon, err := agent.addonClient.AddonV1alpha1().ManagedClusterAddOns("cluster1").Get(ctx, "olm-addon",
+			metav1.GetOptions{})
+		require.NoError(t, err)
+		return addon
+	}
+	sync := func(readyReplicas int64) rollbackState {
+		require.NoError(t, addonIndexer.Update(addon()))
+		require.NoError(t, workIndexer.Update(rolloutWork(t, agent, cluster, addon(), readyReplicas)))
+		require.NoError(t, controller.sync(ctx, "cluster1"))
+		return parseRollbackState(addon())
+	}
+	good := agent.catalog.get()
+	require.Equal(t, good.revision, sync(1).LastKnownGood.Revision)
+
+	// the reloaded manifests keep the same images but fail
+	reloaded := loadedManifests{resolver: good.resolver, files: map[string][]manifestFile{}, revision: "sha256:reloaded"}
+	for name, files := range good.files {
+		reloaded.files[name] = files
+	}
+	reloaded.files["v1.27"] = append(append([]manifestFile{}, good.files["v1.27"]...), manifestFile{name: "reloaded.yaml",
+		objects: []runtime.Object{&corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: "reloaded", Namespace: "olm"},
+		}}})
+	agent.catalog.set(reloaded)
+	require.Equal(t, "sha256:reloaded", sync(0).Changed.Revision)
+	now = now.Add(11 * time.Minute)
+	state := sync(0)
+	require.Equal(t, "sha256:reloaded", state.RolledBack.Revision)
+	require.Equal(t, good.revision, state.LastKnownGood.Revision)
+
+	// the manifests of the last known good revision are deployed
+	objects, err := agent.Manifests(cluster, addon())
+	require.NoError(t, err)
+	for _, obj := range objects {
+		if cm, ok := obj.(*corev1.ConfigMap); ok {
+			require.NotEqual(t, "reloaded", cm.Name, "the manifests of the failed revision are not deployed")
+		}
+	}
+	require.Equal(t, state.LastKnownGood, workRelease(rolloutWork(t, agent, cluster, addon(), 1), "olm"))
+	condition := meta.FindStatusCondition(addon().Status.Conditions, ConditionManifestSetSelected)
+	require.NotNil(t, condition)
+	require.Equal(t, ReasonLastKnownGood, condition.Reason)
+}
+
+func TestParseRollbackStateImagesOnly(t *testing.T) {
+	// states recorded before the manifest sets were only have the images
+	addon := &addonapiv1alpha1.ManagedClusterAddOn{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		RollbackAnnotation: `{"lastKnownGood":{"olm":"olm:v1","configMapServer":"cms:v1"},` +
+			`"rolledBack":{"olm":"olm:v2","configMapServer":"cms:v2"}}`,
+	}}}
+	release := rolledBackRelease(addon)
+	require.Equal(t, &deployedRelease{OLMRelease: v1alpha1.OLMRelease{OLM: "olm:v1", ConfigMapServer: "cms:v1"}}, release)
+	require.Equal(t, "olm olm:v1 and configmap server cms:v1", releaseString(*release))
+}
@@ -0,0 +1,670 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"k8s.io/klog/v2"
+
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	addoninformers "open-cluster-management.io/api/client/addon/informers/externalversions"
+	addonlisters "open-cluster-management.io/api/client/addon/listers/addon/v1alpha1"
+	clusterclientset "open-cluster-management.io/api/client/cluster/clientset/versioned"
+	clusterinformers "open-cluster-management.io/api/client/cluster/informers/externalversions"
+	clusterlistersv1 "open-cluster-management.io/api/client/cluster/listers/cluster/v1"
+	clusterlisters "open-cluster-management.io/api/client/cluster/listers/cluster/v1beta1"
+	workclientset "open-cluster-management.io/api/client/work/clientset/versioned"
+	workinformers "open-cluster-management.io/api/client/work/informers/externalversions"
+	worklisters "open-cluster-management.io/api/client/work/listers/work/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	workapiv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stolostron/olm-addon/pkg/apis/v1alpha1"
+)
+
+const (
+	// OLMReleaseAnnotation pins the OLM images deployed on a cluster. It is set on the ManagedClusterAddOn
+	// by the OLMRollout the cluster belongs to and takes precedence over the AddOnDeploymentConfig.
+	OLMReleaseAnnotation = "olm.addon.stolostron.io/olm-release"
+	// OLMRolloutAnnotation holds the namespace/name key of the OLMRollout pinning the OLM images of a cluster.
+	OLMRolloutAnnotation = "olm.addon.stolostron.io/olm-rollout"
+
+	// ConditionRolloutProgressing reports whether clusters are still to be updated by an OLMRollout.
+	ConditionRolloutProgressing = "Progressing"
+
+	ReasonRollingOut       = "RollingOut"
+	ReasonRolloutCompleted = "RolloutCompleted"
+	ReasonRolloutPaused    = "RolloutPaused"
+	ReasonRolloutHalted    = "RolloutHalted"
+
+	// States of the clusters of an OLMRollout.
+	RolloutStatePending  = "Pending"
+	RolloutStateUpdating = "Updating"
+	RolloutStateUpdated  = "Updated"
+	RolloutStateFailed   = "Failed"
+	RolloutStateSkipped  = "Skipped"
+
+	// defaultHealthCheckDelay is the time given to the OLM components of an updated cluster to become healthy.
+	defaultHealthCheckDelay = 5 * time.Minute
+	// rolloutResync is the interval at which the OLMRollouts are reconciled.
+	rolloutResync = 10 * time.Minute
+)
+
+// pinnedRelease returns the OLM images pinned on the ManagedClusterAddOn, nil when none is.
+func pinnedRelease(addon *addonapiv1alpha1.ManagedClusterAddOn) (*v1alpha1.OLMRelease, error) {
+	value, ok := addon.Annotations[OLMReleaseAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	release := &v1alpha1.OLMRelease{}
+	if err := json.Unmarshal([]byte(value), release); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", OLMReleaseAnnotation, err)
+	}
+	if release.OLM == "" || release.ConfigMapServer == "" {
+		return nil, fmt.Errorf("invalid %s annotation: both images are required", OLMReleaseAnnotation)
+	}
+	return release, nil
+}
+
+// pinRelease returns a copy of the configuration with the images of the release.
+func pinRelease(config addonfactory.Values, release v1alpha1.OLMRelease) addonfactory.Values {
+	pinned := addonfactory.Values{}
+	for key, value := range config {
+		pinned[key] = value
+	}
+	pinned["OLMImage"] = release.OLM
+	pinned["ConfigMapServerImage"] = release.ConfigMapServer
+	return pinned
+}
+
+// getConfig returns the values of the AddOnDeploymentConfig of the addon, nil when there is none.
+func (o *olmAgent) getConfig(cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) (addonfactory.Values, error) {
+	config, err := addonfactory.GetAddOnDeploymentConfigValues(
+		addonfactory.NewAddOnDeloymentConfigGetter(o.addonClient),
+		addonfactory.ToAddOnDeloymentConfigValues)(cluster, addon)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return config, err
+}
+
+// desiredRelease returns the OLM images configured for a cluster, ignoring the pinned release:
+// the images of the manifest set selected for its Kubernetes version overridden by the AddOnDeploymentConfig.
+func (o *olmAgent) desiredRelease(cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) (v1alpha1.OLMRelease, error) {
+	kubeVersion, err := version.ParseSemantic(cluster.Status.Version.Kubernetes)
+	if err != nil {
+		kubeVersion, _ = version.ParseSemantic(defaultVersion)
+	}
+	set, _, err := o.catalog.get().resolver.resolve(kubeVersion)
+	if err != nil {
+		return v1alpha1.OLMRelease{}, err
+	}
+	release := v1alpha1.OLMRelease{OLM: set.images.OLM, ConfigMapServer: set.images.ConfigMapServer}
+	config, err := o.getConfig(cluster, addon)
+	if err != nil {
+		return v1alpha1.OLMRelease{}, err
+	}
+	if image, ok := config["OLMImage"].(string); ok && image != "" {
+		release.OLM = image
+	}
+	if image, ok := config["ConfigMapServerImage"].(string); ok && image != "" {
+		release.ConfigMapServer = image
+	}
+	return release, nil
+}
+
+// workRelease returns the OLM images of the deployments of a ManifestWork and the manifest set they are rendered
+// with, nil when it does not deploy OLM.
+func workRelease(work *workapiv1.ManifestWork, namespace string) *deployedRelease {
+	var release *deployedRelease
+	for _, manifest := range work.Spec.Workload.Manifests {
+		u := &unstructured.Unstructured{}
+		if err := json.Unmarshal(manifest.Raw, &u.Object); err != nil || u.GetKind() != "Deployment" ||
+			u.GetNamespace() != namespace {
+			continue
+		}
+		deployment := &appsv1.Deployment{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, deployment); err != nil {
+			continue
+		}
+		for _, container := range deployment.Spec.Template.Spec.Containers {
+			switch {
+			case deployment.Name == "olm-operator" && container.Name == "olm-operator":
+				if release == nil {
+					release = &deployedRelease{}
+				}
+				release.OLM = container.Image
+				release.Manifests = deployment.Annotations[ManifestSetAnnotation]
+				release.Revision = deployment.Annotations[ManifestsRevisionAnnotation]
+			case deployment.Name == "catalog-operator" && container.Name == "catalog-operator":
+				if release == nil {
+					release = &deployedRelease{}
+				}
+				release.ConfigMapServer = argValue(container.Args, "--configmapServerImage")
+			}
+		}
+	}
+	return release
+}
+
+// deployHealthCheck checks the health of the OLM components of a cluster deploying a release
+// with the ManifestWorks of the addon.
+func (o *olmAgent) deployHealthCheck(lister worklisters.ManifestWorkLister, cluster string,
+	release deployedRelease) error {
+	works, err := lister.ManifestWorks(cluster).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	sort.Slice(works, func(i, j int) bool { return works[i].Name < works[j].Name })
+	for _, work := range works {
+		if strings.HasPrefix(work.Name, constants.DeployWorkNamePrefix(o.addonName)) &&
+			workRelease(work, o.namespaces.OLM) != nil {
+			return o.rolloutHealthCheck(work, release)
+		}
+	}
+	return fmt.Errorf("the ManifestWork deploying OLM does not exist")
+}
+
+// rolloutHealthCheck checks that a ManifestWork deploys the release and that the OLM components run it and are
+// healthy. The manifest set is only checked when the release has one.
+// The CatalogSources are not part of the check as their health does not depend on the release.
+func (o *olmAgent) rolloutHealthCheck(work *workapiv1.ManifestWork, release deployedRelease) error {
+	deployed := workRelease(work, o.namespaces.OLM)
+	if deployed == nil || deployed.OLMRelease != release.OLMRelease || (release.Manifests != "" &&
+		(deployed.Manifests != release.Manifests || deployed.Revision != release.Revision)) {
+		return fmt.Errorf("ManifestWork %s is not updated yet", work.Name)
+	}
+	applied := meta.FindStatusCondition(work.Status.Conditions, workapiv1.WorkApplied)
+	if applied == nil || applied.Status != metav1.ConditionTrue || applied.ObservedGeneration != work.Generation {
+		return fmt.Errorf("ManifestWork %s is not applied yet", work.Name)
+	}
+	components := []probedComponent{}
+	for _, component := range o.probedComponents() {
+		if component.field.ResourceIdentifier.Resource != "catalogsources" {
+			components = append(components, component)
+		}
+	}
+	for _, condition := range healthConditions(components, work) {
+		if condition.Type == ConditionDegraded {
+			if condition.Status == metav1.ConditionTrue {
+				return fmt.Errorf("%s", condition.Message)
+			}
+			continue
+		}
+		if condition.Status != metav1.ConditionTrue {
+			return fmt.Errorf("%s: %s", condition.Type, condition.Message)
+		}
+	}
+	for _, component := range components {
+		identifier := component.field.ResourceIdentifier
+		if identifier.Resource != "deployments" {
+			continue
+		}
+		status := parseDeploymentFeedback(*findFeedback(identifier, work))
+		if status.updatedReplicas < status.replicas {
+			return fmt.Errorf("%d of %d replicas of deployment %s/%s are updated", status.updatedReplicas,
+				status.replicas, identifier.Namespace, identifier.Name)
+		}
+	}
+	return nil
+}
+
+// decodeOLMRollout converts an OLMRollout from its unstructured representation.
+func decodeOLMRollout(obj runtime.Object) (*v1alpha1.OLMRollout, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+	rollout := &v1alpha1.OLMRollout{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, rollout); err != nil {
+		return nil, fmt.Errorf("not able to decode OLMRollout %s/%s: %w", u.GetNamespace(), u.GetName(), err)
+	}
+	return rollout, nil
+}
+
+// validateOLMRollout checks the spec of an OLMRollout.
+func validateOLMRollout(spec v1alpha1.OLMRolloutSpec) error {
+	if spec.Placement == "" {
+		return fmt.Errorf("placement is required")
+	}
+	if spec.BatchSize < 0 {
+		return fmt.Errorf("invalid batchSize %d, a positive number is expected", spec.BatchSize)
+	}
+	if spec.MaxUnavailable < 0 {
+		return fmt.Errorf("invalid maxUnavailable %d, a positive number is expected", spec.MaxUnavailable)
+	}
+	if spec.HealthCheckDelay != nil && spec.HealthCheckDelay.Duration <= 0 {
+		return fmt.Errorf("invalid healthCheckDelay %s, a positive duration is expected", spec.HealthCheckDelay.Duration)
+	}
+	return nil
+}
+
+// RolloutController updates the OLM images of the clusters selected by the OLMRollouts in batches
+// and halts when the OLM components of the updated clusters fail the health check.
+// The images of the clusters are pinned on their ManagedClusterAddOn, which the addon framework watches
+// for rendering the manifests again.
+type RolloutController struct {
+	agent          *olmAgent
+	dynamicClient  dynamic.Interface
+	rolloutFactory dynamicinformer.DynamicSharedInformerFactory
+	clusterFactory clusterinformers.SharedInformerFactory
+	addonFactory   addoninformers.SharedInformerFactory
+	workFactory    workinformers.SharedInformerFactory
+	rolloutLister  cache.GenericLister
+	decisionLister clusterlisters.PlacementDecisionLister
+	clusterLister  clusterlistersv1.ManagedClusterLister
+	addonLister    addonlisters.ManagedClusterAddOnLister
+	workLister     worklisters.ManifestWorkLister
+	synced         []cache.InformerSynced
+	queue          workqueue.RateLimitingInterface
+	now            func() time.Time
+}
+
+// NewRolloutController watches the OLMRollouts, the PlacementDecisions, the ManagedClusterAddOns and
+// the ManifestWorks of the addon.
+func NewRolloutController(agent *olmAgent, dynamicClient dynamic.Interface, clusterClient clusterclientset.Interface,
+	workClient workclientset.Interface) *RolloutController {
+	rolloutFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, rolloutResync)
+	clusterFactory := clusterinformers.NewSharedInformerFactory(clusterClient, rolloutResync)
+	addonFactory := addoninformers.NewSharedInformerFactory(agent.addonClient, rolloutResync)
+	workFactory := workinformers.NewSharedInformerFactoryWithOptions(workClient, rolloutResync,
+		workinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = fmt.Sprintf("%s=%s", addonapiv1alpha1.AddonLabelKey, agent.addonName)
+		}))
+	rolloutInformer := rolloutFactory.ForResource(v1alpha1.OLMRolloutGVR)
+	decisionInformer := clusterFactory.Cluster().V1beta1().PlacementDecisions()
+	clusterInformer := clusterFactory.Cluster().V1().ManagedClusters()
+	addonInformer := addonFactory.Addon().V1alpha1().ManagedClusterAddOns()
+	workInformer := workFactory.Work().V1().ManifestWorks()
+	c := &RolloutController{
+		agent:          agent,
+		dynamicClient:  dynamicClient,
+		rolloutFactory: rolloutFactory,
+		clusterFactory: clusterFactory,
+		addonFactory:   addonFactory,
+		workFactory:    workFactory,
+		rolloutLister:  rolloutInformer.Lister(),
+		decisionLister: decisionInformer.Lister(),
+		clusterLister:  clusterInformer.Lister(),
+		addonLister:    addonInformer.Lister(),
+		workLister:     workInformer.Lister(),
+		synced: []cache.InformerSynced{rolloutInformer.Informer().HasSynced, decisionInformer.Informer().HasSynced,
+			clusterInformer.Informer().HasSynced, addonInformer.Informer().HasSynced, workInformer.Informer().HasSynced},
+		queue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "olm-addon-rollouts"),
+		now:   time.Now,
+	}
+	enqueue := func(obj interface{}) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			utilruntime.HandleError(err)
+			return
+		}
+		c.queue.Add(key)
+	}
+	_, _ = rolloutInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+		DeleteFunc: enqueue,
+	})
+	enqueueDecision := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		if decision, ok := obj.(*clusterv1beta1.PlacementDecision); ok {
+			c.enqueueRollouts(func(rollout *v1alpha1.OLMRollout) bool {
+				return rollout.Namespace == decision.Namespace &&
+					rollout.Spec.Placement == decision.Labels[clusterv1beta1.PlacementLabel]
+			})
+		}
+	}
+	_, _ = decisionInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueueDecision,
+		UpdateFunc: func(_, obj interface{}) { enqueueDecision(obj) },
+		DeleteFunc: enqueueDecision,
+	})
+	// changes of the configuration and of the health of the clusters are reflected on their ManagedClusterAddOn
+	// and ManifestWorks, all the rollouts are reconciled as a cluster may be selected by any of them
+	enqueueAll := func(obj interface{}) {
+		c.enqueueRollouts(func(*v1alpha1.OLMRollout) bool { return true })
+	}
+	for _, informer := range []cache.SharedIndexInformer{addonInformer.Informer(), workInformer.Informer()} {
+		_, _ = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    enqueueAll,
+			UpdateFunc: func(_, obj interface{}) { enqueueAll(obj) },
+			DeleteFunc: enqueueAll,
+		})
+	}
+	return c
+}
+
+// enqueueRollouts enqueues the OLMRollouts matching the filter.
+func (c *RolloutController) enqueueRollouts(filter func(*v1alpha1.OLMRollout) bool) {
+	objects, err := c.rolloutLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, obj := range objects {
+		rollout, err := decodeOLMRollout(obj)
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		if filter(rollout) {
+			c.queue.Add(rollout.Namespace + "/" + rollout.Name)
+		}
+	}
+}
+
+// Run processes the OLMRollouts until the context is cancelled.
+func (c *RolloutController) Run(ctx context.Context) {
+	defer c.queue.ShutDown()
+	c.rolloutFactory.Start(ctx.Done())
+	c.clusterFactory.Start(ctx.Done())
+	c.addonFactory.Start(ctx.Done())
+	c.workFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		return
+	}
+	go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	<-ctx.Done()
+}
+
+func (c *RolloutController) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *RolloutController) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+	if err := c.sync(ctx, key.(string)); err != nil {
+		klog.ErrorS(err, "Not able to sync the OLMRollout", "olmRollout", key)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// sync updates the state of the clusters of an OLMRollout, pins the release of the next batch of clusters
+// and updates the status of the OLMRollout. The clusters which are no longer selected, or all of them when
+// the OLMRollout is deleted, are released and get the configured images directly.
+func (c *RolloutController) sync(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	obj, err := c.rolloutLister.ByNamespace(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return c.unpin(ctx, key, nil)
+	}
+	if err != nil {
+		return err
+	}
+	rollout, err := decodeOLMRollout(obj)
+	if err != nil {
+		return err
+	}
+	status := v1alpha1.OLMRolloutStatus{
+		Conditions: append([]metav1.Condition{}, rollout.Status.Conditions...),
+	}
+	if err := validateOLMRollout(rollout.Spec); err != nil {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    ConditionRolloutProgressing,
+			Status:  metav1.ConditionFalse,
+			Reason:  ReasonInvalidSpec,
+			Message: err.Error(),
+		})
+		return c.updateStatus(ctx, obj.(*unstructured.Unstructured), rollout, status)
+	}
+	clusters, err := decidedClusters(c.decisionLister, namespace, rollout.Spec.Placement)
+	if err != nil {
+		return err
+	}
+	if err := c.unpin(ctx, key, clusters); err != nil {
+		return err
+	}
+	delay := defaultHealthCheckDelay
+	if rollout.Spec.HealthCheckDelay != nil {
+		delay = rollout.Spec.HealthCheckDelay.Duration
+	}
+	previous := map[string]v1alpha1.ClusterRolloutStatus{}
+	for _, cluster := range rollout.Status.Clusters {
+		previous[cluster.Cluster] = cluster
+	}
+	now := metav1.NewTime(c.now())
+	pending := []int{}
+	updating, failed := []string{}, []string{}
+	for _, cluster := range clusters {
+		clusterStatus, err := c.clusterStatus(ctx, key, cluster, previous[cluster], now, delay)
+		if err != nil {
+			return err
+		}
+		switch clusterStatus.State {
+		case RolloutStatePending:
+			pending = append(pending, len(status.Clusters))
+		case RolloutStateUpdating:
+			updating = append(updating, cluster)
+		case RolloutStateFailed:
+			failed = append(failed, cluster)
+		}
+		status.Clusters = append(status.Clusters, clusterStatus)
+	}
+	batchSize := int(rollout.Spec.BatchSize)
+	if batchSize == 0 {
+		batchSize = 1
+	}
+	halted := len(failed) > int(rollout.Spec.MaxUnavailable)
+	if !rollout.Spec.Paused && !halted {
+		for _, i := range pending {
+			if len(updating) >= batchSize {
+				break
+			}
+			if err := c.pin(ctx, key, status.Clusters[i].Cluster, *status.Clusters[i].Target); err != nil {
+				return err
+			}
+			status.Clusters[i] = v1alpha1.ClusterRolloutStatus{
+				Cluster:            status.Clusters[i].Cluster,
+				State:              RolloutStateUpdating,
+				Release:            status.Clusters[i].Target,
+				Target:             status.Clusters[i].Target,
+				LastTransitionTime: &now,
+				Message:            "the OLM images are being updated",
+			}
+			updating = append(updating, status.Clusters[i].Cluster)
+			klog.InfoS("Updating the OLM images of the cluster", "olmRollout", key, "cluster", status.Clusters[i].Cluster,
+				"olmImage", status.Clusters[i].Target.OLM, "configMapServerImage", status.Clusters[i].Target.ConfigMapServer)
+		}
+	}
+	if len(updating) > 0 {
+		// the health of the updating clusters is checked again once their delay is over
+		c.queue.AddAfter(key, delay)
+	}
+	updated := len(clusters) - len(pending) - len(updating) - len(failed)
+	condition := metav1.Condition{Type: ConditionRolloutProgressing}
+	switch {
+	case halted:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonRolloutHalted
+		condition.Message = fmt.Sprintf("%d clusters failed the health check, %d allowed: %s", len(failed),
+			rollout.Spec.MaxUnavailable, strings.Join(failed, ", "))
+	case rollout.Spec.Paused && len(pending)+len(updating) > 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonRolloutPaused
+		condition.Message = fmt.Sprintf("rollout paused with %d clusters to update", len(pending)+len(updating))
+	case len(pending)+len(updating) > 0:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonRollingOut
+		condition.Message = fmt.Sprintf("%d clusters updating, %d pending", len(updating), len(pending))
+	default:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonRolloutCompleted
+		condition.Message = fmt.Sprintf("%d clusters run the configured OLM images", updated)
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+	return c.updateStatus(ctx, obj.(*unstructured.Unstructured), rollout, status)
+}
+
+// clusterStatus computes the state of a cluster of an OLMRollout. Clusters joining the rollout are pinned
+// to their configured release, which they already run.
+func (c *RolloutController) clusterStatus(ctx context.Context, key, cluster string,
+	previous v1alpha1.ClusterRolloutStatus, now metav1.Time, delay time.Duration) (v1alpha1.ClusterRolloutStatus, error) {
+	status := v1alpha1.ClusterRolloutStatus{Cluster: cluster, State: RolloutStateSkipped}
+	setState := func(state, message string) {
+		status.State, status.Message = state, message
+		status.LastTransitionTime = previous.LastTransitionTime
+		if previous.State != state || previous.LastTransitionTime == nil {
+			status.LastTransitionTime = &now
+		}
+	}
+	addon, err := c.addonLister.ManagedClusterAddOns(cluster).Get(c.agent.addonName)
+	if apierrors.IsNotFound(err) {
+		setState(RolloutStateSkipped, "the addon is not installed")
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+	if owner := addon.Annotations[OLMRolloutAnnotation]; owner != "" && owner != key {
+		setState(RolloutStateSkipped, fmt.Sprintf("the OLM images are managed by OLMRollout %s", owner))
+		return status, nil
+	}
+	managedCluster, err := c.clusterLister.Get(cluster)
+	if apierrors.IsNotFound(err) {
+		setState(RolloutStateSkipped, "the ManagedCluster does not exist")
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+	if !c.agent.clusterSupportsAddonInstall(managedCluster) {
+		setState(RolloutStateSkipped, "OLM is not deployed by the addon")
+		return status, nil
+	}
+	desired, err := c.agent.desiredRelease(managedCluster, addon)
+	if err != nil {
+		setState(RolloutStateSkipped, err.Error())
+		return status, nil
+	}
+	status.Target = &desired
+	pinned, err := pinnedRelease(addon)
+	if err != nil || pinned == nil || addon.Annotations[OLMRolloutAnnotation] != key {
+		if err := c.pin(ctx, key, cluster, desired); err != nil {
+			return status, err
+		}
+		pinned = &desired
+	}
+	status.Release = pinned
+	if *pinned != desired {
+		setState(RolloutStatePending, "waiting for the rollout to reach the cluster")
+		return status, nil
+	}
+	if previous.State == RolloutStateUpdating && previous.LastTransitionTime != nil &&
+		now.Sub(previous.LastTransitionTime.Time) < delay {
+		setState(RolloutStateUpdating, "the OLM images are being updated")
+		return status, nil
+	}
+	if healthErr := c.agent.deployHealthCheck(c.workLister, cluster,
+		deployedRelease{OLMRelease: desired}); healthErr != nil {
+		setState(RolloutStateFailed, healthErr.Error())
+		return status, nil
+	}
+	setState(RolloutStateUpdated, "the OLM components run the configured images")
+	return status, nil
+}
+
+// pin sets the release of a cluster on its ManagedClusterAddOn.
+func (c *RolloutController) pin(ctx context.Context, key, cluster string, release v1alpha1.OLMRelease) error {
+	addon, err := c.addonLister.ManagedClusterAddOns(cluster).Get(c.agent.addonName)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(release)
+	if err != nil {
+		return err
+	}
+	if addon.Annotations[OLMReleaseAnnotation] == string(value) && addon.Annotations[OLMRolloutAnnotation] == key {
+		return nil
+	}
+	updated := addon.DeepCopy()
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	updated.Annotations[OLMReleaseAnnotation] = string(value)
+	updated.Annotations[OLMRolloutAnnotation] = key
+	_, err = c.agent.addonClient.AddonV1alpha1().ManagedClusterAddOns(cluster).Update(ctx, updated, metav1.UpdateOptions{})
+	return err
+}
+
+// unpin removes the release pinned by an OLMRollout on the clusters which are not kept.
+func (c *RolloutController) unpin(ctx context.Context, key string, keep []string) error {
+	kept := map[string]bool{}
+	for _, cluster := range keep {
+		kept[cluster] = true
+	}
+	addons, err := c.addonLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, addon := range addons {
+		if addon.Name != c.agent.addonName || addon.Annotations[OLMRolloutAnnotation] != key || kept[addon.Namespace] {
+			continue
+		}
+		updated := addon.DeepCopy()
+		delete(updated.Annotations, OLMReleaseAnnotation)
+		delete(updated.Annotations, OLMRolloutAnnotation)
+		_, err := c.agent.addonClient.AddonV1alpha1().ManagedClusterAddOns(addon.Namespace).Update(ctx, updated,
+			metav1.UpdateOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		klog.V(1).InfoS("Released the OLM images of the cluster", "olmRollout", key, "cluster", addon.Namespace)
+	}
+	return nil
+}
+
+// updateStatus updates the status of the OLMRollout if it changed.
+func (c *RolloutController) updateStatus(ctx context.Context, u *unstructured.Unstructured,
+	rollout *v1alpha1.OLMRollout, status v1alpha1.OLMRolloutStatus) error {
+	if equality.Semantic.DeepEqual(rollout.Status, status) {
+		return nil
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return err
+	}
+	updated := u.DeepCopy()
+	updated.Object["status"] = content
+	_, err = c.dynamicClient.Resource(v1alpha1.OLMRolloutGVR).Namespace(u.GetNamespace()).UpdateStatus(ctx,
+		updated, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,210 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	addonfake "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
+	clusterfake "open-cluster-management.io/api/client/cluster/clientset/versioned/fake"
+	workfake "open-cluster-management.io/api/client/work/clientset/versioned/fake"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	workapiv1 "open-cluster-management.io/api/work/v1"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stolostron/olm-addon/pkg/apis/v1alpha1"
+)
+
+var oldRelease = v1alpha1.OLMRelease{OLM: "quay.io/operator-framework/olm:v0.24.0",
+	ConfigMapServer: "quay.io/operator-framework/configmap-operator-registry:v1.27.0"}
+
+// pinnedAddon returns the ManagedClusterAddOn of a cluster with the release pinned by the OLMRollout fleet/olm.
+func pinnedAddon(cluster string, release v1alpha1.OLMRelease) *addonapiv1alpha1.ManagedClusterAddOn {
+	value, _ := json.Marshal(release)
+	return &addonapiv1alpha1.ManagedClusterAddOn{ObjectMeta: metav1.ObjectMeta{
+		Name:        "olm-addon",
+		Namespace:   cluster,
+		Annotations: map[string]string{OLMReleaseAnnotation: string(value), OLMRolloutAnnotation: "fleet/olm"},
+	}}
+}
+
+// rolloutWork returns the ManifestWork deploying the manifests of a cluster, applied and reporting
+// the given number of ready replicas for the OLM deployments.
+func rolloutWork(t *testing.T, agent *olmAgent, cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn, readyReplicas int64) *workapiv1.ManifestWork {
+	objects, err := agent.Manifests(cluster, addon)
+	require.NoError(t, err)
+	work := &workapiv1.ManifestWork{ObjectMeta: metav1.ObjectMeta{Name: "addon-olm-addon-deploy-0", Namespace: cluster.Name}}
+	for _, obj := range objects {
+		raw, err := json.Marshal(obj)
+		require.NoError(t, err)
+		work.Spec.Workload.Manifests = append(work.Spec.Workload.Manifests,
+			workapiv1.Manifest{RawExtension: runtime.RawExtension{Raw: raw}})
+	}
+	work.Status.Conditions = []metav1.Condition{{Type: workapiv1.WorkApplied, Status: metav1.ConditionTrue}}
+	for _, name := range []string{"olm-operator", "catalog-operator"} {
+		feedback := deploymentFeedback(1, readyReplicas)
+		updated := int64(1)
+		feedback.Values = append(feedback.Values, workapiv1.FeedbackValue{Name: "UpdatedReplicas",
+			Value: workapiv1.FieldValue{Type: workapiv1.Integer, Integer: &updated}})
+		work.Status.ResourceStatus.Manifests = append(work.Status.ResourceStatus.Manifests, workapiv1.ManifestCondition{
+			ResourceMeta:    workapiv1.ManifestResourceMeta{Group: "apps", Resource: "deployments", Name: name, Namespace: "olm"},
+			StatusFeedbacks: feedback,
+		})
+	}
+	work.Status.ResourceStatus.Manifests = append(work.Status.ResourceStatus.Manifests, workapiv1.ManifestCondition{
+		ResourceMeta: workapiv1.ManifestResourceMeta{Group: olmv1alpha1.GroupName, Resource: "clusterserviceversions",
+			Name: "packageserver", Namespace: "olm"},
+		StatusFeedbacks: csvFeedback("Succeeded", "InstallSucceeded", "install strategy completed with no errors"),
+	})
+	return work
+}
+
+func TestPinnedRelease(t *testing.T) {
+	for _, mode := range []RenderingMode{PatchRenderingMode, TemplateRenderingMode} {
+		t.Run(string(mode), func(t *testing.T) {
+			agent, _ := newTestAgent(t, mode)
+			addon := pinnedAddon("cluster1", oldRelease)
+			objects, err := agent.Manifests(newTestCluster("v1.27.3"), addon)
+			require.NoError(t, err)
+			require.Equal(t, oldRelease.OLM, deploymentImages(objects)["olm-operator"])
+
+			work := rolloutWork(t, agent, newTestCluster("v1.27.3"), addon, 1)
+			deployed := deployedRelease{OLMRelease: oldRelease, Manifests: "v1.27", Revision: agent.catalog.get().revision}
+			require.Equal(t, &deployed, workRelease(work, "olm"))
+			require.NoError(t, agent.rolloutHealthCheck(work, deployedRelease{OLMRelease: oldRelease}))
+			require.NoError(t, agent.rolloutHealthCheck(work, deployed))
+			require.ErrorContains(t, agent.rolloutHealthCheck(work, deployedRelease{
+				OLMRelease: v1alpha1.OLMRelease{OLM: "other", ConfigMapServer: "other"}}), "is not updated yet")
+			require.ErrorContains(t, agent.rolloutHealthCheck(work, deployedRelease{OLMRelease: oldRelease,
+				Manifests: "v1.26", Revision: deployed.Revision}), "is not updated yet")
+		})
+	}
+}
+
+func TestValidateOLMRollout(t *testing.T) {
+	require.NoError(t, validateOLMRollout(v1alpha1.OLMRolloutSpec{Placement: "all"}))
+	require.ErrorContains(t, validateOLMRollout(v1alpha1.OLMRolloutSpec{}), "placement is required")
+	require.ErrorContains(t, validateOLMRollout(v1alpha1.OLMRolloutSpec{Placement: "all", BatchSize: -1}), "invalid batchSize")
+	require.ErrorContains(t, validateOLMRollout(v1alpha1.OLMRolloutSpec{Placement: "all",
+		HealthCheckDelay: &metav1.Duration{}}), "invalid healthCheckDelay")
+}
+
+func TestRolloutControllerSync(t *testing.T) {
+	ctx := context.Background()
+	agent, _ := newTestAgent(t, PatchRenderingMode)
+	clusterNames := []string{"cluster1", "cluster2", "cluster3"}
+	addonObjects := []runtime.Object{}
+	clusters := map[string]*clusterv1.ManagedCluster{}
+	for _, name := range clusterNames {
+		addonObjects = append(addonObjects, pinnedAddon(name, oldRelease))
+		clusters[name] = newTestCluster("v1.27.3")
+		clusters[name].Name = name
+	}
+	addonClient := addonfake.NewSimpleClientset(addonObjects...)
+	agent.addonClient = addonClient
+	desired, err := agent.desiredRelease(clusters["cluster1"], addonObjects[0].(*addonapiv1alpha1.ManagedClusterAddOn))
+	require.NoError(t, err)
+	require.NotEqual(t, oldRelease, desired)
+
+	rollout := toUnstructured(t, &v1alpha1.OLMRollout{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "OLMRollout"},
+		ObjectMeta: metav1.ObjectMeta{Name: "olm", Namespace: "fleet"},
+		Spec:       v1alpha1.OLMRolloutSpec{Placement: "all"},
+	})
+	decision := &clusterv1beta1.PlacementDecision{
+		ObjectMeta: metav1.ObjectMeta{Name: "all-decision-1", Namespace: "fleet",
+			Labels: map[string]string{clusterv1beta1.PlacementLabel: "all"}},
+		Status: clusterv1beta1.PlacementDecisionStatus{Decisions: []clusterv1beta1.ClusterDecision{
+			{ClusterName: "cluster1"}, {ClusterName: "cluster2"}, {ClusterName: "cluster3"},
+		}},
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), rollout)
+	controller := NewRolloutController(agent, dynamicClient, clusterfake.NewSimpleClientset(decision),
+		workfake.NewSimpleClientset())
+	now := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	controller.now = func() time.Time { return now }
+	rolloutIndexer := controller.rolloutFactory.ForResource(v1alpha1.OLMRolloutGVR).Informer().GetIndexer()
+	addonIndexer := controller.addonFactory.Addon().V1alpha1().ManagedClusterAddOns().Informer().GetIndexer()
+	workIndexer := controller.workFactory.Work().V1().ManifestWorks().Informer().GetIndexer()
+	require.NoError(t, rolloutIndexer.Add(rollout))
+	require.NoError(t, controller.clusterFactory.Cluster().V1beta1().PlacementDecisions().Informer().GetIndexer().Add(decision))
+	for _, name := range clusterNames {
+		require.NoError(t, controller.clusterFactory.Cluster().V1().ManagedClusters().Informer().GetIndexer().Add(clusters[name]))
+	}
+
+	// sync refreshes the informer caches from the clients and syncs the OLMRollout
+	sync := func() *v1alpha1.OLMRollout {
+		addons, err := addonClient.AddonV1alpha1().ManagedClusterAddOns("").List(ctx, metav1.ListOptions{})
+		require.NoError(t, err)
+		for i := range addons.Items {
+			require.NoError(t, addonIndexer.Update(&addons.Items[i]))
+		}
+		u, err := dynamicClient.Resource(v1alpha1.OLMRolloutGVR).Namespace("fleet").Get(ctx, "olm", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.NoError(t, rolloutIndexer.Update(u))
+		require.NoError(t, controller.sync(ctx, "fleet/olm"))
+		u, err = dynamicClient.Resource(v1alpha1.OLMRolloutGVR).Namespace("fleet").Get(ctx, "olm", metav1.GetOptions{})
+		require.NoError(t, err)
+		result, err := decodeOLMRollout(u)
+		require.NoError(t, err)
+		return result
+	}
+	states := func(rollout *v1alpha1.OLMRollout) string {
+		result := ""
+		for _, cluster := range rollout.Status.Clusters {
+			result += fmt.Sprintf("%s=%s ", cluster.Cluster, cluster.State)
+		}
+		return result
+	}
+	addon := func(cluster string) *addonapiv1alpha1.ManagedClusterAddOn {
+		addon, err := addonClient.AddonV1alpha1().ManagedClusterAddOns(cluster).Get(ctx, "olm-addon", metav1.GetOptions{})
+		require.NoError(t, err)
+		return addon
+	}
+
+	result := sync()
+	require.Equal(t, "cluster1=Updating cluster2=Pending cluster3=Pending ", states(result))
+	require.Equal(t, ReasonRollingOut, result.Status.Conditions[0].Reason)
+	pinned, err := pinnedRelease(addon("cluster1"))
+	require.NoError(t, err)
+	require.Equal(t, desired, *pinned, "the first cluster is updated")
+	pinned, err = pinnedRelease(addon("cluster2"))
+	require.NoError(t, err)
+	require.Equal(t, oldRelease, *pinned)
+
+	// the cluster stays updating until the health check delay is over
+	require.NoError(t, workIndexer.Add(rolloutWork(t, agent, clusters["cluster1"], addon("cluster1"), 1)))
+	now = now.Add(time.Minute)
+	require.Equal(t, "cluster1=Updating cluster2=Pending cluster3=Pending ", states(sync()))
+
+	now = now.Add(5 * time.Minute)
+	require.Equal(t, "cluster1=Updated cluster2=Updating cluster3=Pending ", states(sync()))
+
+	// the rollout halts when an updated cluster fails the health check
+	require.NoError(t, workIndexer.Add(rolloutWork(t, agent, clusters["cluster2"], addon("cluster2"), 0)))
+	now = now.Add(6 * time.Minute)
+	result = sync()
+	require.Equal(t, "cluster1=Updated cluster2=Failed cluster3=Pending ", states(result))
+	require.Equal(t, ReasonRolloutHalted, result.Status.Conditions[0].Reason)
+	require.Contains(t, result.Status.Clusters[1].Message, "OLMOperatorAvailable")
+	pinned, err = pinnedRelease(addon("cluster3"))
+	require.NoError(t, err)
+	require.Equal(t, oldRelease, *pinned, "the last cluster is not updated")
+
+	// the clusters are released when the OLMRollout is deleted
+	require.NoError(t, rolloutIndexer.Delete(rollout))
+	require.NoError(t, controller.sync(ctx, "fleet/olm"))
+	for _, name := range clusterNames {
+		require.NotContains(t, addon(name).Annotations, OLMReleaseAnnotation)
+	}
+}
@@ -0,0 +1,499 @@
+package manager
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"k8s.io/klog/v2"
+)
+
+// ManifestSource provides the manifest sets deployed by the addon.
+type ManifestSource interface {
+	// Manifests returns a file system with the compatibility matrix and
+	// a directory per manifest set at its root.
+	Manifests(ctx context.Context) (fs.FS, error)
+}
+
+// ManifestWatcher is implemented by the manifest sources whose content can change while the addon is running.
+type ManifestWatcher interface {
+	// Watch calls changed whenever the manifests may have changed until the context is done.
+	Watch(ctx context.Context, changed func())
+}
+
+const (
+	embeddedSourceType  = "embedded"
+	dirSourceType       = "dir"
+	configMapSourceType = "configmap"
+	ociSourceType       = "oci"
+
+	// configMapArchiveKey is the binaryData key of the ConfigMap containing the manifest archive.
+	configMapArchiveKey = "manifests.tar.gz"
+	// manifestsLayerMediaType is the media type of the OCI artifact layer containing the manifest archive.
+	manifestsLayerMediaType = "application/vnd.olm-addon.manifests.v1.tar+gzip"
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+
+	// manifestsRoot is the directory of the embedded manifests.
+	manifestsRoot = "manifests"
+
+	// DefaultManifestSourceTimeout bounds the retrieval of the manifests from a ConfigMap or an OCI registry.
+	DefaultManifestSourceTimeout = 2 * time.Minute
+	// DefaultManifestRefreshInterval is the interval between two resolutions of the tag of an OCI manifest source.
+	DefaultManifestRefreshInterval = 5 * time.Minute
+	// configMapResync is the interval at which the ConfigMap manifest source is checked again without changes.
+	configMapResync = 10 * time.Minute
+	// maxManifestsSize limits the size of downloaded manifest archives as well as the size of their content
+	// once extracted, so that a compression bomb cannot fill the disk.
+	maxManifestsSize = 64 << 20
+	// digestDirPrefix is the prefix of the cache directories containing extracted archives.
+	digestDirPrefix = "sha256-"
+	// extractDirPrefix is the prefix of the temporary directories archives are extracted into.
+	extractDirPrefix = "extract-"
+)
+
+// ManifestSourceOptions contains the settings shared by the different source types.
+type ManifestSourceOptions struct {
+	// Embedded contains the manifests bundled with the addon binary.
+	Embedded embed.FS
+	// KubeClient is used to retrieve the manifests from a ConfigMap in the hub.
+	KubeClient kubernetes.Interface
+	// Digest is the expected sha256 digest of the manifest archive, e.g. sha256:abc...
+	Digest string
+	// CacheDir is where manifest archives get extracted, one directory per digest.
+	CacheDir string
+	// InsecureRegistry allows pulling OCI artifacts over plain http.
+	InsecureRegistry bool
+	// Timeout bounds the retrieval of the manifests from a ConfigMap or an OCI registry.
+	// DefaultManifestSourceTimeout is used when not set.
+	Timeout time.Duration
+	// RefreshInterval is the interval between two resolutions of the tag of an OCI manifest source.
+	// DefaultManifestRefreshInterval is used when not set.
+	RefreshInterval time.Duration
+}
+
+// ParseManifestSource returns the manifest source described by spec, which takes the form:
+//   - embedded: manifests bundled with the addon binary
+//   - dir:<path>: local directory
+//   - configmap:<namespace>/<name>: gzipped tarball stored in the ConfigMap under the manifests.tar.gz key
+//   - oci:<registry>/<repository>[:<tag>|@<digest>]: OCI artifact with a gzipped tarball layer
+func ParseManifestSource(spec string, opts ManifestSourceOptions) (ManifestSource, error) {
+	sourceType, location, _ := strings.Cut(spec, ":")
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultManifestSourceTimeout
+	}
+	refreshInterval := opts.RefreshInterval
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultManifestRefreshInterval
+	}
+	switch sourceType {
+	case embeddedSourceType:
+		return &embeddedSource{manifests: opts.Embedded}, nil
+	case dirSourceType:
+		if location == "" {
+			return nil, fmt.Errorf("missing directory in manifest source %q", spec)
+		}
+		return &dirSource{dir: location}, nil
+	case configMapSourceType:
+		namespace, name, ok := strings.Cut(location, "/")
+		if !ok || namespace == "" || name == "" {
+			return nil, fmt.Errorf("manifest source %q does not follow the format configmap:<namespace>/<name>", spec)
+		}
+		if opts.KubeClient == nil {
+			return nil, fmt.Errorf("a kube client is required for the manifest source %q", spec)
+		}
+		return &configMapSource{
+			client:    opts.KubeClient,
+			namespace: namespace,
+			name:      name,
+			timeout:   timeout,
+			cache:     archiveCache{dir: opts.CacheDir, digest: opts.Digest, maxSize: maxManifestsSize},
+		}, nil
+	case ociSourceType:
+		ref, err := parseOCIReference(location)
+		if err != nil {
+			return nil, err
+		}
+		scheme := "https"
+		if opts.InsecureRegistry {
+			scheme = "http"
+		}
+		return &ociSource{
+			ref:             ref,
+			scheme:          scheme,
+			client:          &http.Client{Timeout: timeout},
+			refreshInterval: refreshInterval,
+			cache:           archiveCache{dir: opts.CacheDir, digest: opts.Digest, maxSize: maxManifestsSize},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown manifest source type %q, supported types are: %s, %s, %s, %s", sourceType,
+			embeddedSourceType, dirSourceType, configMapSourceType, ociSourceType)
+	}
+}
+
+// embeddedSource serves the manifests bundled with the addon binary.
+type embeddedSource struct {
+	manifests embed.FS
+}
+
+func (s *embeddedSource) Manifests(_ context.Context) (fs.FS, error) {
+	return fs.Sub(s.manifests, manifestsRoot)
+}
+
+// dirSource serves manifests from a local directory.
+type dirSource struct {
+	dir string
+}
+
+func (s *dirSource) Manifests(_ context.Context) (fs.FS, error) {
+	if _, err := os.Stat(s.dir); err != nil {
+		return nil, err
+	}
+	return os.DirFS(s.dir), nil
+}
+
+// configMapSource serves manifests from a ConfigMap in the hub.
+type configMapSource struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	timeout   time.Duration
+	cache     archiveCache
+}
+
+func (s *configMapSource) Manifests(ctx context.Context) (fs.FS, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	archive, ok := cm.BinaryData[configMapArchiveKey]
+	if !ok {
+		return nil, fmt.Errorf("ConfigMap %s/%s has no %s binary data", s.namespace, s.name, configMapArchiveKey)
+	}
+	return s.cache.extract(digestOf(archive), archive)
+}
+
+// Watch calls changed when the ConfigMap gets created or updated and at every resync.
+func (s *configMapSource) Watch(ctx context.Context, changed func()) {
+	factory := informers.NewSharedInformerFactoryWithOptions(s.client, configMapResync,
+		informers.WithNamespace(s.namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", s.name).String()
+		}))
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	// the reloads are serialized so that an older content never replaces a newer one
+	queue := workqueue.New()
+	defer queue.ShutDown()
+	enqueue := func(interface{}) { queue.Add(s.name) }
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+	})
+	factory.Start(ctx.Done())
+	go func() {
+		<-ctx.Done()
+		queue.ShutDown()
+	}()
+	for {
+		key, shutdown := queue.Get()
+		if shutdown {
+			return
+		}
+		changed()
+		queue.Done(key)
+	}
+}
+
+// ociReference identifies an artifact in an OCI registry.
+type ociReference struct {
+	registry   string
+	repository string
+	// reference is either a tag or a digest
+	reference string
+}
+
+func parseOCIReference(location string) (ociReference, error) {
+	registry, repository, ok := strings.Cut(location, "/")
+	if !ok || registry == "" || repository == "" {
+		return ociReference{}, fmt.Errorf("OCI reference %q does not follow the format <registry>/<repository>[:<tag>|@<digest>]", location)
+	}
+	ref := ociReference{registry: registry, repository: repository, reference: "latest"}
+	if name, digest, ok := strings.Cut(repository, "@"); ok {
+		ref.repository, ref.reference = name, digest
+	} else if i := strings.LastIndex(repository, ":"); i != -1 {
+		ref.repository, ref.reference = repository[:i], repository[i+1:]
+	}
+	return ref, nil
+}
+
+// ociSource serves manifests from an artifact pulled from an OCI registry.
+// Only anonymous pulls are currently supported.
+type ociSource struct {
+	ref    ociReference
+	scheme string
+	client *http.Client
+	// refreshInterval is the interval between two resolutions of the tag
+	refreshInterval time.Duration
+	cache           archiveCache
+}
+
+// ociManifest contains the fields of an OCI image manifest relevant to the addon.
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+}
+
+func (s *ociSource) Manifests(ctx context.Context) (fs.FS, error) {
+	manifestContent, err := s.get(ctx, "manifests", s.ref.reference, ociManifestMediaType)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(s.ref.reference, "sha256:") && digestOf(manifestContent) != s.ref.reference {
+		return nil, fmt.Errorf("digest of the OCI manifest %s does not match the reference %s",
+			digestOf(manifestContent), s.ref.reference)
+	}
+	manifest := ociManifest{}
+	if err := json.Unmarshal(manifestContent, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid OCI manifest: %w", err)
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != manifestsLayerMediaType {
+			continue
+		}
+		if manifests, err := s.cache.cached(layer.Digest); err == nil {
+			klog.V(1).InfoS("Using cached manifests", "reference", s.ref.reference, "digest", layer.Digest)
+			return manifests, nil
+		}
+		archive, err := s.get(ctx, "blobs", layer.Digest, "")
+		if err != nil {
+			return nil, err
+		}
+		if digestOf(archive) != layer.Digest {
+			return nil, fmt.Errorf("digest of the downloaded layer %s does not match the descriptor %s",
+				digestOf(archive), layer.Digest)
+		}
+		return s.cache.extract(layer.Digest, archive)
+	}
+	return nil, fmt.Errorf("no layer with media type %s in the OCI artifact %s/%s:%s", manifestsLayerMediaType,
+		s.ref.registry, s.ref.repository, s.ref.reference)
+}
+
+// Watch calls changed at every refresh interval so that the tag gets resolved again.
+// The content referenced by a digest never changes and is not watched.
+func (s *ociSource) Watch(ctx context.Context, changed func()) {
+	if strings.HasPrefix(s.ref.reference, "sha256:") {
+		return
+	}
+	ticker := time.NewTicker(s.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed()
+		}
+	}
+}
+
+// get retrieves a manifest or a blob through the registry HTTP API.
+func (s *ociSource) get(ctx context.Context, kind, reference, accept string) ([]byte, error) {
+	url := fmt.Sprintf("%s://%s/v2/%s/%s/%s", s.scheme, s.ref.registry, s.ref.repository, kind, reference)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s for %s", resp.Status, url)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, s.cache.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > s.cache.maxSize {
+		return nil, fmt.Errorf("content of %s exceeds the maximum size of %d bytes", url, s.cache.maxSize)
+	}
+	return content, nil
+}
+
+// archiveCache extracts manifest archives into a directory per digest
+// so that they are only extracted once. Only the directory of the last digest used is kept.
+type archiveCache struct {
+	dir string
+	// digest, when set, is the only digest accepted for archives
+	digest string
+	// maxSize is the maximum size of the archives and of their extracted content
+	maxSize int64
+}
+
+// cached returns the manifests previously extracted for the digest.
+func (c archiveCache) cached(digest string) (fs.FS, error) {
+	if err := c.verify(digest); err != nil {
+		return nil, err
+	}
+	dir := c.digestDir(digest)
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	c.prune(digest)
+	return os.DirFS(dir), nil
+}
+
+// extract unpacks the archive after having verified its digest.
+func (c archiveCache) extract(digest string, archive []byte) (fs.FS, error) {
+	if manifests, err := c.cached(digest); err == nil {
+		return manifests, nil
+	}
+	if err := c.verify(digest); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(c.dir, 0o750); err != nil {
+		return nil, err
+	}
+	tmpDir, err := os.MkdirTemp(c.dir, extractDirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := untar(archive, tmpDir, c.maxSize); err != nil {
+		return nil, err
+	}
+	dir := c.digestDir(digest)
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return nil, err
+	}
+	klog.InfoS("Manifests extracted", "digest", digest, "directory", dir)
+	c.prune(digest)
+	return os.DirFS(dir), nil
+}
+
+// digestDir returns the directory the archive with the digest gets extracted into.
+func (c archiveCache) digestDir(digest string) string {
+	return filepath.Join(c.dir, strings.Replace(digest, ":", "-", 1))
+}
+
+// prune removes the archives extracted for other digests and the leftovers of interrupted extractions.
+// Failures are only logged as they do not prevent the current manifests from being used.
+func (c archiveCache) prune(digest string) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		klog.ErrorS(err, "Not able to list the manifest cache", "directory", c.dir)
+		return
+	}
+	current := filepath.Base(c.digestDir(digest))
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == current || !entry.IsDir() ||
+			!(strings.HasPrefix(name, digestDirPrefix) || strings.HasPrefix(name, extractDirPrefix)) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(c.dir, name)); err != nil {
+			klog.ErrorS(err, "Not able to prune the manifest cache", "directory", filepath.Join(c.dir, name))
+			continue
+		}
+		klog.V(1).InfoS("Pruned cached manifests", "directory", filepath.Join(c.dir, name))
+	}
+}
+
+func (c archiveCache) verify(digest string) error {
+	hexDigest := strings.TrimPrefix(digest, "sha256:")
+	if _, err := hex.DecodeString(hexDigest); !strings.HasPrefix(digest, "sha256:") || err != nil ||
+		len(hexDigest) != sha256.Size*2 {
+		return fmt.Errorf("invalid digest %q, expected sha256:<hex>", digest)
+	}
+	if c.digest != "" && c.digest != digest {
+		return fmt.Errorf("digest %s of the manifests does not match the expected digest %s", digest, c.digest)
+	}
+	return nil
+}
+
+// untar extracts the regular files and directories of a gzipped tarball into dir.
+// Extraction fails when the files are in total larger than maxSize.
+func untar(archive []byte, dir string, maxSize int64) error {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	reader := tar.NewReader(gz)
+	remaining := maxSize
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(header.Name, "./")
+		if name == "" || name == "." {
+			continue
+		}
+		if !fs.ValidPath(strings.TrimSuffix(name, "/")) {
+			return fmt.Errorf("invalid path %q in the manifest archive", header.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o750); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+				return err
+			}
+			content, err := io.ReadAll(io.LimitReader(reader, remaining+1))
+			if err != nil {
+				return err
+			}
+			remaining -= int64(len(content))
+			if remaining < 0 {
+				return fmt.Errorf("content of the manifest archive exceeds the maximum size of %d bytes", maxSize)
+			}
+			if err := os.WriteFile(target, content, 0o640); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// digestOf returns the sha256 digest of the content in the OCI format.
+func digestOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,226 @@
+package manager
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testArchive returns a gzipped tarball with the provided files.
+func testArchive(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0o644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+var testArchiveFiles = map[string]string{
+	"compatibility.yaml":     "version: v1\n",
+	"v1.27/olm.yaml":         "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: olm\n",
+	"v1.27/cleanup.yaml":     "",
+	"v1.27/crds.yaml":        "",
+	"v1.27/permissions.yaml": "",
+}
+
+func requireArchiveContent(t *testing.T, manifests fs.FS) {
+	for name, content := range testArchiveFiles {
+		actual, err := fs.ReadFile(manifests, name)
+		require.NoError(t, err)
+		require.Equal(t, content, string(actual))
+	}
+}
+
+func TestConfigMapSource(t *testing.T) {
+	archive := testArchive(t, testArchiveFiles)
+	client := kubefake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "olm-manifests", Namespace: "open-cluster-management"},
+		BinaryData: map[string][]byte{configMapArchiveKey: archive},
+	})
+	tests := []struct {
+		name        string
+		digest      string
+		expectError bool
+	}{
+		{"no digest", "", false},
+		{"matching digest", digestOf(archive), false},
+		{"digest mismatch", digestOf([]byte("other")), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := ParseManifestSource("configmap:open-cluster-management/olm-manifests", ManifestSourceOptions{
+				KubeClient: client,
+				Digest:     tt.digest,
+				CacheDir:   t.TempDir(),
+			})
+			require.NoError(t, err)
+			manifests, err := source.Manifests(context.Background())
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			requireArchiveContent(t, manifests)
+		})
+	}
+}
+
+// testRegistry is a minimal OCI registry serving a single artifact.
+func testRegistry(t *testing.T, layer []byte) (*httptest.Server, *int) {
+	manifest, err := json.Marshal(ociManifest{
+		MediaType: ociManifestMediaType,
+		Layers: []ociDescriptor{
+			{MediaType: "application/vnd.oci.image.config.v1+json", Digest: digestOf([]byte("{}"))},
+			{MediaType: manifestsLayerMediaType, Digest: digestOf(layer)},
+		},
+	})
+	require.NoError(t, err)
+	blobPulls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/olm/manifests/manifests/v0.25.0", fmt.Sprintf("/v2/olm/manifests/manifests/%s", digestOf(manifest)):
+			w.Header().Set("Content-Type", ociManifestMediaType)
+			_, _ = w.Write(manifest)
+		case fmt.Sprintf("/v2/olm/manifests/blobs/%s", digestOf(layer)):
+			blobPulls++
+			_, _ = w.Write(layer)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &blobPulls
+}
+
+func TestOCISource(t *testing.T) {
+	archive := testArchive(t, testArchiveFiles)
+	server, blobPulls := testRegistry(t, archive)
+	registry := strings.TrimPrefix(server.URL, "http://")
+	cacheDir := t.TempDir()
+	opts := ManifestSourceOptions{CacheDir: cacheDir, InsecureRegistry: true}
+
+	source, err := ParseManifestSource("oci:"+registry+"/olm/manifests:v0.25.0", opts)
+	require.NoError(t, err)
+	manifests, err := source.Manifests(context.Background())
+	require.NoError(t, err)
+	requireArchiveContent(t, manifests)
+	require.Equal(t, 1, *blobPulls)
+
+	// the layer is served from the cache the second time
+	manifests, err = source.Manifests(context.Background())
+	require.NoError(t, err)
+	requireArchiveContent(t, manifests)
+	require.Equal(t, 1, *blobPulls)
+
+	// the expected digest is enforced
+	opts.Digest = digestOf([]byte("other"))
+	source, err = ParseManifestSource("oci:"+registry+"/olm/manifests:v0.25.0", opts)
+	require.NoError(t, err)
+	_, err = source.Manifests(context.Background())
+	require.Error(t, err)
+
+	// a digest reference needs to match the manifest
+	source, err = ParseManifestSource("oci:"+registry+"/olm/manifests@"+digestOf([]byte("other")), ManifestSourceOptions{
+		CacheDir:         cacheDir,
+		InsecureRegistry: true,
+	})
+	require.NoError(t, err)
+	_, err = source.Manifests(context.Background())
+	require.Error(t, err)
+}
+
+func TestUntarRejectsPathTraversal(t *testing.T) {
+	archive := testArchive(t, map[string]string{"../evil.yaml": "kind: Namespace"})
+	err := untar(archive, t.TempDir(), maxManifestsSize)
+	require.Error(t, err)
+}
+
+func TestUntarSizeLimit(t *testing.T) {
+	archive := testArchive(t, map[string]string{"v1.27/olm.yaml": strings.Repeat("0", 1<<20)})
+	require.Less(t, len(archive), 1<<12, "the archive is expected to be highly compressed")
+	err := untar(archive, t.TempDir(), 1<<12)
+	require.ErrorContains(t, err, "exceeds the maximum size")
+	require.NoError(t, untar(archive, t.TempDir(), 1<<20))
+}
+
+func TestArchiveCachePrune(t *testing.T) {
+	cache := archiveCache{dir: t.TempDir(), maxSize: maxManifestsSize}
+	first := testArchive(t, testArchiveFiles)
+	_, err := cache.extract(digestOf(first), first)
+	require.NoError(t, err)
+	require.NoError(t, os.Mkdir(filepath.Join(cache.dir, "extract-123"), 0o750))
+	require.NoError(t, os.Mkdir(filepath.Join(cache.dir, "unrelated"), 0o750))
+
+	second := testArchive(t, map[string]string{"compatibility.yaml": "version: v1\n"})
+	_, err = cache.extract(digestOf(second), second)
+	require.NoError(t, err)
+	entries, err := os.ReadDir(cache.dir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.ElementsMatch(t, []string{filepath.Base(cache.digestDir(digestOf(second))), "unrelated"}, names)
+}
+
+func TestOCISourceTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	source, err := ParseManifestSource("oci:"+strings.TrimPrefix(server.URL, "http://")+"/olm/manifests:v0.25.0",
+		ManifestSourceOptions{CacheDir: t.TempDir(), InsecureRegistry: true, Timeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+	_, err = source.Manifests(context.Background())
+	require.ErrorContains(t, err, "Client.Timeout")
+}
+
+func TestParseOCIReference(t *testing.T) {
+	tests := []struct {
+		location  string
+		expected  ociReference
+		expectErr bool
+	}{
+		{"localhost:5000/olm/manifests:v0.25.0", ociReference{"localhost:5000", "olm/manifests", "v0.25.0"}, false},
+		{"quay.io/olm/manifests", ociReference{"quay.io", "olm/manifests", "latest"}, false},
+		{"quay.io/olm/manifests@sha256:abc", ociReference{"quay.io", "olm/manifests", "sha256:abc"}, false},
+		{"manifests", ociReference{}, true},
+	}
+	for _, tt := range tests {
+		ref, err := parseOCIReference(tt.location)
+		if tt.expectErr {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tt.expected, ref)
+	}
+}
@@ -0,0 +1,120 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/klog/v2"
+
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+)
+
+const (
+	// ConditionManifestSetSelected reports the manifest set picked for the Kubernetes version of the cluster.
+	ConditionManifestSetSelected = "ManifestSetSelected"
+	// ConditionInstallSkipped reports that the addon intentionally deploys nothing on the cluster.
+	ConditionInstallSkipped = "InstallSkipped"
+
+	ReasonOLMDeployed = "OLMDeployed"
+	// EventReasonInstallSkipped is the reason of the hub events recorded when the installation gets skipped.
+	EventReasonInstallSkipped = "InstallSkipped"
+
+	// statusUpdateTimeout bounds the requests made to the hub for updating the ManagedClusterAddOn status.
+	statusUpdateTimeout = 10 * time.Second
+)
+
+// updateConditions sets the conditions on the ManagedClusterAddOn status if any of them changed.
+// Failures are logged but not returned so that status reporting does not prevent
+// the manifests from being rendered.
+func (o *olmAgent) updateConditions(addon *addonapiv1alpha1.ManagedClusterAddOn, conditions ...metav1.Condition) {
+	if addon == nil || o.addonClient == nil {
+		return
+	}
+	changed := []string{}
+	for _, condition := range conditions {
+		if existing := meta.FindStatusCondition(addon.Status.Conditions, condition.Type); existing == nil ||
+			existing.Status != condition.Status || existing.Reason != condition.Reason || existing.Message != condition.Message {
+			changed = append(changed, condition.Type)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), statusUpdateTimeout)
+	defer cancel()
+	latest, err := o.addonClient.AddonV1alpha1().ManagedClusterAddOns(addon.Namespace).Get(ctx, addon.Name, metav1.GetOptions{})
+	if err != nil {
+		klog.ErrorS(err, "Not able to retrieve the ManagedClusterAddOn for updating its status", "cluster", addon.Namespace,
+			"conditions", changed)
+		return
+	}
+	latest = latest.DeepCopy()
+	for _, condition := range conditions {
+		meta.SetStatusCondition(&latest.Status.Conditions, condition)
+	}
+	if _, err := o.addonClient.AddonV1alpha1().ManagedClusterAddOns(addon.Namespace).UpdateStatus(ctx, latest, metav1.UpdateOptions{}); err != nil {
+		klog.ErrorS(err, "Not able to update the ManagedClusterAddOn status", "cluster", addon.Namespace,
+			"conditions", changed)
+	}
+}
+
+// removeConditions removes the conditions from the ManagedClusterAddOn status if any of them is set.
+// Failures are logged but not returned as for updateConditions.
+func (o *olmAgent) removeConditions(addon *addonapiv1alpha1.ManagedClusterAddOn, conditionTypes ...string) {
+	if addon == nil || o.addonClient == nil {
+		return
+	}
+	set := []string{}
+	for _, conditionType := range conditionTypes {
+		if meta.FindStatusCondition(addon.Status.Conditions, conditionType) != nil {
+			set = append(set, conditionType)
+		}
+	}
+	if len(set) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), statusUpdateTimeout)
+	defer cancel()
+	latest, err := o.addonClient.AddonV1alpha1().ManagedClusterAddOns(addon.Namespace).Get(ctx, addon.Name, metav1.GetOptions{})
+	if err != nil {
+		klog.ErrorS(err, "Not able to retrieve the ManagedClusterAddOn for updating its status", "cluster", addon.Namespace,
+			"conditions", set)
+		return
+	}
+	latest = latest.DeepCopy()
+	for _, conditionType := range set {
+		meta.RemoveStatusCondition(&latest.Status.Conditions, conditionType)
+	}
+	if _, err := o.addonClient.AddonV1alpha1().ManagedClusterAddOns(addon.Namespace).UpdateStatus(ctx, latest, metav1.UpdateOptions{}); err != nil {
+		klog.ErrorS(err, "Not able to update the ManagedClusterAddOn status", "cluster", addon.Namespace,
+			"conditions", set)
+	}
+}
+
+// reportInstall sets the InstallSkipped condition of the ManagedClusterAddOn. A hub event is recorded
+// when the installation gets skipped or skipped for another reason, not on every rendering of the manifests.
+func (o *olmAgent) reportInstall(addon *addonapiv1alpha1.ManagedClusterAddOn, skipped bool, reason, message string) {
+	if addon == nil {
+		return
+	}
+	condition := metav1.Condition{
+		Type:    ConditionInstallSkipped,
+		Status:  metav1.ConditionFalse,
+		Reason:  reason,
+		Message: message,
+	}
+	if skipped {
+		condition.Status = metav1.ConditionTrue
+		previous := meta.FindStatusCondition(addon.Status.Conditions, ConditionInstallSkipped)
+		if o.recorder != nil && (previous == nil || previous.Status != metav1.ConditionTrue || previous.Reason != reason) {
+			o.recorder.Event(addon, corev1.EventTypeNormal, EventReasonInstallSkipped,
+				fmt.Sprintf("OLM is not deployed on cluster %s (%s): %s", addon.Namespace, reason, message))
+		}
+	}
+	o.updateConditions(addon, condition)
+}
@@ -0,0 +1,119 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+)
+
+// RenderingMode defines how the configuration of the AddOnDeploymentConfig gets applied to the manifests.
+type RenderingMode string
+
+const (
+	// PatchRenderingMode renders the manifest templates with default values
+	// and patches the resulting objects with the configuration.
+	PatchRenderingMode RenderingMode = "patch"
+	// TemplateRenderingMode renders the manifest templates with the configuration.
+	TemplateRenderingMode RenderingMode = "template"
+)
+
+// ParseRenderingMode validates the rendering mode provided by users.
+func ParseRenderingMode(mode string) (RenderingMode, error) {
+	switch m := RenderingMode(strings.ToLower(mode)); m {
+	case PatchRenderingMode, TemplateRenderingMode:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown rendering mode %q, supported values are: %s, %s",
+			mode, PatchRenderingMode, TemplateRenderingMode)
+	}
+}
+
+// manifestFile is a file of a manifest set, which may be a Go template.
+type manifestFile struct {
+	name string
+	// template is nil when the file does not contain any template action
+	template *template.Template
+	// objects are the objects of the file rendered with the default values
+	objects []runtime.Object
+}
+
+var templateFuncs = template.FuncMap{
+	"toJson": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// defaultValues returns the values used for rendering the manifest templates of a set
+// when they are not overridden by customized variables of the AddOnDeploymentConfig.
+func defaultValues(set manifestSet) addonfactory.Values {
+	namespaces := set.namespaces
+	if namespaces == (Namespaces{}) {
+		namespaces = DefaultNamespaces()
+	}
+	values := addonfactory.Values{
+		"OLMNamespace":               namespaces.OLM,
+		"OperatorsNamespace":         namespaces.Operators,
+		"OLMImage":                   set.images.OLM,
+		"ConfigMapServerImage":       set.images.ConfigMapServer,
+		"NodeSelector":               map[string]string{"kubernetes.io/os": "linux"},
+		"Tolerations":                []corev1.Toleration(nil),
+		"PriorityClassName":          "",
+		"HAMode":                     false,
+		"PackageServerReplicas":      defaultPackageServerReplicas,
+		"LeaderElection":             false,
+		"OperatorReplicas":           defaultOperatorReplicas,
+		"DefaultCatalogDisabled":     false,
+		"DefaultCatalogImage":        defaultCatalogImage,
+		"DefaultCatalogDisplayName":  defaultCatalogDisplayName,
+		"DefaultCatalogPublisher":    defaultCatalogPublisher,
+		"DefaultCatalogPollInterval": defaultCatalogPollInterval,
+	}
+	for component := range componentVariablePrefixes {
+		values[resourcesValueName(component)] = defaultResources[component]
+		values[affinityValueName(component)] = (*corev1.Affinity)(nil)
+		values[topologySpreadValueName(component)] = []corev1.TopologySpreadConstraint(nil)
+	}
+	return values
+}
+
+// loadManifestFile parses a file of a manifest set. Files containing template actions
+// are rendered with the default values of the set before being decoded.
+func loadManifestFile(manifests fs.FS, set manifestSet, name string) (manifestFile, error) {
+	file := path.Join(set.dir, name)
+	content, err := fs.ReadFile(manifests, file)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	if !bytes.Contains(content, []byte("{{")) {
+		objects, err := decodeManifests(file, content)
+		return manifestFile{name: file, objects: objects}, err
+	}
+	tmpl, err := template.New(file).Funcs(templateFuncs).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return manifestFile{}, err
+	}
+	objects, err := renderManifests(tmpl, defaultValues(set))
+	if err != nil {
+		return manifestFile{}, err
+	}
+	return manifestFile{name: file, template: tmpl, objects: objects}, nil
+}
+
+// renderManifests executes the template with the values and decodes the result.
+func renderManifests(tmpl *template.Template, values addonfactory.Values) ([]runtime.Object, error) {
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, map[string]interface{}(values)); err != nil {
+		return nil, err
+	}
+	return decodeManifests(tmpl.Name(), buf.Bytes())
+}
@@ -0,0 +1,115 @@
+package manager
+
+import (
+	"testing"
+	"testing/fstest"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRenderingMode(t *testing.T) {
+	mode, err := ParseRenderingMode("Template")
+	require.NoError(t, err)
+	require.Equal(t, TemplateRenderingMode, mode)
+	_, err = ParseRenderingMode("helm")
+	require.ErrorContains(t, err, "unknown rendering mode")
+}
+
+func TestTemplateDefaultsMatchPatchMode(t *testing.T) {
+	patchAgent, addon := newTestAgent(t, PatchRenderingMode)
+	templateAgent, _ := newTestAgent(t, TemplateRenderingMode)
+	for _, kubeVersion := range []string{"v1.23.0", "v1.24.0", "v1.25.0", "v1.26.0", "v1.27.0"} {
+		cluster := newTestCluster(kubeVersion)
+		expected, err := patchAgent.Manifests(cluster, addon)
+		require.NoError(t, err)
+		objects, err := templateAgent.Manifests(cluster, addon)
+		require.NoError(t, err)
+		require.Equal(t, expected, objects, "rendering of %s", kubeVersion)
+	}
+}
+
+func TestTemplateRendering(t *testing.T) {
+	agent, addon := newTestAgent(t, TemplateRenderingMode,
+		addonapiv1alpha1.CustomizedVariable{Name: "OLMImage", Value: testOLMImage},
+		addonapiv1alpha1.CustomizedVariable{Name: "ConfigMapServerImage", Value: testConfigMapServerImage},
+	)
+	objects, err := agent.Manifests(newTestCluster("v1.27.3"), addon)
+	require.NoError(t, err)
+	for name, image := range deploymentImages(objects) {
+		require.Equal(t, testOLMImage, image, "image of %s not configured", name)
+	}
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *appsv1.Deployment:
+			if o.Name != "catalog-operator" {
+				continue
+			}
+			container := o.Spec.Template.Spec.Containers[0]
+			require.Equal(t, "olm", argValue(container.Args, "--namespace"))
+			require.Equal(t, testOLMImage, argValue(container.Args, "--util-image"))
+			require.Equal(t, testConfigMapServerImage, argValue(container.Args, "--configmapServerImage"))
+		case *olmv1alpha1.ClusterServiceVersion:
+			deployment := o.Spec.InstallStrategy.StrategySpec.DeploymentSpecs[0]
+			require.Equal(t, testOLMImage, deployment.Spec.Template.Spec.Containers[0].Image)
+			require.Equal(t, map[string]string{"kubernetes.io/os": "linux"}, deployment.Spec.Template.Spec.NodeSelector)
+			require.Empty(t, deployment.Spec.Template.Spec.Tolerations)
+		}
+	}
+	for _, file := range agent.catalog.get().files["v1.27"] {
+		for name, image := range deploymentImages(file.objects) {
+			require.NotEqual(t, testOLMImage, image, "cached %s modified", name)
+		}
+	}
+}
+
+func TestTemplateRenderingQuotesValues(t *testing.T) {
+	values := []string{
+		"true",
+		"1234",
+		"quay.io/olm:v1\n          securityContext:\n            privileged: true",
+		"quay.io/olm:v1\nprivileged: true",
+		"{{ .OLMImage }}",
+		"\"quoted\" 'value'",
+	}
+	for _, value := range values {
+		agent, addon := newTestAgent(t, TemplateRenderingMode,
+			addonapiv1alpha1.CustomizedVariable{Name: "OLMImage", Value: value},
+			addonapiv1alpha1.CustomizedVariable{Name: "ConfigMapServerImage", Value: value},
+		)
+		objects, err := agent.Manifests(newTestCluster("v1.27.3"), addon)
+		require.NoError(t, err, "rendering of %q", value)
+		cached := 0
+		for _, file := range agent.catalog.get().files["v1.27"] {
+			cached += len(file.objects)
+		}
+		require.Len(t, objects, cached, "objects injected with %q", value)
+		for _, obj := range objects {
+			switch o := obj.(type) {
+			case *appsv1.Deployment:
+				container := o.Spec.Template.Spec.Containers[0]
+				require.Equal(t, value, container.Image)
+				require.Nil(t, container.SecurityContext.Privileged)
+				if o.Name == "catalog-operator" {
+					require.Equal(t, value, argValue(container.Args, "--util-image"))
+					require.Equal(t, value, argValue(container.Args, "--configmapServerImage"))
+				}
+			case *olmv1alpha1.ClusterServiceVersion:
+				container := o.Spec.InstallStrategy.StrategySpec.DeploymentSpecs[0].Spec.Template.Spec.Containers[0]
+				require.Equal(t, value, container.Image)
+				require.Nil(t, container.SecurityContext.Privileged)
+			}
+		}
+	}
+}
+
+func TestTemplateRenderingErrors(t *testing.T) {
+	manifests := fstest.MapFS{
+		"v1.27/olm.yaml": {Data: []byte("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: {{ .Unknown }}\n")},
+	}
+	_, err := loadManifestFile(manifests, manifestSet{dir: "v1.27"}, "olm.yaml")
+	require.ErrorContains(t, err, "Unknown")
+}
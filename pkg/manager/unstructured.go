@@ -0,0 +1,76 @@
+package manager
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"k8s.io/klog/v2"
+
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+)
+
+// setUnstructuredConfiguration replaces the node selector, tolerations and images
+// in all the pod templates of an object whose type is not registered in the scheme.
+func setUnstructuredConfiguration(obj *unstructured.Unstructured, config addonfactory.Values) {
+	templates := findPodTemplates(obj.Object)
+	if len(templates) == 0 {
+		return
+	}
+	klog.V(6).InfoS("Configuring pod templates of unstructured object", "kind", obj.GetKind(),
+		"namespace", obj.GetNamespace(), "name", obj.GetName(), "templates", len(templates))
+	for _, template := range templates {
+		spec := template["spec"].(map[string]interface{})
+		if nodeSelector, ok := config["NodeSelector"]; ok {
+			selector := map[string]interface{}{}
+			for k, v := range nodeSelector.(map[string]string) {
+				selector[k] = v
+			}
+			spec["nodeSelector"] = selector
+		}
+		if tolerations, ok := config["Tolerations"]; ok {
+			values := []interface{}{}
+			for _, toleration := range tolerations.([]corev1.Toleration) {
+				toleration := toleration
+				value, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&toleration)
+				if err != nil {
+					klog.ErrorS(err, "Not able to convert toleration", "kind", obj.GetKind(), "name", obj.GetName())
+					continue
+				}
+				values = append(values, value)
+			}
+			spec["tolerations"] = values
+		}
+		if img, ok := config["OLMImage"]; ok {
+			for _, container := range spec["containers"].([]interface{}) {
+				if c, ok := container.(map[string]interface{}); ok {
+					c["image"] = img.(string)
+				}
+			}
+		}
+	}
+}
+
+// findPodTemplates walks the object and returns the pod templates it contains, e.g.
+// spec.template for workloads, spec.jobTemplate.spec.template for cron jobs or
+// spec.install.spec.deployments[*].spec.template for cluster service versions.
+// A pod template is identified as a map with a spec containing a list of containers.
+func findPodTemplates(value interface{}) []map[string]interface{} {
+	templates := []map[string]interface{}{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if spec, ok := v["spec"].(map[string]interface{}); ok {
+			if _, ok := spec["containers"].([]interface{}); ok {
+				return append(templates, v)
+			}
+		}
+		for _, field := range v {
+			templates = append(templates, findPodTemplates(field)...)
+		}
+	case []interface{}:
+		for _, item := range v {
+			templates = append(templates, findPodTemplates(item)...)
+		}
+	}
+	return templates
+}
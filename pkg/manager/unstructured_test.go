@@ -0,0 +1,83 @@
+package manager
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"open-cluster-management.io/addon-framework/pkg/addonfactory"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetUnstructuredConfiguration(t *testing.T) {
+	manifests := `apiVersion: example.com/v1
+kind: Workload
+metadata:
+  name: test
+  namespace: olm
+spec:
+  workers:
+  - name: first
+    template:
+      spec:
+        containers:
+        - name: worker
+          image: quay.io/example/worker:v1
+  schedule:
+    jobTemplate:
+      spec:
+        template:
+          spec:
+            containers:
+            - name: job
+              image: quay.io/example/job:v1
+---
+apiVersion: example.com/v1
+kind: Schema
+metadata:
+  name: schema
+spec:
+  properties:
+    spec:
+      properties:
+        containers:
+          type: array
+`
+	objects, err := decodeManifests("test.yaml", []byte(manifests))
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+	config := addonfactory.Values{
+		"OLMImage":     testOLMImage,
+		"NodeSelector": map[string]string{testNodeSelectorKey: testNodeSelectorVal},
+		"Tolerations": []corev1.Toleration{{
+			Key:      "node-role.kubernetes.io/infra",
+			Operator: corev1.TolerationOpExists,
+			Effect:   corev1.TaintEffectNoSchedule,
+		}},
+	}
+	for _, obj := range objects {
+		require.NoError(t, setConfiguration(obj, config))
+	}
+
+	workload := objects[0].(*unstructured.Unstructured)
+	templates := findPodTemplates(workload.Object)
+	require.Len(t, templates, 2)
+	for _, template := range templates {
+		image, _, _ := unstructured.NestedSlice(template, "spec", "containers")
+		require.Equal(t, testOLMImage, image[0].(map[string]interface{})["image"])
+		nodeSelector, _, _ := unstructured.NestedStringMap(template, "spec", "nodeSelector")
+		require.Equal(t, map[string]string{testNodeSelectorKey: testNodeSelectorVal}, nodeSelector)
+		tolerations, _, _ := unstructured.NestedSlice(template, "spec", "tolerations")
+		require.Equal(t, []interface{}{map[string]interface{}{
+			"key":      "node-role.kubernetes.io/infra",
+			"operator": "Exists",
+			"effect":   "NoSchedule",
+		}}, tolerations)
+	}
+
+	schema := objects[1].(*unstructured.Unstructured)
+	require.Empty(t, findPodTemplates(schema.Object))
+	_, found, _ := unstructured.NestedFieldNoCopy(schema.Object, "spec", "properties", "spec", "nodeSelector")
+	require.False(t, found)
+}
@@ -0,0 +1,161 @@
+package manager
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// VersionPolicy defines how a manifest set gets selected when none matches
+// the Kubernetes minor version of a managed cluster.
+type VersionPolicy string
+
+const (
+	// NearestLowerPolicy selects the closest manifest set below the cluster version,
+	// or the lowest available one if the cluster is older than all of them.
+	NearestLowerPolicy VersionPolicy = "nearest-lower"
+	// NearestHigherPolicy selects the closest manifest set above the cluster version,
+	// or the highest available one if the cluster is newer than all of them.
+	NearestHigherPolicy VersionPolicy = "nearest-higher"
+	// RefusePolicy does not deploy anything when the cluster version is not supported by any manifest set.
+	RefusePolicy VersionPolicy = "refuse"
+)
+
+// Reasons reported when a manifest set has been selected for a cluster.
+const (
+	ReasonSupportedVersion = "SupportedKubernetesVersion"
+	ReasonNearestLower     = "NearestLower"
+	ReasonNearestHigher    = "NearestHigher"
+	ReasonUnsupported      = "UnsupportedKubernetesVersion"
+)
+
+// ParseVersionPolicy validates the policy name provided by users.
+func ParseVersionPolicy(policy string) (VersionPolicy, error) {
+	switch p := VersionPolicy(strings.ToLower(policy)); p {
+	case NearestLowerPolicy, NearestHigherPolicy, RefusePolicy:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown version policy %q, supported values are: %s, %s, %s",
+			policy, NearestLowerPolicy, NearestHigherPolicy, RefusePolicy)
+	}
+}
+
+// manifestSet is a directory of manifests for a range of Kubernetes minor versions.
+type manifestSet struct {
+	version *version.Version
+	dir     string
+	// minVersion and maxVersion default to version when not defined in the compatibility matrix
+	minVersion *version.Version
+	maxVersion *version.Version
+	// olmVersion is the OLM release of the manifest set as declared in the compatibility matrix
+	olmVersion string
+	// images are the images of the OLM release, used as default values when rendering the manifest templates
+	images releaseImages
+	// leaderElection is whether the OLM and catalog operators of the release support leader election
+	leaderElection bool
+	// namespaces are the namespaces the manifests get rendered into, the upstream ones when not set
+	namespaces Namespaces
+}
+
+// Name returns the name of the manifest set as found in the manifests directory, e.g. v1.25.
+func (s manifestSet) Name() string {
+	return path.Base(s.dir)
+}
+
+// versionResolver maps Kubernetes versions to the manifest sets available.
+type versionResolver struct {
+	// sets are sorted by ascending version
+	sets   []manifestSet
+	policy VersionPolicy
+}
+
+// newVersionResolver enumerates the manifest sets available
+// and builds a compatibility table sorted by version.
+func newVersionResolver(manifests fs.FS, policy VersionPolicy) (*versionResolver, error) {
+	entries, err := fs.ReadDir(manifests, ".")
+	if err != nil {
+		return nil, err
+	}
+	sets := []manifestSet{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		v, err := version.ParseGeneric(entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("manifest directory %q does not match a Kubernetes version: %w", entry.Name(), err)
+		}
+		sets = append(sets, manifestSet{version: v, dir: entry.Name(), minVersion: v, maxVersion: v})
+	}
+	if len(sets) == 0 {
+		return nil, fmt.Errorf("no manifest set found")
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].version.LessThan(sets[j].version)
+	})
+	return &versionResolver{sets: sets, policy: policy}, nil
+}
+
+// resolve returns the manifest set to use for the provided Kubernetes version
+// together with the reason for the selection.
+func (r *versionResolver) resolve(kubeVersion *version.Version) (manifestSet, string, error) {
+	lower, higher := -1, -1
+	for i, set := range r.sets {
+		belowMax := sameMinorOrLess(kubeVersion, set.maxVersion)
+		aboveMin := sameMinorOrLess(set.minVersion, kubeVersion)
+		if belowMax && aboveMin {
+			return set, ReasonSupportedVersion, nil
+		}
+		if !belowMax {
+			lower = i
+		} else if higher == -1 {
+			higher = i
+		}
+	}
+	switch r.policy {
+	case NearestLowerPolicy:
+		if lower != -1 {
+			return r.sets[lower], ReasonNearestLower, nil
+		}
+		return r.sets[higher], ReasonNearestHigher, nil
+	case NearestHigherPolicy:
+		if higher != -1 {
+			return r.sets[higher], ReasonNearestHigher, nil
+		}
+		return r.sets[lower], ReasonNearestLower, nil
+	default:
+		return manifestSet{}, ReasonUnsupported, fmt.Errorf("no manifest set for Kubernetes v%d.%d, supported versions are: %s",
+			kubeVersion.Major(), kubeVersion.Minor(), strings.Join(r.names(), ", "))
+	}
+}
+
+// get returns the manifest set of the provided name.
+func (r *versionResolver) get(name string) (manifestSet, bool) {
+	for _, set := range r.sets {
+		if set.Name() == name {
+			return set, true
+		}
+	}
+	return manifestSet{}, false
+}
+
+// names returns the names of the available manifest sets.
+func (r *versionResolver) names() []string {
+	names := make([]string, 0, len(r.sets))
+	for _, set := range r.sets {
+		names = append(names, set.Name())
+	}
+	return names
+}
+
+// sameMinorOrLess compares versions on their major and minor components only.
+func sameMinorOrLess(a, b *version.Version) bool {
+	if a.Major() != b.Major() {
+		return a.Major() < b.Major()
+	}
+	return a.Minor() <= b.Minor()
+}
@@ -0,0 +1,58 @@
+package manager
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"k8s.io/apimachinery/pkg/util/version"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveManifestSet(t *testing.T) {
+	manifests := fstest.MapFS{
+		"v1.23/olm.yaml": &fstest.MapFile{},
+		"v1.25/olm.yaml": &fstest.MapFile{},
+		"v1.27/olm.yaml": &fstest.MapFile{},
+	}
+	tests := []struct {
+		name        string
+		policy      VersionPolicy
+		kubeVersion string
+		expectedSet string
+		reason      string
+		expectError bool
+	}{
+		{"supported", NearestLowerPolicy, "v1.25.3", "v1.25", ReasonSupportedVersion, false},
+		{"supported with refuse", RefusePolicy, "v1.27.0+k3s1", "v1.27", ReasonSupportedVersion, false},
+		{"lower in between", NearestLowerPolicy, "v1.24.1", "v1.23", ReasonNearestLower, false},
+		{"higher in between", NearestHigherPolicy, "v1.24.1", "v1.25", ReasonNearestHigher, false},
+		{"lower above all", NearestLowerPolicy, "v1.30.2", "v1.27", ReasonNearestLower, false},
+		{"lower below all", NearestLowerPolicy, "v1.22.0", "v1.23", ReasonNearestHigher, false},
+		{"higher above all", NearestHigherPolicy, "v1.30.2", "v1.27", ReasonNearestLower, false},
+		{"refuse", RefusePolicy, "v1.28.0", "", ReasonUnsupported, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, err := newVersionResolver(manifests, tt.policy)
+			require.NoError(t, err)
+			require.Equal(t, []string{"v1.23", "v1.25", "v1.27"}, resolver.names())
+			set, reason, err := resolver.resolve(version.MustParseSemantic(tt.kubeVersion))
+			require.Equal(t, tt.reason, reason)
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedSet, set.Name())
+		})
+	}
+}
+
+func TestParseVersionPolicy(t *testing.T) {
+	policy, err := ParseVersionPolicy("Nearest-Higher")
+	require.NoError(t, err)
+	require.Equal(t, NearestHigherPolicy, policy)
+	_, err = ParseVersionPolicy("latest")
+	require.Error(t, err)
+}